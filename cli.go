@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/zoundwavedj/cybersecurity/configs"
+	"github.com/zoundwavedj/cybersecurity/database"
+	"github.com/zoundwavedj/cybersecurity/handlers"
+	"github.com/zoundwavedj/cybersecurity/repositories"
+	"github.com/zoundwavedj/cybersecurity/utils"
+)
+
+const (
+	indexBatchSize     = 100
+	auditBatchSize     = 1000
+	reencryptBatchSize = 100
+	reencryptJob       = "reencrypt_users"
+)
+
+const usage = `Usage:
+  cybersecurity                     start the server
+  cybersecurity migrate up          apply pending migrations
+  cybersecurity migrate down [n]    roll back the latest n migrations (default 1)
+  cybersecurity migrate status      list migrations and whether they're applied
+  cybersecurity index users         compute missing SSN and email blind indexes
+  cybersecurity kms rotate          add a new version to the local KMS key, older versions keep decrypting
+  cybersecurity reencrypt users [--all] [--restart] [--batch n]
+                                    encrypt plaintext fields and re-encrypt ones not bound to their record
+                                    or not wrapped by the active key, resuming where an interrupted run
+                                    stopped. --all also re-encrypts up to date users
+  cybersecurity audit verify        check the audit log hash chain end to end
+`
+
+// runCommand function to handle CLI subcommands, returns the process exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "migrate":
+		return migrateCommand(args[1:])
+	case "index":
+		return indexCommand(args[1:])
+	case "kms":
+		return kmsCommand(args[1:])
+	case "reencrypt":
+		return reencryptCommand(args[1:])
+	case "audit":
+		return auditCommand(args[1:])
+	}
+
+	fmt.Fprint(os.Stderr, usage)
+
+	return 2
+}
+
+func migrateCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	database.Open()
+	defer database.Db.Close()
+
+	switch args[0] {
+	case "up":
+		count, err := database.Migrate()
+		if err != nil {
+			log.Err(err).Msg("")
+			return 1
+		}
+
+		log.Info().Int("applied", count).Msg("Migrations up to date")
+	case "down":
+		steps := 1
+
+		if len(args) > 1 {
+			var err error
+
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				fmt.Fprint(os.Stderr, usage)
+				return 2
+			}
+		}
+
+		count, err := database.Rollback(steps)
+		if err != nil {
+			log.Err(err).Msg("")
+			return 1
+		}
+
+		log.Info().Int("reverted", count).Msg("Rollback finished")
+	case "status":
+		applied, err := database.AppliedMigrations()
+		if err != nil {
+			log.Err(err).Msg("")
+			return 1
+		}
+
+		appliedAt := map[int]int64{}
+		for _, state := range applied {
+			appliedAt[state.Version] = state.AppliedAt
+		}
+
+		for _, migration := range database.Migrations() {
+			status := "pending"
+			if at, ok := appliedAt[migration.Version]; ok {
+				status = "applied " + time.Unix(at, 0).Format(time.RFC3339)
+			}
+
+			fmt.Printf("%4d  %-30s %s\n", migration.Version, migration.Name, status)
+		}
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	return 0
+}
+
+// indexCommand backfills blind indexes for users created before they existed. Users sharing an SSN are reported and left unindexed
+func indexCommand(args []string) int {
+	if len(args) != 1 || args[0] != "users" {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	if err := utils.SetupKeyProvider(); err != nil {
+		log.Err(err).Msg("")
+		return 1
+	}
+
+	database.Open()
+	defer database.Db.Close()
+
+	var (
+		ctx                        = context.Background()
+		users                      = repositories.NewSQLUserRepository(database.Db, database.Driver)
+		afterID                    string
+		indexed, duplicates, total int
+	)
+
+	for {
+		batch, err := users.ListUnindexed(ctx, afterID, indexBatchSize)
+		if err != nil {
+			log.Err(err).Msg("")
+			return 1
+		}
+
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, user := range batch {
+			afterID = user.ID
+			total++
+
+			if err = handlers.IndexUser(user); err != nil {
+				log.Err(err).Msg("")
+				return 1
+			}
+
+			if err = users.UpdateIndexes(ctx, user); err != nil {
+				if err == repositories.ErrConflict {
+					log.Warn().Str("userId", user.ID).Msg("SSN already indexed for another user, left unindexed")
+					duplicates++
+					continue
+				}
+
+				log.Err(err).Msg("")
+				return 1
+			}
+
+			indexed++
+		}
+	}
+
+	log.Info().Int("scanned", total).Int("indexed", indexed).Int("duplicates", duplicates).Msg("Blind indexes up to date")
+
+	if duplicates > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// kmsCommand manages the local KMS stand-in, a managed KMS would be rotated through its own tooling
+func kmsCommand(args []string) int {
+	if len(args) != 1 || args[0] != "rotate" {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	kms, err := utils.OpenLocalKMS(utils.KMSKeystore())
+	if err != nil {
+		log.Err(err).Msg("")
+		return 1
+	}
+
+	version, err := kms.RotateKey(utils.KMSKeyID())
+	if err != nil {
+		log.Err(err).Msg("")
+		return 1
+	}
+
+	log.Info().Int("version", version).Msg("KMS key rotated")
+
+	return 0
+}
+
+// reencryptCommand moves every encrypted user field onto the active keys in batches, checkpointing after each batch so an interrupted run resumes
+func reencryptCommand(args []string) int {
+	flags := flag.NewFlagSet("reencrypt", flag.ContinueOnError)
+	all := flags.Bool("all", false, "re-encrypt users already encrypted under the active keys too")
+	restart := flags.Bool("restart", false, "ignore the checkpoint of an interrupted run")
+	batchSize := flags.Int("batch", reencryptBatchSize, "users per batch")
+
+	if len(args) == 0 || args[0] != "users" || flags.Parse(args[1:]) != nil || *batchSize < 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	if err := utils.SetupKeyProvider(); err != nil {
+		log.Err(err).Msg("")
+		return 1
+	}
+
+	database.Open()
+	defer database.Db.Close()
+
+	var (
+		ctx         = context.Background()
+		users       = repositories.NewSQLUserRepository(database.Db, database.Driver)
+		checkpoints = repositories.NewSQLCheckpointRepository(database.Db, database.Driver)
+		checkpoint  = &repositories.Checkpoint{Job: reencryptJob}
+		rotated     int
+		changed     int
+	)
+
+	if *restart {
+		if err := checkpoints.Clear(ctx, reencryptJob); err != nil {
+			log.Err(err).Msg("")
+			return 1
+		}
+	} else if saved, err := checkpoints.Load(ctx, reencryptJob); err == nil {
+		checkpoint = saved
+		log.Info().Str("after", saved.Position).Int64("processed", saved.Processed).Msg("Resuming interrupted re-encryption")
+	} else if err != repositories.ErrNotFound {
+		log.Err(err).Msg("")
+		return 1
+	}
+
+	total, err := users.Count(ctx)
+	if err != nil {
+		log.Err(err).Msg("")
+		return 1
+	}
+
+	for {
+		batch, err := users.ListStoredAfter(ctx, checkpoint.Position, *batchSize)
+		if err != nil {
+			log.Err(err).Msg("")
+			return 1
+		}
+
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, user := range batch {
+			if *all || utils.FieldsNeedRotation(user) {
+				// A user updated since the batch was read already carries fresh ciphertexts
+				switch err = users.Reencrypt(ctx, user); err {
+				case nil:
+					rotated++
+				case repositories.ErrNotFound:
+					changed++
+				default:
+					log.Err(err).Str("userId", user.ID).Msg("Can't re-encrypt user, run stopped")
+					return 1
+				}
+			}
+
+			checkpoint.Position = user.ID
+			checkpoint.Processed++
+		}
+
+		checkpoint.UpdatedAt = time.Now().Unix()
+
+		if err = checkpoints.Save(ctx, checkpoint); err != nil {
+			log.Err(err).Msg("")
+			return 1
+		}
+
+		percent := 100.0
+		if total > 0 {
+			percent = float64(checkpoint.Processed) * 100 / float64(total)
+		}
+
+		log.Info().
+			Int64("processed", checkpoint.Processed).
+			Int64("total", total).
+			Str("progress", strconv.FormatFloat(percent, 'f', 1, 64)+"%").
+			Int("reencrypted", rotated).
+			Msg("Batch re-encrypted")
+	}
+
+	if err = checkpoints.Clear(ctx, reencryptJob); err != nil {
+		log.Err(err).Msg("")
+		return 1
+	}
+
+	log.Info().Int64("processed", checkpoint.Processed).Int("reencrypted", rotated).Int("changedConcurrently", changed).Str("key", utils.Keys.Active()).Msg("Re-encryption finished")
+
+	return 0
+}
+
+// auditCommand walks the audit log in append order, checking each event links to the one before and still hashes to its stored hash
+// under AUDIT_HMAC_KEY. Truncating the end of the log can't be detected from the log itself, compare the reported head against a copy kept elsewhere
+func auditCommand(args []string) int {
+	if len(args) != 1 || args[0] != "verify" {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	key, err := configs.AuditKey()
+	if err != nil {
+		log.Err(err).Msg("")
+		return 1
+	}
+
+	database.Open()
+	defer database.Db.Close()
+
+	var (
+		ctx      = context.Background()
+		audit    = repositories.NewSQLAuditRepository(database.Db, database.Driver, key)
+		head     string
+		afterSeq int64
+		verified int64
+	)
+
+	for {
+		batch, err := audit.List(ctx, repositories.AuditQuery{AfterSeq: afterSeq, Limit: auditBatchSize})
+		if err != nil {
+			log.Err(err).Msg("")
+			return 1
+		}
+
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, event := range batch {
+			if event.PrevHash != head {
+				log.Error().Int64("seq", event.Seq).Int64("verified", verified).Msg("Audit log broken, event doesn't link to the one before")
+				return 1
+			}
+
+			if repositories.AuditHash(key, event) != event.Hash {
+				log.Error().Int64("seq", event.Seq).Int64("verified", verified).Msg("Audit log broken, event was modified")
+				return 1
+			}
+
+			head = event.Hash
+			afterSeq = event.Seq
+			verified++
+		}
+	}
+
+	log.Info().Int64("events", verified).Str("head", head).Msg("Audit log intact")
+
+	return 0
+}
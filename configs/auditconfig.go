@@ -0,0 +1,96 @@
+package configs
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/zoundwavedj/cybersecurity/repositories"
+)
+
+const (
+	// AuditLogin action
+	AuditLogin = "auth.login"
+	// AuditLogout action
+	AuditLogout = "auth.logout"
+	// AuditRefresh action
+	AuditRefresh = "auth.refresh"
+	// AuditSuperuserBootstrap action
+	AuditSuperuserBootstrap = "superuser.bootstrap"
+	// AuditUsersCreate action
+	AuditUsersCreate = "users.create"
+	// AuditUsersRead action
+	AuditUsersRead = "users.read"
+	// AuditUsersList action
+	AuditUsersList = "users.list"
+	// AuditUsersUpdate action
+	AuditUsersUpdate = "users.update"
+	// AuditUsersDelete action
+	AuditUsersDelete = "users.delete"
+	// AuditUsersRestore action
+	AuditUsersRestore = "users.restore"
+	// AuditOperatorsCreate action
+	AuditOperatorsCreate = "operators.create"
+	// AuditOperatorsList action
+	AuditOperatorsList = "operators.list"
+	// AuditOperatorsDisable action
+	AuditOperatorsDisable = "operators.disable"
+	// AuditOperatorsDelete action
+	AuditOperatorsDelete = "operators.delete"
+	// AuditLockoutsUnlock action
+	AuditLockoutsUnlock = "lockouts.unlock"
+	// AuditKeysReload action
+	AuditKeysReload = "keys.reload"
+	// AuditLogRead action
+	AuditLogRead = "audit.read"
+)
+
+const (
+	// AuditSuccess outcome
+	AuditSuccess = "success"
+	// AuditFailure outcome
+	AuditFailure = "failure"
+	// AuditDenied outcome, the caller wasn't authorized
+	AuditDenied = "denied"
+)
+
+const auditEventContextKey contextKey = "auditEvent"
+
+// ErrAuditKey error
+var ErrAuditKey = errors.New("AUDIT_HMAC_KEY must be a hex encoded key of at least 32 bytes")
+
+// AuditKey function to read the key of the audit log's hash chain from the AUDIT_HMAC_KEY env var. It must never be stored
+// in the database, anyone holding it could rewrite the log and recompute the chain
+func AuditKey() ([]byte, error) {
+	key, err := hex.DecodeString(os.Getenv("AUDIT_HMAC_KEY"))
+	if err != nil || len(key) < 32 {
+		return nil, ErrAuditKey
+	}
+
+	return key, nil
+}
+
+// WithAuditEvent function to attach the audit event being recorded for a request to its context
+func WithAuditEvent(ctx context.Context, event *repositories.AuditEvent) context.Context {
+	return context.WithValue(ctx, auditEventContextKey, event)
+}
+
+// AuditEventFromContext function to retrieve the event attached by WithAuditEvent, handlers fill in what only they know
+func AuditEventFromContext(ctx context.Context) (*repositories.AuditEvent, bool) {
+	event, ok := ctx.Value(auditEventContextKey).(*repositories.AuditEvent)
+
+	return event, ok
+}
+
+// ClientIP function to get the IP a request came from, without its port
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,58 @@
+package configs
+
+import (
+	"crypto/ed25519"
+	"errors"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// SigningMethodEdDSA type implementing Ed25519 signatures, which jwt-go v3 doesn't ship with
+type SigningMethodEdDSA struct{}
+
+var (
+	// SigningMethodEd25519 instance registered under the EdDSA alg
+	SigningMethodEd25519 = &SigningMethodEdDSA{}
+	// ErrEdDSAVerification error
+	ErrEdDSAVerification = errors.New("EdDSA verification failed")
+)
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEd25519.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEd25519
+	})
+}
+
+// Alg function returning the JWA name
+func (m *SigningMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+// Sign function expecting an ed25519.PrivateKey
+func (m *SigningMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+// Verify function expecting an ed25519.PublicKey
+func (m *SigningMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return ErrEdDSAVerification
+	}
+
+	return nil
+}
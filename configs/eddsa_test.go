@@ -0,0 +1,54 @@
+package configs
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestEdDSA(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature, err := SigningMethodEd25519.Sign("header.payload", private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = SigningMethodEd25519.Verify("header.payload", signature, public); err != nil {
+		t.Errorf("Verify of a fresh signature returned %v", err)
+	}
+
+	if err = SigningMethodEd25519.Verify("header.payload2", signature, public); err != ErrEdDSAVerification {
+		t.Errorf("Verify of another signing string returned %v, want %v", err, ErrEdDSAVerification)
+	}
+
+	otherPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = SigningMethodEd25519.Verify("header.payload", signature, otherPublic); err != ErrEdDSAVerification {
+		t.Errorf("Verify with another public key returned %v, want %v", err, ErrEdDSAVerification)
+	}
+
+	if _, err = SigningMethodEd25519.Sign("header.payload", []byte("secret")); err != jwt.ErrInvalidKeyType {
+		t.Errorf("Sign with an HMAC secret returned %v, want %v", err, jwt.ErrInvalidKeyType)
+	}
+
+	if err = SigningMethodEd25519.Verify("header.payload", signature, []byte("secret")); err != jwt.ErrInvalidKeyType {
+		t.Errorf("Verify with an HMAC secret returned %v, want %v", err, jwt.ErrInvalidKeyType)
+	}
+
+	if err = SigningMethodEd25519.Verify("header.payload", "!"+signature, public); err == nil {
+		t.Error("Verify of a signature that isn't base64url succeeded, want an error")
+	}
+
+	if method := jwt.GetSigningMethod("EdDSA"); method != SigningMethodEd25519 {
+		t.Errorf("EdDSA is registered as %v, want %v", method, SigningMethodEd25519)
+	}
+}
@@ -0,0 +1,102 @@
+package configs
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestPublicJWKS(t *testing.T) {
+	useTestKeyrings(t)
+
+	var keys []*SigningKey
+
+	for alg, private := range testPrivateKeys(t) {
+		key, err := NewAsymmetricSigningKey("", alg, encodePKCS8(t, private))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	hmac := NewHMACSigningKey("hmac", []byte("secret"))
+	hmac.Active = true
+
+	if err := accessKeys.Replace(append(keys, hmac)); err != nil {
+		t.Fatal(err)
+	}
+
+	jwks := PublicJWKS()
+	if len(jwks.Keys) != len(keys) {
+		t.Fatalf("PublicJWKS listed %d keys, want the %d asymmetric ones and never the HMAC secret", len(jwks.Keys), len(keys))
+	}
+
+	for i, jwk := range jwks.Keys {
+		if jwk.Kid != keys[i].ID || jwk.Alg != keys[i].Method.Alg() || jwk.Use != "sig" {
+			t.Errorf("JWK %d is %+v, want kid %s, alg %s and use sig", i, jwk, keys[i].ID, keys[i].Method.Alg())
+		}
+
+		if strings.Contains(jwk.X+jwk.Y+jwk.N, "=") {
+			t.Errorf("JWK %d is %+v, want unpadded base64url members", i, jwk)
+		}
+	}
+}
+
+func TestThumbprint(t *testing.T) {
+	// RFC 7638 section 3.1
+	n, err := jwt.DecodeSegment("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaKey := &rsa.PublicKey{E: 65537}
+	rsaKey.N = new(big.Int).SetBytes(n)
+
+	// RFC 8037 appendix A.3
+	x, err := jwt.DecodeSegment("11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		public interface{}
+		want   string
+	}{
+		{"RSA", rsaKey, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"},
+		{"Ed25519", ed25519.PublicKey(x), "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k"},
+	}
+
+	for _, test := range tests {
+		if got, err := Thumbprint(test.public); err != nil || got != test.want {
+			t.Errorf("Thumbprint of the %s key = %q, %v, want %q", test.name, got, err, test.want)
+		}
+	}
+
+	if _, err = Thumbprint([]byte("secret")); err != ErrUnsupportedKey {
+		t.Errorf("Thumbprint of an HMAC secret returned %v, want %v", err, ErrUnsupportedKey)
+	}
+
+	// EC coordinates are always encoded at the full curve size, as RFC 7518 requires
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwk, err := publicJWK(&ecKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if x, _ := jwt.DecodeSegment(jwk.X); len(x) != 32 || jwk.Crv != "P-256" {
+		t.Errorf("P-256 JWK is %+v, want a 32 byte x on P-256", jwk)
+	}
+}
@@ -7,13 +7,16 @@ import (
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
-	"github.com/zoundwavedj/cybersecurity/database"
+	"github.com/rs/zerolog/log"
+	"github.com/zoundwavedj/cybersecurity/stores"
 )
 
 // Claims type
 type Claims struct {
-	AccessID  string `json:"accessId,omitempty"`
-	RefreshID string `json:"refreshId,omitempty"`
+	AccessID    string       `json:"accessId,omitempty"`
+	RefreshID   string       `json:"refreshId,omitempty"`
+	Role        string       `json:"role,omitempty"`
+	Permissions []Permission `json:"perms,omitempty"`
 	jwt.StandardClaims
 }
 
@@ -27,166 +30,292 @@ const (
 	REFRESH SecretType = "REFRESH"
 )
 
+const (
+	// STATEFUL access token mode, every access token is looked up in the token store
+	STATEFUL = "stateful"
+	// STATELESS access token mode, access tokens are checked by signature, claims and the denylist only
+	STATELESS = "stateless"
+)
+
 var (
 	// ErrTokenMissing error
 	ErrTokenMissing = errors.New("Token missing")
 	// ErrTokenExpired error
 	ErrTokenExpired = errors.New("Token expired")
-	accessSecret    = []byte(os.Getenv("ACCESS_SECRET"))
-	refreshSecret   = []byte(os.Getenv("REFRESH_SECRET"))
+	// ErrTokenReused error
+	ErrTokenReused = errors.New("Token reused, please login again")
+	// ErrTokenNotYetValid error
+	ErrTokenNotYetValid = errors.New("Token not yet valid")
+	// ErrTokenInvalidIssuer error
+	ErrTokenInvalidIssuer = errors.New("Token issuer is invalid")
+	// ErrTokenInvalidAudience error
+	ErrTokenInvalidAudience = errors.New("Token audience is invalid")
+	// ErrTokenRevoked error
+	ErrTokenRevoked = errors.New("Token revoked")
+	// ErrTokenInvalidClaims error
+	ErrTokenInvalidClaims = errors.New("Token claims are invalid")
+	accessSecret          = []byte(os.Getenv("ACCESS_SECRET"))
+	refreshSecret         = []byte(os.Getenv("REFRESH_SECRET"))
+	issuer                = envOrDefault("JWT_ISSUER", "cybersecurity")
+	audience              = envOrDefault("JWT_AUDIENCE", "cybersecurity")
+	clockSkew             = durationOrDefault("JWT_CLOCK_SKEW", time.Second*5)
+	accessTokenMode       = envOrDefault("ACCESS_TOKEN_MODE", STATEFUL)
+	denylist              *stores.Denylist
+)
+
+const (
+	accessTokenTTL  = time.Second * 30
+	refreshTokenTTL = time.Minute * 5
 )
 
-// GenerateAccessToken function to build access token given ID
-func GenerateAccessToken() (string, int64, string, error) {
+// Valid function to check registered claims, allowing for clock skew between servers
+func (c *Claims) Valid() error {
+	now := time.Now()
+
+	if !c.VerifyExpiresAt(now.Add(-clockSkew).Unix(), true) {
+		return ErrTokenExpired
+	}
+
+	if !c.VerifyNotBefore(now.Add(clockSkew).Unix(), true) || !c.VerifyIssuedAt(now.Add(clockSkew).Unix(), true) {
+		return ErrTokenNotYetValid
+	}
+
+	if !c.VerifyIssuer(issuer, true) {
+		return ErrTokenInvalidIssuer
+	}
+
+	if !c.VerifyAudience(audience, true) {
+		return ErrTokenInvalidAudience
+	}
+
+	if c.Subject == "" || c.Id == "" {
+		return ErrTokenInvalidClaims
+	}
+
+	return nil
+}
+
+// GenerateAccessToken function to build access token given user ID and role, the role's permissions are embedded in the token
+func GenerateAccessToken(userID string, role string) (string, int64, string, error) {
 	uuid := uuid.New().String()
-	expiresAt := time.Now().Add(time.Second * 30).Unix()
+	now := time.Now()
+	expiresAt := now.Add(accessTokenTTL).Unix()
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS512, &Claims{
-		AccessID: uuid,
-	})
+	key, err := accessKeys.Active()
+	if err != nil {
+		return "", 0, "", err
+	}
 
-	signedToken, err := token.SignedString(accessSecret)
+	signedToken, err := key.Sign(&Claims{
+		AccessID:       uuid,
+		Role:           role,
+		Permissions:    RolePermissions(role),
+		StandardClaims: standardClaims(uuid, userID, now, expiresAt),
+	})
 
 	return uuid, expiresAt, signedToken, err
 }
 
-// GenerateRefreshToken function to build refresh token
-func GenerateRefreshToken() (string, int64, string, error) {
+// GenerateRefreshToken function to build refresh token given user ID
+func GenerateRefreshToken(userID string) (string, int64, string, error) {
 	uuid := uuid.New().String()
-	expiresAt := time.Now().Add(time.Minute * 5).Unix()
+	now := time.Now()
+	expiresAt := now.Add(refreshTokenTTL).Unix()
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS512, &Claims{
-		RefreshID: uuid,
-	})
+	key, err := refreshKeys.Active()
+	if err != nil {
+		return "", 0, "", err
+	}
 
-	signedToken, err := token.SignedString(refreshSecret)
+	signedToken, err := key.Sign(&Claims{
+		RefreshID:      uuid,
+		StandardClaims: standardClaims(uuid, userID, now, expiresAt),
+	})
 
 	return uuid, expiresAt, signedToken, err
 }
 
-// ValidateAccessToken function to verify JWT token
-func ValidateAccessToken(tokenString string) error {
-	token, err := ParseToken(tokenString, ACCESS)
+// StatelessAccessTokens function to check if ACCESS_TOKEN_MODE skips the token store for access tokens
+func StatelessAccessTokens() bool {
+	return accessTokenMode == STATELESS
+}
+
+// UseDenylist function to set the denylist consulted by stateless access token validation
+func UseDenylist(d *stores.Denylist) {
+	denylist = d
+}
+
+// ValidateAccessToken function to verify JWT token and return its claims
+func ValidateAccessToken(tokenString string) (*Claims, error) {
+	claims, err := ParseToken(tokenString, ACCESS)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		return err
+	// A refresh token verifying under a shared secret still can't pass for an access token
+	if claims.AccessID == "" || claims.AccessID != claims.Id || claims.RefreshID != "" {
+		return nil, ErrTokenInvalidClaims
 	}
 
-	accessID, ok := claims["accessId"].(string)
-	if !ok {
-		return err
+	if StatelessAccessTokens() {
+		if denylist != nil && denylist.Contains(claims.Id) {
+			return nil, ErrTokenRevoked
+		}
+
+		return claims, nil
 	}
 
-	rows, err := database.Db.Query("SELECT userId, expiresAt FROM authentication WHERE token=? LIMIT 1", accessID)
-	if err != nil {
-		return err
+	if _, err = lookupToken(claims); err != nil {
+		return nil, err
 	}
-	defer rows.Close()
 
-	if rows.Next() {
-		var (
-			userID    string
-			expiresAt int64
-		)
+	return claims, nil
+}
 
-		if err = rows.Scan(&userID, &expiresAt); err != nil {
-			return err
-		}
-		rows.Close()
+// ValidateRefreshToken function to verify JWT token, presenting an already rotated token revokes its whole family
+func ValidateRefreshToken(tokenString string) (*stores.Token, error) {
+	claims, err := ParseToken(tokenString, REFRESH)
+	if err != nil {
+		return nil, err
+	}
 
-		if time.Now().After(time.Unix(expiresAt, 0)) {
-			statement, err := database.Db.Prepare("DELETE FROM authentication WHERE token=?")
-			if err != nil {
-				return err
-			}
-			defer statement.Close()
+	if claims.RefreshID == "" || claims.RefreshID != claims.Id || claims.AccessID != "" {
+		return nil, ErrTokenInvalidClaims
+	}
 
-			if _, err = statement.Exec(accessID); err != nil {
-				return err
-			}
+	stored, err := lookupToken(claims)
+	if err != nil {
+		return nil, err
+	}
 
-			return ErrTokenExpired
-		}
-	} else {
-		return ErrTokenMissing
+	if stored.Rotated {
+		return nil, revokeReusedFamily(stored)
 	}
 
-	return nil
+	return stored, nil
 }
 
-// ValidateRefreshToken function to verify JWT token
-func ValidateRefreshToken(tokenString string) (string, string, error) {
-	token, err := ParseToken(tokenString, REFRESH)
-	if err != nil {
-		return "", "", err
-	}
+// RotateRefreshToken function to mark a validated refresh token as used so it can't be exchanged again
+func RotateRefreshToken(stored *stores.Token) error {
+	if err := stores.Tokens.Rotate(stored.Token); err != nil {
+		if err == stores.ErrTokenRotated {
+			return revokeReusedFamily(stored)
+		}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		return "", "", err
+		if err == stores.ErrTokenNotFound {
+			return ErrTokenMissing
+		}
+
+		return err
 	}
 
-	refreshID, ok := claims["refreshId"].(string)
-	if !ok {
-		return "", "", err
+	return nil
+}
+
+func standardClaims(id string, userID string, now time.Time, expiresAt int64) jwt.StandardClaims {
+	return jwt.StandardClaims{
+		Id:        id,
+		Subject:   userID,
+		Issuer:    issuer,
+		Audience:  audience,
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		ExpiresAt: expiresAt,
 	}
+}
 
-	rows, err := database.Db.Query("SELECT userId, expiresAt FROM authentication WHERE token=? LIMIT 1", refreshID)
+func revokeReusedFamily(stored *stores.Token) error {
+	revoked, err := stores.Tokens.RevokeFamily(stored.FamilyID)
 	if err != nil {
-		return "", "", err
+		return err
 	}
-	defer rows.Close()
 
-	var userID string
+	log.Warn().
+		Str("event", "refresh_token_reuse").
+		Str("userId", stored.UserID).
+		Str("familyId", stored.FamilyID).
+		Int64("revoked", revoked).
+		Msg("Rotated refresh token presented again, token family revoked")
 
-	if rows.Next() {
-		var expiresAt int64
+	return ErrTokenReused
+}
 
-		if err = rows.Scan(&userID, &expiresAt); err != nil {
-			return "", "", err
+func lookupToken(claims *Claims) (*stores.Token, error) {
+	stored, err := stores.Tokens.Lookup(claims.Id)
+	if err != nil {
+		if err == stores.ErrTokenNotFound {
+			return nil, ErrTokenMissing
 		}
-		rows.Close()
 
-		if time.Now().After(time.Unix(expiresAt, 0)) {
-			statement, err := database.Db.Prepare("DELETE FROM authentication WHERE token=?")
-			if err != nil {
-				return "", "", err
-			}
-			defer statement.Close()
+		return nil, err
+	}
 
-			if _, err = statement.Exec(refreshID); err != nil {
-				return "", "", err
-			}
+	if stored.UserID != claims.Subject {
+		return nil, ErrTokenMissing
+	}
 
-			return "", "", ErrTokenExpired
+	if time.Now().After(time.Unix(stored.ExpiresAt, 0)) {
+		if err = stores.Tokens.Revoke(stored.Token); err != nil {
+			return nil, err
 		}
-	} else {
-		return "", "", ErrTokenMissing
+
+		return nil, ErrTokenExpired
 	}
 
-	return userID, refreshID, nil
+	return stored, nil
 }
 
-// ParseToken function to parse a token string
-func ParseToken(token string, secretType SecretType) (*jwt.Token, error) {
-	parsed, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("Invalid signing method")
+// ParseToken function to parse a token string and validate its claims
+func ParseToken(token string, secretType SecretType) (*Claims, error) {
+	parsed, err := jwt.ParseWithClaims(token, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		keyring := refreshKeys
+		if secretType == ACCESS {
+			keyring = accessKeys
 		}
 
-		if secretType == ACCESS {
-			return accessSecret, nil
+		kid, _ := token.Header["kid"].(string)
+
+		key, err := keyring.Lookup(kid)
+		if err != nil {
+			return nil, err
 		}
 
-		return refreshSecret, nil
+		// Only the alg the key was configured with is accepted, so a public key can never be used as an HMAC secret
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, errors.New("Invalid signing method")
+		}
+
+		return key.Public, nil
 	})
 	if err != nil {
+		if validationErr, ok := err.(*jwt.ValidationError); ok && validationErr.Inner != nil {
+			return nil, validationErr.Inner
+		}
+
 		return nil, err
 	}
 
-	return parsed, nil
+	claims, ok := parsed.Claims.(*Claims)
+	if !ok || !parsed.Valid {
+		return nil, ErrTokenInvalidClaims
+	}
+
+	return claims, nil
+}
+
+func envOrDefault(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	return fallback
+}
+
+func durationOrDefault(key string, fallback time.Duration) time.Duration {
+	duration, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || duration < 0 {
+		return fallback
+	}
+
+	return duration
 }
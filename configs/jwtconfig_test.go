@@ -0,0 +1,229 @@
+package configs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/zoundwavedj/cybersecurity/stores"
+)
+
+// useTestKeyrings swaps in empty keyrings, a memory token store and a 10 second clock skew, and puts the previous ones back after the test
+func useTestKeyrings(t *testing.T) stores.TokenStore {
+	t.Helper()
+
+	previousAccess, previousRefresh, previousTokens, previousSkew, previousMode := accessKeys, refreshKeys, stores.Tokens, clockSkew, accessTokenMode
+	store := stores.NewMemoryTokenStore(time.Hour)
+
+	accessKeys, refreshKeys, stores.Tokens, clockSkew, accessTokenMode = NewKeyring(accessTokenTTL), NewKeyring(refreshTokenTTL), store, 10*time.Second, STATEFUL
+
+	t.Cleanup(func() {
+		store.Close()
+		accessKeys, refreshKeys, stores.Tokens, clockSkew, accessTokenMode = previousAccess, previousRefresh, previousTokens, previousSkew, previousMode
+	})
+
+	return store
+}
+
+// activeKey returns a keyring holding only key, as the active one
+func activeKey(t *testing.T, ttl time.Duration, key *SigningKey) *Keyring {
+	t.Helper()
+
+	key.Active = true
+
+	keyring := NewKeyring(ttl)
+	if err := keyring.Replace([]*SigningKey{key}); err != nil {
+		t.Fatal(err)
+	}
+
+	return keyring
+}
+
+func TestClaimsValid(t *testing.T) {
+	useTestKeyrings(t)
+
+	now := time.Now()
+	skew := int64(clockSkew / time.Second)
+
+	valid := func(change func(c *Claims)) *Claims {
+		c := &Claims{StandardClaims: standardClaims("t1", "u1", now, now.Add(time.Minute).Unix())}
+		change(c)
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		claims *Claims
+		want   error
+	}{
+		{"valid claims", valid(func(c *Claims) {}), nil},
+		{"exp within the skew", valid(func(c *Claims) { c.ExpiresAt = now.Unix() - skew + 2 }), nil},
+		{"exp past the skew", valid(func(c *Claims) { c.ExpiresAt = now.Unix() - skew - 2 }), ErrTokenExpired},
+		{"no exp", valid(func(c *Claims) { c.ExpiresAt = 0 }), ErrTokenExpired},
+		{"nbf within the skew", valid(func(c *Claims) { c.NotBefore = now.Unix() + skew - 2 }), nil},
+		{"nbf past the skew", valid(func(c *Claims) { c.NotBefore = now.Unix() + skew + 2 }), ErrTokenNotYetValid},
+		{"no nbf", valid(func(c *Claims) { c.NotBefore = 0 }), ErrTokenNotYetValid},
+		{"iat within the skew", valid(func(c *Claims) { c.IssuedAt = now.Unix() + skew - 2 }), nil},
+		{"iat past the skew", valid(func(c *Claims) { c.IssuedAt = now.Unix() + skew + 2 }), ErrTokenNotYetValid},
+		{"no iat", valid(func(c *Claims) { c.IssuedAt = 0 }), ErrTokenNotYetValid},
+		{"another issuer", valid(func(c *Claims) { c.Issuer = "someone-else" }), ErrTokenInvalidIssuer},
+		{"no issuer", valid(func(c *Claims) { c.Issuer = "" }), ErrTokenInvalidIssuer},
+		{"another audience", valid(func(c *Claims) { c.Audience = "someone-else" }), ErrTokenInvalidAudience},
+		{"no audience", valid(func(c *Claims) { c.Audience = "" }), ErrTokenInvalidAudience},
+		{"no subject", valid(func(c *Claims) { c.Subject = "" }), ErrTokenInvalidClaims},
+		{"no jti", valid(func(c *Claims) { c.Id = "" }), ErrTokenInvalidClaims},
+	}
+
+	for _, test := range tests {
+		if err := test.claims.Valid(); err != test.want {
+			t.Errorf("Valid with %s returned %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestParseTokenRejectsOtherIssuers(t *testing.T) {
+	useTestKeyrings(t)
+
+	key := NewHMACSigningKey("k1", []byte("secret"))
+	accessKeys = activeKey(t, accessTokenTTL, key)
+
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		change func(c *Claims)
+		want   error
+	}{
+		{"another issuer", func(c *Claims) { c.Issuer = "someone-else" }, ErrTokenInvalidIssuer},
+		{"another audience", func(c *Claims) { c.Audience = "someone-else" }, ErrTokenInvalidAudience},
+	}
+
+	for _, test := range tests {
+		claims := &Claims{StandardClaims: standardClaims("t1", "u1", now, now.Add(time.Minute).Unix())}
+		test.change(claims)
+
+		signed, err := key.Sign(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = ParseToken(signed, ACCESS); err != test.want {
+			t.Errorf("ParseToken of a token for %s returned %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestValidateTokenTypes(t *testing.T) {
+	store := useTestKeyrings(t)
+
+	// Both token types signed under the same kid and secret, only the ID claims tell them apart
+	accessKeys = activeKey(t, accessTokenTTL, NewHMACSigningKey("shared", []byte("secret")))
+	refreshKeys = activeKey(t, refreshTokenTTL, NewHMACSigningKey("shared", []byte("secret")))
+
+	accessID, accessExpiresAt, access, err := GenerateAccessToken("u1", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refreshID, refreshExpiresAt, refresh, err := GenerateRefreshToken("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = store.Issue(stores.Token{UserID: "u1", FamilyID: "f1", Token: accessID, ExpiresAt: accessExpiresAt}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = store.Issue(stores.Token{UserID: "u1", FamilyID: "f1", Token: refreshID, ExpiresAt: refreshExpiresAt}); err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := ValidateAccessToken(access)
+	if err != nil || claims.AccessID != accessID || claims.Subject != "u1" || claims.Role != "admin" {
+		t.Errorf("ValidateAccessToken returned %+v, %v, want u1's admin access token", claims, err)
+	}
+
+	if stored, err := ValidateRefreshToken(refresh); err != nil || stored.Token != refreshID {
+		t.Errorf("ValidateRefreshToken returned %+v, %v, want the refresh token", stored, err)
+	}
+
+	if _, err = ValidateAccessToken(refresh); err != ErrTokenInvalidClaims {
+		t.Errorf("ValidateAccessToken of a refresh token returned %v, want %v", err, ErrTokenInvalidClaims)
+	}
+
+	if _, err = ValidateRefreshToken(access); err != ErrTokenInvalidClaims {
+		t.Errorf("ValidateRefreshToken of an access token returned %v, want %v", err, ErrTokenInvalidClaims)
+	}
+
+	// An ID claim that isn't the token's jti is as good as a missing one
+	now := time.Now()
+	mismatched, err := accessKeys.keys[0].Sign(&Claims{
+		AccessID:       refreshID,
+		StandardClaims: standardClaims(accessID, "u1", now, now.Add(time.Minute).Unix()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = ValidateAccessToken(mismatched); err != ErrTokenInvalidClaims {
+		t.Errorf("ValidateAccessToken with an accessId other than its jti returned %v, want %v", err, ErrTokenInvalidClaims)
+	}
+
+	accessTokenMode = STATELESS
+
+	if _, err = ValidateAccessToken(refresh); err != ErrTokenInvalidClaims {
+		t.Errorf("stateless ValidateAccessToken of a refresh token returned %v, want %v", err, ErrTokenInvalidClaims)
+	}
+}
+
+func TestParseTokenRejectsUnexpectedKeys(t *testing.T) {
+	useTestKeyrings(t)
+
+	key := NewHMACSigningKey("k1", []byte("secret"))
+	accessKeys = activeKey(t, accessTokenTTL, key)
+
+	now := time.Now()
+	claims := &Claims{StandardClaims: standardClaims("t1", "u1", now, now.Add(time.Minute).Unix())}
+
+	sign := func(method jwt.SigningMethod, kid interface{}, secret interface{}) string {
+		token := jwt.NewWithClaims(method, claims)
+		if kid != nil {
+			token.Header["kid"] = kid
+		}
+
+		signed, err := token.SignedString(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return signed
+	}
+
+	if _, err := ParseToken(sign(jwt.SigningMethodHS512, "k1", []byte("secret")), ACCESS); err != nil {
+		t.Fatalf("ParseToken of a token signed by k1 returned %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"an unknown kid", sign(jwt.SigningMethodHS512, "k2", []byte("secret")), ErrUnknownKey},
+		{"no kid", sign(jwt.SigningMethodHS512, nil, []byte("secret")), ErrUnknownKey},
+		{"a kid that isn't a string", sign(jwt.SigningMethodHS512, 1, []byte("secret")), ErrUnknownKey},
+		{"another alg than the key's", sign(jwt.SigningMethodHS256, "k1", []byte("secret")), nil},
+		{"alg none", sign(jwt.SigningMethodNone, "k1", jwt.UnsafeAllowNoneSignatureType), nil},
+		{"another secret", sign(jwt.SigningMethodHS512, "k1", []byte("guess")), nil},
+	}
+
+	for _, test := range tests {
+		if _, err := ParseToken(test.token, ACCESS); err == nil || (test.want != nil && err != test.want) {
+			t.Errorf("ParseToken of a token with %s returned %v, want %v", test.name, err, test.want)
+		}
+	}
+
+	// Access and refresh tokens are verified by their own keyring
+	if _, err := ParseToken(sign(jwt.SigningMethodHS512, "k1", []byte("secret")), REFRESH); err != ErrUnknownKey {
+		t.Errorf("ParseToken of an access token as a refresh token returned %v, want %v", err, ErrUnknownKey)
+	}
+}
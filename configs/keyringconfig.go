@@ -0,0 +1,268 @@
+package configs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Keyring type holding every key able to verify a token type, exactly one of which signs new tokens
+type Keyring struct {
+	mu   sync.RWMutex
+	keys []*SigningKey
+	ttl  time.Duration
+}
+
+// KeyringFile type describing the JSON file pointed to by KEYRING_FILE
+type KeyringFile struct {
+	Access  []KeyringEntry `json:"access"`
+	Refresh []KeyringEntry `json:"refresh"`
+}
+
+// KeyringEntry type, KeyFile holds a PEM private key or, for HS512, the raw secret
+type KeyringEntry struct {
+	ID       string     `json:"kid"`
+	Alg      string     `json:"alg"`
+	KeyFile  string     `json:"keyFile"`
+	Active   bool       `json:"active"`
+	RetireAt *time.Time `json:"retireAt,omitempty"`
+}
+
+var (
+	// ErrNoActiveKey error
+	ErrNoActiveKey = errors.New("Keyring has no active key")
+	// ErrUnknownKey error
+	ErrUnknownKey = errors.New("Unknown signing key")
+	// ErrNoKeyringFile error
+	ErrNoKeyringFile = errors.New("KEYRING_FILE is not configured")
+	keyringMu        sync.Mutex
+)
+
+// NewKeyring function to create a keyring whose inactive keys stay valid for ttl, the lifetime of the tokens they signed
+func NewKeyring(ttl time.Duration) *Keyring {
+	return &Keyring{ttl: ttl}
+}
+
+// Replace function to swap in a new set of keys, inactive keys without a retire date are kept until tokens signed by them expire.
+// Keys left out of the new set are treated the same, a key that was active a moment ago still verifies the tokens it signed
+func (kr *Keyring) Replace(keys []*SigningKey) error {
+	if err := checkActive(keys); err != nil {
+		return err
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	var (
+		previous = map[string]*SigningKey{}
+		now      = time.Now()
+	)
+
+	for _, key := range kr.keys {
+		previous[key.ID] = key
+	}
+
+	for _, key := range keys {
+		old, ok := previous[key.ID]
+		delete(previous, key.ID)
+
+		if key.Active {
+			continue
+		}
+
+		if key.RetireAt.IsZero() {
+			if ok && !old.RetireAt.IsZero() {
+				key.RetireAt = old.RetireAt
+			} else {
+				key.RetireAt = now.Add(kr.ttl + clockSkew)
+			}
+		}
+	}
+
+	// Copied rather than flagged in place, signers may still hold the previously active key
+	for _, old := range kr.keys {
+		if _, ok := previous[old.ID]; !ok {
+			continue
+		}
+
+		retired := *old
+		if retired.Active {
+			retired.Active = false
+			retired.RetireAt = now.Add(kr.ttl + clockSkew)
+		}
+
+		if now.Before(retired.RetireAt) {
+			keys = append(keys, &retired)
+		}
+	}
+
+	kr.keys = keys
+
+	return nil
+}
+
+// Active function to get the key new tokens are signed with
+func (kr *Keyring) Active() (*SigningKey, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	for _, key := range kr.keys {
+		if key.Active {
+			return key, nil
+		}
+	}
+
+	return nil, ErrNoActiveKey
+}
+
+// Lookup function to get a verification key by kid, retired keys are no longer returned
+func (kr *Keyring) Lookup(id string) (*SigningKey, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	now := time.Now()
+
+	for _, key := range kr.keys {
+		if key.ID == id && (key.Active || now.Before(key.RetireAt)) {
+			return key, nil
+		}
+	}
+
+	return nil, ErrUnknownKey
+}
+
+// Keys function to get a snapshot of the keys still valid for verification
+func (kr *Keyring) Keys() []*SigningKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	var (
+		keys []*SigningKey
+		now  = time.Now()
+	)
+
+	for _, key := range kr.keys {
+		if key.Active || now.Before(key.RetireAt) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// AccessKeyring function to get the keyring for access tokens
+func AccessKeyring() *Keyring {
+	return accessKeys
+}
+
+// RefreshKeyring function to get the keyring for refresh tokens
+func RefreshKeyring() *Keyring {
+	return refreshKeys
+}
+
+// ReloadKeyrings function to re-read KEYRING_FILE without a restart
+func ReloadKeyrings() error {
+	path := os.Getenv("KEYRING_FILE")
+	if path == "" {
+		return ErrNoKeyringFile
+	}
+
+	keyringMu.Lock()
+	defer keyringMu.Unlock()
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var file KeyringFile
+
+	if err = json.Unmarshal(contents, &file); err != nil {
+		return err
+	}
+
+	access, err := loadKeyringEntries(file.Access)
+	if err != nil {
+		return err
+	}
+
+	refresh, err := loadKeyringEntries(file.Refresh)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range refresh {
+		if !key.IsSymmetric() {
+			return fmt.Errorf("Refresh key %s must use %s", key.ID, jwt.SigningMethodHS512.Alg())
+		}
+	}
+
+	// Checked up front so a bad file never leaves one keyring swapped and the other not
+	if err = checkActive(access); err != nil {
+		return err
+	}
+
+	if err = checkActive(refresh); err != nil {
+		return err
+	}
+
+	if err = accessKeys.Replace(access); err != nil {
+		return err
+	}
+
+	return refreshKeys.Replace(refresh)
+}
+
+func loadKeyringEntries(entries []KeyringEntry) ([]*SigningKey, error) {
+	var keys []*SigningKey
+
+	for _, entry := range entries {
+		if entry.ID == "" {
+			return nil, errors.New("Keyring entries require a kid")
+		}
+
+		contents, err := ioutil.ReadFile(entry.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		var key *SigningKey
+
+		if entry.Alg == jwt.SigningMethodHS512.Alg() {
+			key = NewHMACSigningKey(entry.ID, contents)
+		} else if key, err = NewAsymmetricSigningKey(entry.ID, entry.Alg, contents); err != nil {
+			return nil, err
+		}
+
+		key.Active = entry.Active
+		if entry.RetireAt != nil {
+			key.RetireAt = *entry.RetireAt
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func checkActive(keys []*SigningKey) error {
+	var active int
+
+	for _, key := range keys {
+		if key.Active {
+			active++
+		}
+	}
+
+	if active != 1 {
+		return fmt.Errorf("Keyring must have exactly one active key, found %d", active)
+	}
+
+	return nil
+}
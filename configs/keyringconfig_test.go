@@ -0,0 +1,218 @@
+package configs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func hmacKey(id string, active bool, retireAt time.Time) *SigningKey {
+	key := NewHMACSigningKey(id, []byte("secret-"+id))
+	key.Active = active
+	key.RetireAt = retireAt
+
+	return key
+}
+
+func expectKeys(t *testing.T, kr *Keyring, active string, verifying ...string) {
+	t.Helper()
+
+	if key, err := kr.Active(); err != nil || key.ID != active {
+		t.Errorf("Active returned %+v, %v, want %s", key, err, active)
+	}
+
+	var ids []string
+	for _, key := range kr.Keys() {
+		ids = append(ids, key.ID)
+	}
+
+	if len(ids) != len(verifying) {
+		t.Fatalf("keyring verifies with %v, want %v", ids, verifying)
+	}
+
+	for _, id := range verifying {
+		if _, err := kr.Lookup(id); err != nil {
+			t.Errorf("Lookup(%s) returned %v, want the key", id, err)
+		}
+	}
+}
+
+// expectRetireAt checks a key retires about after, allowing for the time the test took
+func expectRetireAt(t *testing.T, kr *Keyring, id string, after time.Duration) {
+	t.Helper()
+
+	key, err := kr.Lookup(id)
+	if err != nil {
+		t.Fatalf("Lookup(%s) returned %v", id, err)
+	}
+
+	if want := time.Now().Add(after); key.RetireAt.Before(want.Add(-time.Second)) || key.RetireAt.After(want) {
+		t.Errorf("%s retires at %s, want about %s", id, key.RetireAt, want)
+	}
+}
+
+func TestKeyringReplace(t *testing.T) {
+	useTestKeyrings(t)
+
+	ttl := time.Minute
+	kr := NewKeyring(ttl)
+
+	if _, err := kr.Active(); err != ErrNoActiveKey {
+		t.Errorf("Active of an empty keyring returned %v, want %v", err, ErrNoActiveKey)
+	}
+
+	k1 := hmacKey("k1", true, time.Time{})
+	if err := kr.Replace([]*SigningKey{k1}); err != nil {
+		t.Fatal(err)
+	}
+
+	expectKeys(t, kr, "k1", "k1")
+
+	// The key that was active keeps verifying the tokens it signed until they've expired, skew included
+	if err := kr.Replace([]*SigningKey{hmacKey("k2", true, time.Time{})}); err != nil {
+		t.Fatal(err)
+	}
+
+	expectKeys(t, kr, "k2", "k2", "k1")
+	expectRetireAt(t, kr, "k1", ttl+clockSkew)
+
+	if !k1.Active {
+		t.Error("Replace flagged the previously active key in place, a signer holding it would see it change")
+	}
+
+	// Reloading the same file doesn't push the retire date back
+	k1Retired, _ := kr.Lookup("k1")
+	retireAt := k1Retired.RetireAt
+
+	if err := kr.Replace([]*SigningKey{hmacKey("k2", true, time.Time{}), hmacKey("k1", false, time.Time{})}); err != nil {
+		t.Fatal(err)
+	}
+
+	if key, err := kr.Lookup("k1"); err != nil || !key.RetireAt.Equal(retireAt) {
+		t.Errorf("k1 listed again retires at %v (%v), want it kept at %s", key, err, retireAt)
+	}
+
+	// An inactive key new to the keyring is trusted as long as a token it signed could live
+	if err := kr.Replace([]*SigningKey{hmacKey("k2", true, time.Time{}), hmacKey("k3", false, time.Time{})}); err != nil {
+		t.Fatal(err)
+	}
+
+	expectKeys(t, kr, "k2", "k2", "k3", "k1")
+	expectRetireAt(t, kr, "k3", ttl+clockSkew)
+
+	// A retire date in the past drops a key at once
+	if err := kr.Replace([]*SigningKey{hmacKey("k2", true, time.Time{}), hmacKey("k1", false, time.Now().Add(-time.Second))}); err != nil {
+		t.Fatal(err)
+	}
+
+	expectKeys(t, kr, "k2", "k2", "k3")
+
+	if _, err := kr.Lookup("k1"); err != ErrUnknownKey {
+		t.Errorf("Lookup of a retired key returned %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestKeyringReplaceNeedsOneActiveKey(t *testing.T) {
+	kr := NewKeyring(time.Minute)
+
+	if err := kr.Replace([]*SigningKey{hmacKey("k1", true, time.Time{})}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, keys := range [][]*SigningKey{
+		nil,
+		{hmacKey("k2", false, time.Time{})},
+		{hmacKey("k2", true, time.Time{}), hmacKey("k3", true, time.Time{})},
+	} {
+		if err := kr.Replace(keys); err == nil {
+			t.Errorf("Replace with %d keys and no single active one succeeded, want an error", len(keys))
+		}
+	}
+
+	expectKeys(t, kr, "k1", "k1")
+}
+
+func TestKeyringRetiredKeysStopVerifying(t *testing.T) {
+	useTestKeyrings(t)
+
+	kr := NewKeyring(time.Minute)
+	if err := kr.Replace([]*SigningKey{hmacKey("k1", true, time.Time{}), hmacKey("k0", false, time.Now().Add(time.Hour))}); err != nil {
+		t.Fatal(err)
+	}
+
+	expectKeys(t, kr, "k1", "k1", "k0")
+
+	// Lookup and Keys compare against the clock, a key whose date passed goes without a reload
+	key, err := kr.Lookup("k0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key.RetireAt = time.Now().Add(-time.Second)
+
+	expectKeys(t, kr, "k1", "k1")
+}
+
+func TestReloadKeyrings(t *testing.T) {
+	useTestKeyrings(t)
+
+	dir := t.TempDir()
+	write := func(name string, contents string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		return path
+	}
+
+	secret := write("secret", "shared secret")
+	edKey := write("ed25519.pem", string(encodePKCS8(t, testPrivateKeys(t)[SigningMethodEd25519.Alg()])))
+
+	previous, ok := os.LookupEnv("KEYRING_FILE")
+	defer func() {
+		if ok {
+			os.Setenv("KEYRING_FILE", previous)
+		} else {
+			os.Unsetenv("KEYRING_FILE")
+		}
+	}()
+
+	os.Unsetenv("KEYRING_FILE")
+
+	if err := ReloadKeyrings(); err != ErrNoKeyringFile {
+		t.Errorf("ReloadKeyrings without KEYRING_FILE returned %v, want %v", err, ErrNoKeyringFile)
+	}
+
+	os.Setenv("KEYRING_FILE", write("keyring.json", `{
+		"access": [{"kid": "a2", "alg": "EdDSA", "keyFile": "`+edKey+`", "active": true}, {"kid": "a1", "alg": "HS512", "keyFile": "`+secret+`"}],
+		"refresh": [{"kid": "r1", "alg": "HS512", "keyFile": "`+secret+`", "active": true}]
+	}`))
+
+	if err := ReloadKeyrings(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectKeys(t, accessKeys, "a2", "a2", "a1")
+	expectKeys(t, refreshKeys, "r1", "r1")
+
+	// A bad file changes neither keyring
+	for _, contents := range []string{
+		`{"access": [{"kid": "a3", "alg": "HS512", "keyFile": "` + secret + `", "active": true}], "refresh": [{"kid": "r2", "alg": "HS512", "keyFile": "` + secret + `"}]}`,
+		`{"access": [{"kid": "a3", "alg": "HS512", "keyFile": "` + secret + `", "active": true}], "refresh": [{"kid": "r2", "alg": "EdDSA", "keyFile": "` + edKey + `", "active": true}]}`,
+		`{"access": [{"kid": "a3", "alg": "HS512", "keyFile": "` + filepath.Join(dir, "missing") + `", "active": true}], "refresh": []}`,
+		`{"access": [{"alg": "HS512", "keyFile": "` + secret + `", "active": true}], "refresh": []}`,
+		`not json`,
+	} {
+		os.Setenv("KEYRING_FILE", write("bad.json", contents))
+
+		if err := ReloadKeyrings(); err == nil {
+			t.Errorf("ReloadKeyrings of %s succeeded, want an error", contents)
+		}
+
+		expectKeys(t, accessKeys, "a2", "a2", "a1")
+		expectKeys(t, refreshKeys, "r1", "r1")
+	}
+}
@@ -0,0 +1,70 @@
+package configs
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultLoginUserMaxFailures used when LOGIN_USER_MAX_FAILURES is not set
+	DefaultLoginUserMaxFailures = 5
+	// DefaultLoginIPMaxFailures used when LOGIN_IP_MAX_FAILURES is not set
+	DefaultLoginIPMaxFailures = 20
+	// DefaultLoginFailureWindow used when LOGIN_FAILURE_WINDOW is not set
+	DefaultLoginFailureWindow = 15 * time.Minute
+	// DefaultLoginLockout used when LOGIN_LOCKOUT is not set
+	DefaultLoginLockout = time.Minute
+	// DefaultLoginMaxLockout used when LOGIN_MAX_LOCKOUT is not set
+	DefaultLoginMaxLockout = time.Hour
+)
+
+// LockoutPolicy type, how many failed logins a username or an IP gets before being locked out and for how long
+type LockoutPolicy struct {
+	UserMaxFailures int
+	IPMaxFailures   int
+	// Window after the last failure, or the end of the last lock, before the count starts over
+	Window time.Duration
+	// Lockout is the first lock, each further failure doubles it up to MaxLockout
+	Lockout    time.Duration
+	MaxLockout time.Duration
+}
+
+// LoadLockoutPolicy function to read the lockout policy from the LOGIN_* env vars
+func LoadLockoutPolicy() LockoutPolicy {
+	return LockoutPolicy{
+		UserMaxFailures: intOrDefault("LOGIN_USER_MAX_FAILURES", DefaultLoginUserMaxFailures),
+		IPMaxFailures:   intOrDefault("LOGIN_IP_MAX_FAILURES", DefaultLoginIPMaxFailures),
+		Window:          durationOrDefault("LOGIN_FAILURE_WINDOW", DefaultLoginFailureWindow),
+		Lockout:         durationOrDefault("LOGIN_LOCKOUT", DefaultLoginLockout),
+		MaxLockout:      durationOrDefault("LOGIN_MAX_LOCKOUT", DefaultLoginMaxLockout),
+	}
+}
+
+// LockDuration function to get how long to lock after the given number of failures, zero while under maxFailures
+func (p LockoutPolicy) LockDuration(failures int, maxFailures int) time.Duration {
+	if failures < maxFailures {
+		return 0
+	}
+
+	lock := p.Lockout
+
+	for i := maxFailures; i < failures && lock < p.MaxLockout; i++ {
+		lock *= 2
+	}
+
+	if lock > p.MaxLockout {
+		return p.MaxLockout
+	}
+
+	return lock
+}
+
+func intOrDefault(key string, fallback int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || n < 1 {
+		return fallback
+	}
+
+	return n
+}
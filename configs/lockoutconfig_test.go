@@ -0,0 +1,84 @@
+package configs
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLockDuration(t *testing.T) {
+	policy := LockoutPolicy{Lockout: time.Minute, MaxLockout: 10 * time.Minute}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{4, 0},
+		{5, time.Minute},
+		{6, 2 * time.Minute},
+		{7, 4 * time.Minute},
+		{8, 8 * time.Minute},
+		{9, 10 * time.Minute},
+		{100, 10 * time.Minute},
+	}
+
+	for _, test := range tests {
+		if got := policy.LockDuration(test.failures, 5); got != test.want {
+			t.Errorf("LockDuration(%d, 5) = %s, want %s", test.failures, got, test.want)
+		}
+	}
+
+	// A first lock already past the maximum is capped too
+	policy.Lockout = time.Hour
+	if got := policy.LockDuration(5, 5); got != 10*time.Minute {
+		t.Errorf("LockDuration with a first lock over the maximum = %s, want %s", got, 10*time.Minute)
+	}
+}
+
+func TestLoadLockoutPolicy(t *testing.T) {
+	names := []string{"LOGIN_USER_MAX_FAILURES", "LOGIN_IP_MAX_FAILURES", "LOGIN_FAILURE_WINDOW", "LOGIN_LOCKOUT", "LOGIN_MAX_LOCKOUT"}
+
+	for _, name := range names {
+		name := name
+
+		previous, ok := os.LookupEnv(name)
+		defer func() {
+			if ok {
+				os.Setenv(name, previous)
+			} else {
+				os.Unsetenv(name)
+			}
+		}()
+
+		os.Unsetenv(name)
+	}
+
+	want := LockoutPolicy{
+		UserMaxFailures: DefaultLoginUserMaxFailures,
+		IPMaxFailures:   DefaultLoginIPMaxFailures,
+		Window:          DefaultLoginFailureWindow,
+		Lockout:         DefaultLoginLockout,
+		MaxLockout:      DefaultLoginMaxLockout,
+	}
+
+	if got := LoadLockoutPolicy(); got != want {
+		t.Errorf("LoadLockoutPolicy without env vars = %+v, want the defaults %+v", got, want)
+	}
+
+	for name, value := range map[string]string{
+		"LOGIN_USER_MAX_FAILURES": "3",
+		"LOGIN_IP_MAX_FAILURES":   "0",
+		"LOGIN_FAILURE_WINDOW":    "1h",
+		"LOGIN_LOCKOUT":           "soon",
+		"LOGIN_MAX_LOCKOUT":       "2h",
+	} {
+		os.Setenv(name, value)
+	}
+
+	want.UserMaxFailures, want.Window, want.MaxLockout = 3, time.Hour, 2*time.Hour
+
+	if got := LoadLockoutPolicy(); got != want {
+		t.Errorf("LoadLockoutPolicy = %+v, want %+v, invalid values falling back to the defaults", got, want)
+	}
+}
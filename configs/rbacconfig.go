@@ -0,0 +1,86 @@
+package configs
+
+import (
+	"context"
+)
+
+// Permission type
+type Permission string
+
+const (
+	// UsersRead permission to look up and list users
+	UsersRead Permission = "users:read"
+	// UsersWrite permission to create users
+	UsersWrite Permission = "users:write"
+	// UsersReadPII permission to see masked PII such as the last four digits of SSNs, and to look users up by SSN
+	UsersReadPII Permission = "users:read_pii"
+	// UsersRevealPII permission to unmask PII with an explicit ?reveal=, every reveal is recorded
+	UsersRevealPII Permission = "users:reveal_pii"
+	// KeysManage permission to reload signing keys
+	KeysManage Permission = "keys:manage"
+	// OperatorsManage permission to create, list, disable and delete operator accounts
+	OperatorsManage Permission = "operators:manage"
+	// AuditRead permission to query the audit log
+	AuditRead Permission = "audit:read"
+)
+
+const (
+	// ADMIN role
+	ADMIN = "admin"
+	// OPERATOR role
+	OPERATOR = "operator"
+	// SUPPORT role
+	SUPPORT = "support"
+)
+
+type contextKey string
+
+const claimsContextKey contextKey = "claims"
+
+var roles = map[string][]Permission{
+	ADMIN:    {UsersRead, UsersWrite, UsersReadPII, UsersRevealPII, KeysManage, OperatorsManage, AuditRead},
+	OPERATOR: {UsersRead, UsersWrite, UsersReadPII, UsersRevealPII},
+	SUPPORT:  {UsersRead, UsersReadPII},
+}
+
+// RolePermissions function to list the permissions granted to a role, unknown roles get none
+func RolePermissions(role string) []Permission {
+	return roles[role]
+}
+
+// IsValidRole function to check if a role is defined
+func IsValidRole(role string) bool {
+	_, ok := roles[role]
+
+	return ok
+}
+
+// HasPermission function to check if claims grant a permission
+func (c *Claims) HasPermission(permission Permission) bool {
+	for _, p := range c.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WithClaims function to attach validated access token claims to a request context
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsContextKey, claims)
+}
+
+// ClaimsFromContext function to retrieve the claims attached by WithClaims
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(*Claims)
+
+	return claims, ok
+}
+
+// HasPermission function to check if the caller of a request context holds a permission
+func HasPermission(ctx context.Context, permission Permission) bool {
+	claims, ok := ClaimsFromContext(ctx)
+
+	return ok && claims.HasPermission(permission)
+}
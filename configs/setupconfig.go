@@ -0,0 +1,73 @@
+package configs
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/zoundwavedj/cybersecurity/repositories"
+)
+
+var (
+	// ErrNoSuperUsername error
+	ErrNoSuperUsername = errors.New("SUPERUSERNAME must be set to bootstrap the first admin account")
+	setupMu            sync.Mutex
+	setupToken         string
+)
+
+// SuperUsername function to get the username of the first admin account from SUPERUSERNAME, surrounding spaces trimmed
+func SuperUsername() string {
+	return strings.TrimSpace(os.Getenv("SUPERUSERNAME"))
+}
+
+// SetupBootstrap function to print a one-time setup token to the log when no admin account exists yet, which then
+// requires SUPERUSERNAME so the admin isn't created without a username
+func SetupBootstrap(operators repositories.OperatorRepository) error {
+	exists, err := operators.ExistsWithRole(context.Background(), ADMIN)
+	if err != nil || exists {
+		return err
+	}
+
+	if SuperUsername() == "" {
+		return ErrNoSuperUsername
+	}
+
+	raw := make([]byte, 32)
+	if _, err = rand.Read(raw); err != nil {
+		return err
+	}
+
+	setupMu.Lock()
+	setupToken = hex.EncodeToString(raw)
+	setupMu.Unlock()
+
+	log.Warn().Str("setupToken", setupToken).Msg("No admin account exists yet, POST /superuser with this token in the X-Setup-Token header to create one")
+
+	return nil
+}
+
+// CheckSetupToken function to compare a candidate against the setup token in constant time
+func CheckSetupToken(candidate string) bool {
+	setupMu.Lock()
+	defer setupMu.Unlock()
+
+	if setupToken == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(candidate), []byte(setupToken)) == 1
+}
+
+// ClearSetupToken function to invalidate the setup token once it has been used
+func ClearSetupToken() {
+	setupMu.Lock()
+	defer setupMu.Unlock()
+
+	setupToken = ""
+}
@@ -0,0 +1,71 @@
+package configs
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/zoundwavedj/cybersecurity/repositories"
+)
+
+// fakeOperators only answers whether an admin exists
+type fakeOperators struct {
+	repositories.OperatorRepository
+	adminExists bool
+}
+
+func (o fakeOperators) ExistsWithRole(ctx context.Context, role string) (bool, error) {
+	return o.adminExists && role == ADMIN, nil
+}
+
+func TestSetupBootstrap(t *testing.T) {
+	previous, ok := os.LookupEnv("SUPERUSERNAME")
+	defer func() {
+		if ok {
+			os.Setenv("SUPERUSERNAME", previous)
+		} else {
+			os.Unsetenv("SUPERUSERNAME")
+		}
+
+		ClearSetupToken()
+	}()
+
+	// Without an admin, bootstrapping one needs a username
+	for _, value := range []string{"", "   "} {
+		os.Setenv("SUPERUSERNAME", value)
+		ClearSetupToken()
+
+		if err := SetupBootstrap(fakeOperators{}); err != ErrNoSuperUsername {
+			t.Errorf("SetupBootstrap with SUPERUSERNAME %q returned %v, want %v", value, err, ErrNoSuperUsername)
+		}
+
+		if setupToken != "" {
+			t.Errorf("SetupBootstrap with SUPERUSERNAME %q issued a setup token", value)
+		}
+	}
+
+	// Once there's an admin the variable isn't needed
+	if err := SetupBootstrap(fakeOperators{adminExists: true}); err != nil || setupToken != "" {
+		t.Errorf("SetupBootstrap with an admin returned %v and token %q, want neither", err, setupToken)
+	}
+
+	os.Setenv("SUPERUSERNAME", " root ")
+
+	if err := SetupBootstrap(fakeOperators{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if SuperUsername() != "root" {
+		t.Errorf("SuperUsername returned %q, want root", SuperUsername())
+	}
+
+	if !CheckSetupToken(setupToken) || CheckSetupToken("") || CheckSetupToken("guess") {
+		t.Error("CheckSetupToken accepts something other than the token it issued")
+	}
+
+	ClearSetupToken()
+
+	if CheckSetupToken("") {
+		t.Error("CheckSetupToken accepts an empty token once cleared")
+	}
+}
@@ -0,0 +1,192 @@
+package configs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// SigningKey type pairing a signing method with its key material
+type SigningKey struct {
+	ID       string
+	Method   jwt.SigningMethod
+	Private  interface{}
+	Public   interface{}
+	Active   bool
+	RetireAt time.Time
+}
+
+var (
+	// ErrNoPEMBlock error
+	ErrNoPEMBlock = errors.New("No PEM block found in key file")
+	// ErrUnsupportedKey error
+	ErrUnsupportedKey = errors.New("Unsupported private key type")
+	accessKeys        = NewKeyring(accessTokenTTL)
+	refreshKeys       = NewKeyring(refreshTokenTTL)
+)
+
+// SetupSigningKeys function to load signing keys from KEYRING_FILE, or else from env vars where ACCESS_SIGNING_METHOD selects HS512 (default), RS256, ES256 or EdDSA for access tokens
+func SetupSigningKeys() error {
+	if os.Getenv("KEYRING_FILE") != "" {
+		return ReloadKeyrings()
+	}
+
+	accessKey, err := loadSigningKey(
+		envOrDefault("ACCESS_SIGNING_METHOD", jwt.SigningMethodHS512.Alg()),
+		accessSecret,
+		os.Getenv("ACCESS_PRIVATE_KEY_FILE"),
+		os.Getenv("ACCESS_KEY_ID"),
+	)
+	if err != nil {
+		return err
+	}
+
+	accessKey.Active = true
+	if err = accessKeys.Replace([]*SigningKey{accessKey}); err != nil {
+		return err
+	}
+
+	refreshKey := NewHMACSigningKey(envOrDefault("REFRESH_KEY_ID", "default"), refreshSecret)
+	refreshKey.Active = true
+
+	return refreshKeys.Replace([]*SigningKey{refreshKey})
+}
+
+// NewHMACSigningKey function to build an HS512 key from a shared secret
+func NewHMACSigningKey(id string, secret []byte) *SigningKey {
+	return &SigningKey{
+		ID:      id,
+		Method:  jwt.SigningMethodHS512,
+		Private: secret,
+		Public:  secret,
+	}
+}
+
+// NewAsymmetricSigningKey function to build a key from a PEM encoded private key, the ID defaults to the key's JWK thumbprint
+func NewAsymmetricSigningKey(id string, alg string, pemBytes []byte) (*SigningKey, error) {
+	private, err := parsePrivateKey(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	method := jwt.GetSigningMethod(alg)
+	if method == nil {
+		return nil, fmt.Errorf("Unknown signing method %s", alg)
+	}
+
+	if err = checkKeyType(alg, private); err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{
+		ID:      id,
+		Method:  method,
+		Private: private,
+		Public:  private.Public(),
+	}
+
+	if key.ID == "" {
+		if key.ID, err = Thumbprint(key.Public); err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// IsSymmetric function to check if the key is a shared secret, which must never be published
+func (k *SigningKey) IsSymmetric() bool {
+	_, ok := k.Method.(*jwt.SigningMethodHMAC)
+
+	return ok
+}
+
+// Sign function to sign claims with this key, stamping the kid header
+func (k *SigningKey) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.Method, claims)
+	token.Header["kid"] = k.ID
+
+	return token.SignedString(k.Private)
+}
+
+func loadSigningKey(alg string, secret []byte, keyFile string, id string) (*SigningKey, error) {
+	if alg == jwt.SigningMethodHS512.Alg() {
+		if id == "" {
+			id = "default"
+		}
+
+		return NewHMACSigningKey(id, secret), nil
+	}
+
+	if keyFile == "" {
+		return nil, fmt.Errorf("A private key file is required for %s signing", alg)
+	}
+
+	pemBytes, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewAsymmetricSigningKey(id, alg, pemBytes)
+}
+
+func parsePrivateKey(pemBytes []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, ErrNoPEMBlock
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, ErrUnsupportedKey
+		}
+
+		return signer, nil
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, ErrUnsupportedKey
+}
+
+func checkKeyType(alg string, key crypto.Signer) error {
+	var ok bool
+
+	switch alg {
+	case jwt.SigningMethodRS256.Alg():
+		_, ok = key.(*rsa.PrivateKey)
+	case jwt.SigningMethodES256.Alg():
+		var ecKey *ecdsa.PrivateKey
+
+		ecKey, ok = key.(*ecdsa.PrivateKey)
+		ok = ok && ecKey.Curve == elliptic.P256()
+	case SigningMethodEd25519.Alg():
+		_, ok = key.(ed25519.PrivateKey)
+	default:
+		return fmt.Errorf("Unsupported signing method %s", alg)
+	}
+
+	if !ok {
+		return fmt.Errorf("Private key doesn't match signing method %s", alg)
+	}
+
+	return nil
+}
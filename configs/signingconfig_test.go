@@ -0,0 +1,191 @@
+package configs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// testPrivateKeys generates one key for each asymmetric alg, keyed by alg
+func testPrivateKeys(t *testing.T) map[string]crypto.Signer {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]crypto.Signer{
+		jwt.SigningMethodRS256.Alg(): rsaKey,
+		jwt.SigningMethodES256.Alg(): ecKey,
+		SigningMethodEd25519.Alg():   edKey,
+	}
+}
+
+func encodePKCS8(t *testing.T, key crypto.Signer) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func testClaims() *Claims {
+	now := time.Now()
+	return &Claims{AccessID: "t1", StandardClaims: standardClaims("t1", "u1", now, now.Add(time.Minute).Unix())}
+}
+
+func TestAsymmetricSigningKeys(t *testing.T) {
+	useTestKeyrings(t)
+
+	for alg, private := range testPrivateKeys(t) {
+		key, err := NewAsymmetricSigningKey("", alg, encodePKCS8(t, private))
+		if err != nil {
+			t.Fatalf("NewAsymmetricSigningKey(%s) returned %v", alg, err)
+		}
+
+		if thumbprint, _ := Thumbprint(private.Public()); key.ID != thumbprint || key.IsSymmetric() {
+			t.Errorf("%s key has kid %q, want its thumbprint %q and an asymmetric key", alg, key.ID, thumbprint)
+		}
+
+		accessKeys = activeKey(t, accessTokenTTL, key)
+
+		signed, err := key.Sign(testClaims())
+		if err != nil {
+			t.Fatalf("Sign with %s returned %v", alg, err)
+		}
+
+		if claims, err := ParseToken(signed, ACCESS); err != nil || claims.Subject != "u1" {
+			t.Errorf("ParseToken of a %s token returned %+v, %v", alg, claims, err)
+		}
+
+		// A token signed by another key of the same alg under the same kid doesn't verify
+		other, err := NewAsymmetricSigningKey(key.ID, alg, encodePKCS8(t, testPrivateKeys(t)[alg]))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		forged, err := other.Sign(testClaims())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = ParseToken(forged, ACCESS); err == nil {
+			t.Errorf("ParseToken of a %s token signed by another key succeeded, want an error", alg)
+		}
+	}
+}
+
+func TestParseTokenRejectsAlgConfusion(t *testing.T) {
+	useTestKeyrings(t)
+
+	private := testPrivateKeys(t)[jwt.SigningMethodRS256.Alg()]
+
+	key, err := NewAsymmetricSigningKey("k1", jwt.SigningMethodRS256.Alg(), encodePKCS8(t, private))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accessKeys = activeKey(t, accessTokenTTL, key)
+
+	// The public key is public, an HMAC token keyed by it must not verify
+	der, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range [][]byte{der, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})} {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+		token.Header["kid"] = "k1"
+
+		signed, err := token.SignedString(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = ParseToken(signed, ACCESS); err == nil {
+			t.Error("ParseToken of an HS256 token keyed by the RS256 public key succeeded, want an error")
+		}
+	}
+
+	// Nor does a token claiming another asymmetric alg for the same kid
+	token := jwt.NewWithClaims(jwt.SigningMethodPS256, testClaims())
+	token.Header["kid"] = "k1"
+
+	signed, err := token.SignedString(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = ParseToken(signed, ACCESS); err == nil {
+		t.Error("ParseToken of a PS256 token for an RS256 key succeeded, want an error")
+	}
+}
+
+func TestNewAsymmetricSigningKeyErrors(t *testing.T) {
+	keys := testPrivateKeys(t)
+
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		alg  string
+		pem  []byte
+	}{
+		{"no PEM block", jwt.SigningMethodRS256.Alg(), []byte("not a key")},
+		{"an unknown alg", "XS256", encodePKCS8(t, keys[jwt.SigningMethodRS256.Alg()])},
+		{"an HMAC alg", jwt.SigningMethodHS512.Alg(), encodePKCS8(t, keys[jwt.SigningMethodRS256.Alg()])},
+		{"an EC key for RS256", jwt.SigningMethodRS256.Alg(), encodePKCS8(t, keys[jwt.SigningMethodES256.Alg()])},
+		{"an RSA key for ES256", jwt.SigningMethodES256.Alg(), encodePKCS8(t, keys[jwt.SigningMethodRS256.Alg()])},
+		{"a P-384 key for ES256", jwt.SigningMethodES256.Alg(), encodePKCS8(t, p384)},
+		{"an RSA key for EdDSA", SigningMethodEd25519.Alg(), encodePKCS8(t, keys[jwt.SigningMethodRS256.Alg()])},
+	}
+
+	for _, test := range tests {
+		if _, err := NewAsymmetricSigningKey("k1", test.alg, test.pem); err == nil {
+			t.Errorf("NewAsymmetricSigningKey with %s succeeded, want an error", test.name)
+		}
+	}
+
+	// PKCS#1 and SEC 1 encodings are read too
+	rsaKey := keys[jwt.SigningMethodRS256.Alg()].(*rsa.PrivateKey)
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+
+	if _, err = NewAsymmetricSigningKey("k1", jwt.SigningMethodRS256.Alg(), pkcs1); err != nil {
+		t.Errorf("NewAsymmetricSigningKey with a PKCS#1 key returned %v", err)
+	}
+
+	sec1, err := x509.MarshalECPrivateKey(keys[jwt.SigningMethodES256.Alg()].(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = NewAsymmetricSigningKey("k1", jwt.SigningMethodES256.Alg(), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1})); err != nil {
+		t.Errorf("NewAsymmetricSigningKey with a SEC 1 key returned %v", err)
+	}
+}
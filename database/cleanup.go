@@ -2,13 +2,26 @@ package database
 
 import (
 	"os"
+	"strings"
 
 	"github.com/rs/zerolog/log"
 )
 
-// Cleanup function to remove previous database file
+// WipeOnBoot function to check if DB_WIPE_ON_BOOT asks for a fresh database on every start
+func WipeOnBoot() bool {
+	return strings.ToLower(os.Getenv("DB_WIPE_ON_BOOT")) == "true"
+}
+
+// Cleanup function to remove previous database file, PostgreSQL databases are never dropped
 func Cleanup() {
-	if err := os.Remove("./local.db"); err != nil {
-		log.Fatal().Err(err)
+	if DialectOf(os.Getenv("DATABASE_URL")) != SQLITE {
+		log.Warn().Msg("DB_WIPE_ON_BOOT only applies to the SQLite database, skipping")
+		return
 	}
+
+	if err := os.Remove("./local.db"); err != nil && !os.IsNotExist(err) {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	log.Warn().Msg("Previous database wiped")
 }
@@ -0,0 +1,557 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Migration type, Up and Down hold the statements for each dialect and run in a single transaction
+type Migration struct {
+	Version int
+	Name    string
+	Up      map[Dialect][]string
+	Down    map[Dialect][]string
+}
+
+// MigrationState type
+type MigrationState struct {
+	Version   int
+	Name      string
+	AppliedAt int64
+}
+
+// migrations in the order they're applied, never edit or reorder an entry once shipped, add a new one instead
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_superuser",
+		Up: map[Dialect][]string{
+			SQLITE:   {"CREATE TABLE superuser (id TEXT PRIMARY KEY, username TEXT UNIQUE, password TEXT, role TEXT NOT NULL DEFAULT 'admin', disabled INTEGER NOT NULL DEFAULT 0, createdAt INTEGER)"},
+			POSTGRES: {"CREATE TABLE superuser (id TEXT PRIMARY KEY, username TEXT UNIQUE, password TEXT, role TEXT NOT NULL DEFAULT 'admin', disabled BOOLEAN NOT NULL DEFAULT FALSE, createdAt BIGINT)"},
+		},
+		Down: map[Dialect][]string{
+			SQLITE:   {"DROP TABLE superuser"},
+			POSTGRES: {"DROP TABLE superuser"},
+		},
+	},
+	{
+		Version: 2,
+		Name:    "create_authentication",
+		Up: map[Dialect][]string{
+			SQLITE: {
+				"CREATE TABLE authentication (userId TEXT, familyId TEXT, token TEXT, expiresAt INTEGER, rotated INTEGER DEFAULT 0, FOREIGN KEY (userId) REFERENCES superuser(id))",
+				"CREATE INDEX authentication_token ON authentication (token)",
+				"CREATE INDEX authentication_expiresAt ON authentication (expiresAt)",
+			},
+			POSTGRES: {
+				"CREATE TABLE authentication (userId TEXT REFERENCES superuser(id), familyId TEXT, token TEXT, expiresAt BIGINT, rotated BOOLEAN NOT NULL DEFAULT FALSE)",
+				"CREATE INDEX authentication_token ON authentication (token)",
+				"CREATE INDEX authentication_expiresAt ON authentication (expiresAt)",
+			},
+		},
+		Down: map[Dialect][]string{
+			SQLITE:   {"DROP TABLE authentication"},
+			POSTGRES: {"DROP TABLE authentication"},
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create_revocation",
+		Up: map[Dialect][]string{
+			SQLITE:   {"CREATE TABLE revocation (token TEXT PRIMARY KEY, expiresAt INTEGER, revokedAt INTEGER)"},
+			POSTGRES: {"CREATE TABLE revocation (token TEXT PRIMARY KEY, expiresAt BIGINT, revokedAt BIGINT)"},
+		},
+		Down: map[Dialect][]string{
+			SQLITE:   {"DROP TABLE revocation"},
+			POSTGRES: {"DROP TABLE revocation"},
+		},
+	},
+	{
+		Version: 4,
+		Name:    "create_user",
+		Up: map[Dialect][]string{
+			SQLITE:   {"CREATE TABLE user (id TEXT PRIMARY KEY, name TEXT, dob TEXT, email TEXT, ssn TEXT)"},
+			POSTGRES: {`CREATE TABLE "user" (id TEXT PRIMARY KEY, name TEXT, dob TEXT, email TEXT, ssn TEXT)`},
+		},
+		Down: map[Dialect][]string{
+			SQLITE:   {"DROP TABLE user"},
+			POSTGRES: {`DROP TABLE "user"`},
+		},
+	},
+	{
+		Version: 5,
+		Name:    "add_user_deleted_at",
+		Up: map[Dialect][]string{
+			SQLITE:   {"ALTER TABLE user ADD COLUMN deletedAt INTEGER"},
+			POSTGRES: {`ALTER TABLE "user" ADD COLUMN deletedAt BIGINT`},
+		},
+		Down: map[Dialect][]string{
+			// SQLite can't drop columns, the table is rebuilt without it
+			SQLITE: {
+				"CREATE TABLE user_rollback (id TEXT PRIMARY KEY, name TEXT, dob TEXT, email TEXT, ssn TEXT)",
+				"INSERT INTO user_rollback SELECT id, name, dob, email, ssn FROM user",
+				"DROP TABLE user",
+				"ALTER TABLE user_rollback RENAME TO user",
+			},
+			POSTGRES: {`ALTER TABLE "user" DROP COLUMN deletedAt`},
+		},
+	},
+	{
+		Version: 6,
+		Name:    "create_user_listing_indexes",
+		Up: map[Dialect][]string{
+			SQLITE: {
+				"CREATE INDEX user_name ON user (name, id)",
+				"CREATE INDEX user_email ON user (email, id)",
+				"CREATE INDEX user_dob ON user (dob, id)",
+			},
+			POSTGRES: {
+				`CREATE INDEX user_name ON "user" (name, id)`,
+				`CREATE INDEX user_email ON "user" (email, id)`,
+				`CREATE INDEX user_dob ON "user" (dob, id)`,
+			},
+		},
+		Down: map[Dialect][]string{
+			SQLITE:   {"DROP INDEX user_name", "DROP INDEX user_email", "DROP INDEX user_dob"},
+			POSTGRES: {"DROP INDEX user_name", "DROP INDEX user_email", "DROP INDEX user_dob"},
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add_user_blind_indexes",
+		Up: map[Dialect][]string{
+			SQLITE: {
+				"ALTER TABLE user ADD COLUMN ssnIndex TEXT",
+				"ALTER TABLE user ADD COLUMN emailIndex TEXT",
+				"CREATE UNIQUE INDEX user_ssnIndex ON user (ssnIndex)",
+				"CREATE INDEX user_emailIndex ON user (emailIndex)",
+			},
+			POSTGRES: {
+				`ALTER TABLE "user" ADD COLUMN ssnIndex TEXT`,
+				`ALTER TABLE "user" ADD COLUMN emailIndex TEXT`,
+				`CREATE UNIQUE INDEX user_ssnIndex ON "user" (ssnIndex)`,
+				`CREATE INDEX user_emailIndex ON "user" (emailIndex)`,
+			},
+		},
+		Down: map[Dialect][]string{
+			// SQLite can't drop columns, the table and the listing indexes are rebuilt without them
+			SQLITE: {
+				"DROP INDEX user_ssnIndex",
+				"DROP INDEX user_emailIndex",
+				"CREATE TABLE user_rollback (id TEXT PRIMARY KEY, name TEXT, dob TEXT, email TEXT, ssn TEXT, deletedAt INTEGER)",
+				"INSERT INTO user_rollback SELECT id, name, dob, email, ssn, deletedAt FROM user",
+				"DROP TABLE user",
+				"ALTER TABLE user_rollback RENAME TO user",
+				"CREATE INDEX user_name ON user (name, id)",
+				"CREATE INDEX user_email ON user (email, id)",
+				"CREATE INDEX user_dob ON user (dob, id)",
+			},
+			POSTGRES: {
+				"DROP INDEX user_ssnIndex",
+				"DROP INDEX user_emailIndex",
+				`ALTER TABLE "user" DROP COLUMN ssnIndex`,
+				`ALTER TABLE "user" DROP COLUMN emailIndex`,
+			},
+		},
+	},
+	{
+		Version: 8,
+		Name:    "create_job_checkpoint",
+		Up: map[Dialect][]string{
+			SQLITE:   {"CREATE TABLE job_checkpoint (job TEXT PRIMARY KEY, position TEXT NOT NULL, processed BIGINT NOT NULL, updatedAt BIGINT NOT NULL)"},
+			POSTGRES: {"CREATE TABLE job_checkpoint (job TEXT PRIMARY KEY, position TEXT NOT NULL, processed BIGINT NOT NULL, updatedAt BIGINT NOT NULL)"},
+		},
+		Down: map[Dialect][]string{
+			SQLITE:   {"DROP TABLE job_checkpoint"},
+			POSTGRES: {"DROP TABLE job_checkpoint"},
+		},
+	},
+	{
+		Version: 9,
+		Name:    "create_audit_log",
+		Up: map[Dialect][]string{
+			// The unique prevHash keeps the chain linear, two concurrent appends to the same head can't both land
+			SQLITE: {
+				`CREATE TABLE audit_log (seq INTEGER PRIMARY KEY AUTOINCREMENT, occurredAt INTEGER NOT NULL, actor TEXT NOT NULL, action TEXT NOT NULL, target TEXT NOT NULL,
+					ip TEXT NOT NULL, userAgent TEXT NOT NULL, outcome TEXT NOT NULL, details TEXT NOT NULL, prevHash TEXT NOT NULL UNIQUE, hash TEXT NOT NULL UNIQUE)`,
+				"CREATE INDEX audit_log_actor ON audit_log (actor)",
+				"CREATE INDEX audit_log_action ON audit_log (action)",
+				"CREATE INDEX audit_log_target ON audit_log (target)",
+				"CREATE INDEX audit_log_occurredAt ON audit_log (occurredAt)",
+				"CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END",
+				"CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END",
+			},
+			POSTGRES: {
+				`CREATE TABLE audit_log (seq BIGSERIAL PRIMARY KEY, occurredAt BIGINT NOT NULL, actor TEXT NOT NULL, action TEXT NOT NULL, target TEXT NOT NULL,
+					ip TEXT NOT NULL, userAgent TEXT NOT NULL, outcome TEXT NOT NULL, details TEXT NOT NULL, prevHash TEXT NOT NULL UNIQUE, hash TEXT NOT NULL UNIQUE)`,
+				"CREATE INDEX audit_log_actor ON audit_log (actor)",
+				"CREATE INDEX audit_log_action ON audit_log (action)",
+				"CREATE INDEX audit_log_target ON audit_log (target)",
+				"CREATE INDEX audit_log_occurredAt ON audit_log (occurredAt)",
+				"CREATE FUNCTION audit_log_append_only() RETURNS trigger AS $$ BEGIN RAISE EXCEPTION 'audit_log is append-only'; END $$ LANGUAGE plpgsql",
+				"CREATE TRIGGER audit_log_no_change BEFORE UPDATE OR DELETE ON audit_log FOR EACH ROW EXECUTE PROCEDURE audit_log_append_only()",
+				"CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log FOR EACH STATEMENT EXECUTE PROCEDURE audit_log_append_only()",
+			},
+		},
+		Down: map[Dialect][]string{
+			SQLITE: {"DROP TABLE audit_log"},
+			POSTGRES: {
+				"DROP TABLE audit_log",
+				"DROP FUNCTION audit_log_append_only()",
+			},
+		},
+	},
+	{
+		Version: 10,
+		Name:    "create_login_failure",
+		Up: map[Dialect][]string{
+			SQLITE:   {"CREATE TABLE login_failure (subject TEXT PRIMARY KEY, failures INTEGER NOT NULL, lastFailureAt BIGINT NOT NULL, lockedUntil BIGINT NOT NULL DEFAULT 0)"},
+			POSTGRES: {"CREATE TABLE login_failure (subject TEXT PRIMARY KEY, failures INTEGER NOT NULL, lastFailureAt BIGINT NOT NULL, lockedUntil BIGINT NOT NULL DEFAULT 0)"},
+		},
+		Down: map[Dialect][]string{
+			SQLITE:   {"DROP TABLE login_failure"},
+			POSTGRES: {"DROP TABLE login_failure"},
+		},
+	},
+	{
+		Version: 11,
+		Name:    "add_user_email_domain_index",
+		// Emails are encrypted so user_email only ever ordered ciphertexts, domains are matched through their blind index instead
+		Up: map[Dialect][]string{
+			SQLITE: {
+				"ALTER TABLE user ADD COLUMN emailDomainIndex TEXT",
+				"CREATE INDEX user_emailDomainIndex ON user (emailDomainIndex, id)",
+				"DROP INDEX user_email",
+			},
+			POSTGRES: {
+				`ALTER TABLE "user" ADD COLUMN emailDomainIndex TEXT`,
+				`CREATE INDEX user_emailDomainIndex ON "user" (emailDomainIndex, id)`,
+				"DROP INDEX user_email",
+			},
+		},
+		Down: map[Dialect][]string{
+			// SQLite can't drop columns, the table and its indexes are rebuilt without it
+			SQLITE: {
+				"DROP INDEX user_emailDomainIndex",
+				"CREATE TABLE user_rollback (id TEXT PRIMARY KEY, name TEXT, dob TEXT, email TEXT, ssn TEXT, deletedAt INTEGER, ssnIndex TEXT, emailIndex TEXT)",
+				"INSERT INTO user_rollback SELECT id, name, dob, email, ssn, deletedAt, ssnIndex, emailIndex FROM user",
+				"DROP TABLE user",
+				"ALTER TABLE user_rollback RENAME TO user",
+				"CREATE INDEX user_name ON user (name, id)",
+				"CREATE INDEX user_email ON user (email, id)",
+				"CREATE INDEX user_dob ON user (dob, id)",
+				"CREATE UNIQUE INDEX user_ssnIndex ON user (ssnIndex)",
+				"CREATE INDEX user_emailIndex ON user (emailIndex)",
+			},
+			POSTGRES: {
+				"DROP INDEX user_emailDomainIndex",
+				`ALTER TABLE "user" DROP COLUMN emailDomainIndex`,
+				`CREATE INDEX user_email ON "user" (email, id)`,
+			},
+		},
+	},
+	{
+		Version: 12,
+		Name:    "add_user_name_prefix_and_dob_year_indexes",
+		// Names are encrypted again so user_name only ever ordered ciphertexts, name prefixes and birth years are matched through blind indexes instead
+		Up: map[Dialect][]string{
+			SQLITE: {
+				"CREATE TABLE user_name_prefix (prefixIndex TEXT NOT NULL, userId TEXT NOT NULL, PRIMARY KEY (prefixIndex, userId))",
+				"CREATE INDEX user_name_prefix_userId ON user_name_prefix (userId)",
+				"ALTER TABLE user ADD COLUMN dobYearIndex TEXT",
+				"CREATE INDEX user_dobYearIndex ON user (dobYearIndex, id)",
+				"DROP INDEX user_name",
+			},
+			POSTGRES: {
+				"CREATE TABLE user_name_prefix (prefixIndex TEXT NOT NULL, userId TEXT NOT NULL, PRIMARY KEY (prefixIndex, userId))",
+				"CREATE INDEX user_name_prefix_userId ON user_name_prefix (userId)",
+				`ALTER TABLE "user" ADD COLUMN dobYearIndex TEXT`,
+				`CREATE INDEX user_dobYearIndex ON "user" (dobYearIndex, id)`,
+				"DROP INDEX user_name",
+			},
+		},
+		Down: map[Dialect][]string{
+			// SQLite can't drop columns, the table and its indexes are rebuilt without it
+			SQLITE: {
+				"DROP TABLE user_name_prefix",
+				"DROP INDEX user_dobYearIndex",
+				"CREATE TABLE user_rollback (id TEXT PRIMARY KEY, name TEXT, dob TEXT, email TEXT, ssn TEXT, deletedAt INTEGER, ssnIndex TEXT, emailIndex TEXT, emailDomainIndex TEXT)",
+				"INSERT INTO user_rollback SELECT id, name, dob, email, ssn, deletedAt, ssnIndex, emailIndex, emailDomainIndex FROM user",
+				"DROP TABLE user",
+				"ALTER TABLE user_rollback RENAME TO user",
+				"CREATE INDEX user_name ON user (name, id)",
+				"CREATE INDEX user_dob ON user (dob, id)",
+				"CREATE UNIQUE INDEX user_ssnIndex ON user (ssnIndex)",
+				"CREATE INDEX user_emailIndex ON user (emailIndex)",
+				"CREATE INDEX user_emailDomainIndex ON user (emailDomainIndex, id)",
+			},
+			POSTGRES: {
+				"DROP TABLE user_name_prefix",
+				"DROP INDEX user_dobYearIndex",
+				`ALTER TABLE "user" DROP COLUMN dobYearIndex`,
+				`CREATE INDEX user_name ON "user" (name, id)`,
+			},
+		},
+	},
+}
+
+// Migrations function to list every known migration
+func Migrations() []Migration {
+	return migrations
+}
+
+// baselineVersion is the last migration a database created before migrations existed already holds the tables of
+const baselineVersion = 4
+
+// baselineColumns lists the columns added to the baseline tables while they were still created on startup,
+// an older local.db may lack some of them
+var baselineColumns = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"superuser", "role", "TEXT NOT NULL DEFAULT 'admin'"},
+	{"superuser", "disabled", "INTEGER NOT NULL DEFAULT 0"},
+	{"superuser", "createdAt", "INTEGER"},
+	{"authentication", "familyId", "TEXT"},
+	{"authentication", "rotated", "INTEGER DEFAULT 0"},
+}
+
+// Migrate function to apply every pending migration, returns the number applied
+func Migrate() (int, error) {
+	applied, err := appliedVersions()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(applied) == 0 {
+		if applied, err = adoptBaseline(); err != nil {
+			return 0, err
+		}
+	}
+
+	var count int
+
+	for _, migration := range migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		err = runMigration(migration, migration.Up[Driver], func(tx *sql.Tx) error {
+			_, err := tx.Exec(Rebind(Driver, "INSERT INTO schema_migrations (version, name, appliedAt) VALUES (?, ?, ?)"), migration.Version, migration.Name, time.Now().Unix())
+			return err
+		})
+		if err != nil {
+			return count, err
+		}
+
+		log.Info().Int("version", migration.Version).Str("name", migration.Name).Msg("Migration applied")
+		count++
+	}
+
+	return count, nil
+}
+
+// adoptBaseline records the migrations up to baselineVersion as applied on a SQLite database whose tables were created on startup,
+// before schema_migrations existed, adding whatever columns and tables it lacks. PostgreSQL support came with migrations, so only
+// SQLite databases can predate them
+func adoptBaseline() (map[int]MigrationState, error) {
+	if Driver != SQLITE {
+		return map[int]MigrationState{}, nil
+	}
+
+	var tables int
+
+	err := Db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='superuser'").Scan(&tables)
+	if err != nil || tables == 0 {
+		return map[int]MigrationState{}, err
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	statements := []string{
+		"CREATE TABLE IF NOT EXISTS authentication (userId TEXT, familyId TEXT, token TEXT, expiresAt INTEGER, rotated INTEGER DEFAULT 0, FOREIGN KEY (userId) REFERENCES superuser(id))",
+		"CREATE TABLE IF NOT EXISTS revocation (token TEXT PRIMARY KEY, expiresAt INTEGER, revokedAt INTEGER)",
+		"CREATE TABLE IF NOT EXISTS user (id TEXT PRIMARY KEY, name TEXT, dob TEXT, email TEXT, ssn TEXT)",
+	}
+
+	for _, c := range baselineColumns {
+		exists, err := hasColumn(tx, c.table, c.column)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		if !exists {
+			statements = append(statements, "ALTER TABLE "+c.table+" ADD COLUMN "+c.column+" "+c.definition)
+		}
+	}
+
+	statements = append(statements,
+		"CREATE INDEX IF NOT EXISTS authentication_token ON authentication (token)",
+		"CREATE INDEX IF NOT EXISTS authentication_expiresAt ON authentication (expiresAt)",
+	)
+
+	for _, statement := range statements {
+		if _, err = tx.Exec(statement); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("Adopting the baseline schema failed: %v", err)
+		}
+	}
+
+	applied := map[int]MigrationState{}
+	now := time.Now().Unix()
+
+	for _, migration := range migrations {
+		if migration.Version > baselineVersion {
+			break
+		}
+
+		if _, err = tx.Exec("INSERT INTO schema_migrations (version, name, appliedAt) VALUES (?, ?, ?)", migration.Version, migration.Name, now); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		applied[migration.Version] = MigrationState{Version: migration.Version, Name: migration.Name, AppliedAt: now}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	log.Info().Int("version", baselineVersion).Msg("Baseline schema adopted")
+
+	return applied, nil
+}
+
+// hasColumn tells if a SQLite table has a column
+func hasColumn(tx *sql.Tx, table string, column string) (bool, error) {
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+
+		if err = rows.Scan(&name); err != nil {
+			return false, err
+		}
+
+		if name == column {
+			return true, nil
+		}
+	}
+
+	return false, rows.Err()
+}
+
+// Rollback function to revert the latest steps applied migrations, returns the number reverted
+func Rollback(steps int) (int, error) {
+	applied, err := appliedVersions()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+
+	for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+		migration := migrations[i]
+
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		err = runMigration(migration, migration.Down[Driver], func(tx *sql.Tx) error {
+			_, err := tx.Exec(Rebind(Driver, "DELETE FROM schema_migrations WHERE version=?"), migration.Version)
+			return err
+		})
+		if err != nil {
+			return count, err
+		}
+
+		log.Info().Int("version", migration.Version).Str("name", migration.Name).Msg("Migration rolled back")
+		count++
+	}
+
+	return count, nil
+}
+
+// AppliedMigrations function to list the migrations recorded in schema_migrations
+func AppliedMigrations() ([]MigrationState, error) {
+	if err := ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	rows, err := Db.Query("SELECT version, name, appliedAt FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var states []MigrationState
+
+	for rows.Next() {
+		var state MigrationState
+
+		if err = rows.Scan(&state.Version, &state.Name, &state.AppliedAt); err != nil {
+			return nil, err
+		}
+
+		states = append(states, state)
+	}
+
+	return states, rows.Err()
+}
+
+func appliedVersions() (map[int]MigrationState, error) {
+	states, err := AppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := map[int]MigrationState{}
+	for _, state := range states {
+		applied[state.Version] = state
+	}
+
+	return applied, nil
+}
+
+func ensureMigrationsTable() error {
+	_, err := Db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, name TEXT, appliedAt BIGINT)")
+
+	return err
+}
+
+func runMigration(migration Migration, statements []string, record func(*sql.Tx) error) error {
+	if len(statements) == 0 {
+		return fmt.Errorf("Migration %d (%s) has no statements for %s", migration.Version, migration.Name, Driver)
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, statement := range statements {
+		if _, err = tx.Exec(statement); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Migration %d (%s) failed: %v", migration.Version, migration.Name, err)
+		}
+	}
+
+	if err = record(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
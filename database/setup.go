@@ -2,57 +2,102 @@ package database
 
 import (
 	"database/sql"
+	"os"
+	"strconv"
+	"strings"
 
+	// Required for postgres driver
+	_ "github.com/lib/pq"
 	// Required for sqlite3 driver
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/rs/zerolog/log"
 )
 
-// Db global var
-var Db *sql.DB
+// Dialect type naming the SQL flavour of the connected database
+type Dialect string
 
-// Setup function for initiating db connection and preparing schema
+const (
+	// SQLITE dialect
+	SQLITE Dialect = "sqlite3"
+	// POSTGRES dialect
+	POSTGRES Dialect = "postgres"
+	// DefaultDSN used when DATABASE_URL is not set
+	DefaultDSN = "file:local.db?cache=shared&mode=rwc"
+)
+
+var (
+	// Db global var
+	Db *sql.DB
+	// Driver global var, the dialect Db speaks
+	Driver Dialect
+)
+
+// Setup function for initiating db connection and applying pending migrations, set DB_AUTO_MIGRATE=false to only migrate through the CLI
 func Setup() {
-	var err error
+	Open()
 
-	Db, err = sql.Open("sqlite3", "file:local.db?cache=shared&mode=rwc")
-	if err != nil {
+	if strings.ToLower(os.Getenv("DB_AUTO_MIGRATE")) == "false" {
+		return
+	}
+
+	if _, err := Migrate(); err != nil {
 		log.Fatal().Err(err).Msg("")
 	}
+}
 
-	statement, err := Db.Prepare("CREATE TABLE IF NOT EXISTS superuser (id TEXT PRIMARY KEY, username TEXT UNIQUE, password TEXT)")
-	if err != nil {
-		log.Fatal().Err(err)
+// Open function for initiating db connection without touching the schema, DATABASE_URL selects PostgreSQL when it's a postgres:// URL
+func Open() {
+	var err error
+
+	dsn := os.Getenv("DATABASE_URL")
+	Driver = DialectOf(dsn)
+
+	if dsn == "" {
+		dsn = DefaultDSN
 	}
-	defer statement.Close()
 
-	_, err = statement.Exec()
+	Db, err = sql.Open(string(Driver), dsn)
 	if err != nil {
 		log.Fatal().Err(err).Msg("")
 	}
-	statement.Close()
 
-	statement, err = Db.Prepare("CREATE TABLE IF NOT EXISTS authentication (userId TEXT, token TEXT, expiresAt INTEGER, FOREIGN KEY (userId) REFERENCES superuser(id))")
-	if err != nil {
-		log.Fatal().Err(err)
+	if err = Db.Ping(); err != nil {
+		log.Fatal().Err(err).Msg("")
 	}
-	defer statement.Close()
 
-	_, err = statement.Exec()
-	if err != nil {
-		log.Fatal().Err(err).Msg("")
+	log.Info().Str("driver", string(Driver)).Msg("Database connected")
+}
+
+// DialectOf function to work out the dialect of a DSN
+func DialectOf(dsn string) Dialect {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return POSTGRES
 	}
-	statement.Close()
 
-	statement, err = Db.Prepare("CREATE TABLE IF NOT EXISTS user (id TEXT PRIMARY KEY, name TEXT, dob TEXT, email TEXT, ssn TEXT)")
-	if err != nil {
-		log.Fatal().Err(err)
+	return SQLITE
+}
+
+// Rebind function to rewrite ? placeholders into the dialect's bindvar style
+func Rebind(dialect Dialect, query string) string {
+	if dialect != POSTGRES {
+		return query
 	}
-	defer statement.Close()
 
-	_, err = statement.Exec()
-	if err != nil {
-		log.Fatal().Err(err).Msg("")
+	var (
+		builder strings.Builder
+		n       int
+	)
+
+	for _, c := range query {
+		if c == '?' {
+			n++
+			builder.WriteString("$")
+			builder.WriteString(strconv.Itoa(n))
+			continue
+		}
+
+		builder.WriteRune(c)
 	}
-	statement.Close()
+
+	return builder.String()
 }
@@ -150,7 +150,13 @@ const getSuperUserCreds = (
   setCredsDialog: React.Dispatch<React.SetStateAction<boolean>>,
   setFailureDialog: React.Dispatch<React.SetStateAction<boolean>>
 ) => {
-  fetch('/superuser', { method: 'GET' })
+  const setupToken = window.prompt('Enter the setup token printed in the server log');
+
+  if (!setupToken) {
+    return;
+  }
+
+  fetch('/superuser', { method: 'POST', headers: { 'X-Setup-Token': setupToken } })
     .then(response => response.json())
     .then(data => {
       if (data.username && data.password) {
@@ -3,9 +3,13 @@ module github.com/zoundwavedj/cybersecurity
 go 1.15
 
 require (
+	github.com/alicebob/miniredis/v2 v2.30.4
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
+	github.com/fergusstrange/embedded-postgres v1.34.0
+	github.com/gomodule/redigo v1.8.3
 	github.com/google/uuid v1.1.2
 	github.com/gorilla/mux v1.8.0
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.5
 	github.com/rs/zerolog v1.20.0
 	golang.org/x/crypto v0.0.0-20201203163018-be400aefbc4c
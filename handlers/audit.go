@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/zoundwavedj/cybersecurity/configs"
+)
+
+// auditActor names who made a request on routes the audit middleware can't tell from an access token
+func auditActor(r *http.Request, actor string) {
+	if event, ok := configs.AuditEventFromContext(r.Context()); ok {
+		event.Actor = actor
+	}
+}
+
+// auditTarget names the record a request acted on when it isn't in the route
+func auditTarget(r *http.Request, target string) {
+	if event, ok := configs.AuditEventFromContext(r.Context()); ok {
+		event.Target = target
+	}
+}
+
+// auditDetail adds key=value to the details of a request's audit event, never pass it PII
+func auditDetail(r *http.Request, key string, value string) {
+	if event, ok := configs.AuditEventFromContext(r.Context()); ok {
+		if event.Details != "" {
+			event.Details += " "
+		}
+
+		event.Details += key + "=" + value
+	}
+}
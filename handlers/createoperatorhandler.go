@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/zoundwavedj/cybersecurity/configs"
+	"github.com/zoundwavedj/cybersecurity/repositories"
+)
+
+const minPasswordLength = 12
+
+type createOperatorReq struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Role     string `json:"role,omitempty"`
+}
+
+type createOperatorResp struct {
+	ID       string `json:"id,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Role     string `json:"role,omitempty"`
+}
+
+// CreateOperatorHandler function to create an operator account, a password is generated and returned once if none is given
+func CreateOperatorHandler(operators repositories.OperatorRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var req createOperatorReq
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			HandleError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		req.Username = strings.TrimSpace(req.Username)
+		if req.Username == "" {
+			HandleError(w, "Username is required", http.StatusBadRequest)
+			return
+		}
+
+		if req.Role == "" {
+			req.Role = configs.OPERATOR
+		}
+
+		if !configs.IsValidRole(req.Role) {
+			HandleError(w, "Invalid role", http.StatusBadRequest)
+			return
+		}
+
+		var (
+			resp = createOperatorResp{Username: req.Username, Role: req.Role}
+			err  error
+		)
+
+		if req.Password == "" {
+			if req.Password, err = generatePassword(); err != nil {
+				log.Err(err).Msg("")
+				HandleError500(w)
+				return
+			}
+
+			resp.Password = req.Password
+		} else if len(req.Password) < minPasswordLength {
+			HandleError(w, "Password must be at least 12 characters", http.StatusBadRequest)
+			return
+		}
+
+		hash, err := generateHash(req.Password)
+		if err != nil {
+			log.Err(err).Msg("")
+			HandleError500(w)
+			return
+		}
+
+		resp.ID = uuid.New().String()
+		auditTarget(r, resp.ID)
+		auditDetail(r, "role", req.Role)
+
+		err = operators.Create(r.Context(), &repositories.Operator{
+			ID:        resp.ID,
+			Username:  req.Username,
+			Password:  hash,
+			Role:      req.Role,
+			CreatedAt: time.Now().Unix(),
+		})
+		if err != nil {
+			if err == repositories.ErrConflict {
+				HandleError(w, "Username already exists", http.StatusConflict)
+				return
+			}
+
+			log.Err(err).Msg("")
+			HandleError500(w)
+			return
+		}
+
+		log.Info().Str("operatorId", resp.ID).Str("role", req.Role).Msg("Operator created")
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(&resp)
+	}
+}
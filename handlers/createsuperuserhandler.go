@@ -6,12 +6,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"github.com/zoundwavedj/cybersecurity/configs"
-	"github.com/zoundwavedj/cybersecurity/database"
+	"github.com/zoundwavedj/cybersecurity/repositories"
 	"golang.org/x/crypto/argon2"
 )
 
@@ -20,76 +21,110 @@ type createSuperUserResp struct {
 	Password string `json:"password,omitempty"`
 }
 
-// CreateSuperUserHandler function to create a superuser
-func CreateSuperUserHandler(w http.ResponseWriter, r *http.Request) {
-	var username = os.Getenv("SUPERUSERNAME")
-	w.Header().Set("Content-Type", "application/json")
-
-	rows, err := database.Db.Query("SELECT * FROM superuser")
-	if err != nil {
-		log.Error().Err(err).Msg("")
-		HandleError500(w)
-		return
-	}
-	defer rows.Close()
-
-	if rows.Next() {
-		HandleError(w, "Superuser already exists", http.StatusBadRequest)
-		return
-	}
-
-	hash, plaintext, err := generateHash()
-	if err != nil {
-		log.Error().Err(err).Msg("")
-		HandleError500(w)
-		return
-	}
-
-	id, err := uuid.NewRandom()
-	if err != nil {
-		log.Error().Err(err).Msg("")
-		HandleError500(w)
-		return
+// bootstrapMu serializes bootstrap attempts so the setup token can only ever create one admin
+var bootstrapMu sync.Mutex
+
+// CreateSuperUserHandler function to create the initial admin, requires the setup token logged at startup and is disabled once an admin exists
+func CreateSuperUserHandler(operators repositories.OperatorRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		bootstrapMu.Lock()
+		defer bootstrapMu.Unlock()
+
+		exists, err := operators.ExistsWithRole(r.Context(), configs.ADMIN)
+		if err != nil {
+			log.Error().Err(err).Msg("")
+			HandleError500(w)
+			return
+		}
+
+		if exists {
+			HandleError(w, "Setup already completed", http.StatusGone)
+			return
+		}
+
+		if !configs.CheckSetupToken(r.Header.Get("X-Setup-Token")) {
+			log.Warn().Str("ip", r.RemoteAddr).Msg("Superuser bootstrap attempted with an invalid setup token")
+			HandleError(w, "Invalid setup token", http.StatusUnauthorized)
+			return
+		}
+
+		// SetupBootstrap already refuses to start without it, an admin must never be created without a username
+		username := configs.SuperUsername()
+		if username == "" {
+			log.Error().Err(configs.ErrNoSuperUsername).Msg("")
+			HandleError(w, "The admin username isn't configured, set SUPERUSERNAME and restart", http.StatusInternalServerError)
+			return
+		}
+
+		plaintext, err := generatePassword()
+		if err != nil {
+			log.Error().Err(err).Msg("")
+			HandleError500(w)
+			return
+		}
+
+		hash, err := generateHash(plaintext)
+		if err != nil {
+			log.Error().Err(err).Msg("")
+			HandleError500(w)
+			return
+		}
+
+		id, err := uuid.NewRandom()
+		if err != nil {
+			log.Error().Err(err).Msg("")
+			HandleError500(w)
+			return
+		}
+
+		err = operators.Create(r.Context(), &repositories.Operator{
+			ID:        id.String(),
+			Username:  username,
+			Password:  hash,
+			Role:      configs.ADMIN,
+			CreatedAt: time.Now().Unix(),
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("")
+			HandleError500(w)
+			return
+		}
+
+		auditActor(r, id.String())
+		configs.ClearSetupToken()
+		log.Info().Str("username", username).Msg("Superuser bootstrapped, setup token invalidated")
+
+		resp := createSuperUserResp{
+			Username: username,
+			Password: plaintext,
+		}
+		json.NewEncoder(w).Encode(resp)
 	}
-
-	statement, err := database.Db.Prepare("INSERT INTO superuser (id, username, password) VALUES (?, ?, ?)")
-	if err != nil {
-		log.Error().Err(err).Msg("")
-		HandleError500(w)
-		return
-	}
-	defer statement.Close()
-
-	if _, err = statement.Exec(id.String(), username, string(hash)); err != nil {
-		log.Error().Err(err).Msg("")
-		HandleError500(w)
-		return
-	}
-
-	resp := createSuperUserResp{
-		Username: username,
-		Password: plaintext,
-	}
-	json.NewEncoder(w).Encode(resp)
 }
 
-func generateHash() (string, string, error) {
+func generatePassword() (string, error) {
 	uuid, err := uuid.NewRandom()
 	if err != nil {
-		return "", "", err
+		return "", err
 	}
 
+	return uuid.String(), nil
+}
+
+func generateHash(plain string) (string, error) {
 	hashConfig := configs.DefaultHashConfig()
 
 	salt := make([]byte, hashConfig.KeyLen)
 	if _, err := rand.Read(salt); err != nil {
-		return "", "", err
+		return "", err
 	}
 
-	hash := argon2.IDKey([]byte(uuid.String()), salt, hashConfig.Time, hashConfig.Memory, hashConfig.Threads, hashConfig.KeyLen)
+	hash := argon2.IDKey([]byte(plain), salt, hashConfig.Time, hashConfig.Memory, hashConfig.Threads, hashConfig.KeyLen)
 	encodedSalt := base64.RawStdEncoding.EncodeToString(salt)
 	encodedHash := base64.RawStdEncoding.EncodeToString(hash)
 	format := "$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s"
 
-	return fmt.Sprintf(format, argon2.Version, hashConfig.Memory, hashConfig.Time, hashConfig.Threads, encodedSalt, encodedHash), uuid.String(), nil
+	return fmt.Sprintf(format, argon2.Version, hashConfig.Memory, hashConfig.Time, hashConfig.Threads, encodedSalt, encodedHash), nil
 }
@@ -6,7 +6,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
-	"github.com/zoundwavedj/cybersecurity/database"
+	"github.com/zoundwavedj/cybersecurity/repositories"
 	"github.com/zoundwavedj/cybersecurity/utils"
 )
 
@@ -26,43 +26,82 @@ type createUserResp struct {
 }
 
 // CreateUserHandler function to create users
-func CreateUserHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+func CreateUserHandler(users repositories.UserRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
 
-	var req createUserReq
+		reveal, msg, code := parseReveal(r)
+		if msg != "" {
+			HandleError(w, msg, code)
+			return
+		}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		HandleError(w, "Invalid request body", http.StatusBadRequest)
-		return
+		var req createUserReq
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			HandleError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		user := &repositories.User{
+			ID:    uuid.New().String(),
+			Name:  req.Name,
+			Dob:   req.Dob,
+			Email: req.Email,
+			Ssn:   req.Ssn,
+		}
+
+		auditTarget(r, user.ID)
+
+		err := IndexUser(user)
+		if err != nil {
+			log.Err(err).Msg("")
+			HandleError500(w)
+			return
+		}
+
+		if err = users.Create(r.Context(), user); err != nil {
+			if err == repositories.ErrConflict {
+				HandleError(w, "A user with this SSN already exists", http.StatusConflict)
+				return
+			}
+
+			log.Err(err).Msg("")
+			HandleError500(w)
+			return
+		}
+
+		json.NewEncoder(w).Encode(&createUserResp{
+			ID:    user.ID,
+			Name:  user.Name,
+			Dob:   user.Dob,
+			Email: user.Email,
+			Ssn:   shapeSsn(r, user, reveal),
+		})
+	}
+}
+
+// IndexUser function to compute the blind indexes of a user, shared with the index command
+func IndexUser(user *repositories.User) error {
+	var err error
+
+	if user.SsnIndex, err = utils.SsnIndex(user.Ssn); err != nil {
+		return err
 	}
 
-	statement, err := database.Db.Prepare("INSERT INTO user (id, name, dob, email, ssn) VALUES (?, ?, ?, ?, ?)")
-	if err != nil {
-		log.Err(err).Msg("")
-		HandleError500(w)
-		return
+	if user.EmailIndex, err = utils.EmailIndex(user.Email); err != nil {
+		return err
 	}
-	defer statement.Close()
-
-	id := uuid.New().String()
-	encryptedSsn, err := utils.Encrypt(req.Ssn)
-	if err != nil {
-		log.Err(err).Msg("")
-		HandleError500(w)
-		return
+
+	if user.EmailDomainIndex, err = utils.EmailDomainIndex(user.Email); err != nil {
+		return err
 	}
 
-	if _, err := statement.Exec(id, req.Name, req.Dob, req.Email, encryptedSsn); err != nil {
-		log.Err(err).Msg("")
-		HandleError500(w)
-		return
+	if user.NamePrefixIndexes, err = utils.NamePrefixIndexes(user.Name); err != nil {
+		return err
 	}
 
-	json.NewEncoder(w).Encode(&createUserResp{
-		ID:    id,
-		Name:  req.Name,
-		Dob:   req.Dob,
-		Email: req.Email,
-		Ssn:   encryptedSsn,
-	})
+	user.DobYearIndex, err = utils.DobYearIndex(user.Dob)
+
+	return err
 }
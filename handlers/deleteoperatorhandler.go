@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"github.com/zoundwavedj/cybersecurity/repositories"
+)
+
+// DeleteOperatorHandler function to delete an operator account and revoke its tokens
+func DeleteOperatorHandler(operators repositories.OperatorRepository, sessions repositories.SessionRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		id := mux.Vars(r)["id"]
+		if !checkOperatorTarget(w, r, id) {
+			return
+		}
+
+		if _, err := operators.Get(r.Context(), id); err != nil {
+			if err == repositories.ErrNotFound {
+				HandleError(w, "Operator not found", http.StatusNotFound)
+				return
+			}
+
+			log.Err(err).Msg("")
+			HandleError500(w)
+			return
+		}
+
+		// Tokens go first, their rows reference the operator
+		revoked, err := sessions.RevokeAll(r.Context(), id)
+		if err != nil {
+			log.Err(err).Msg("")
+			HandleError500(w)
+			return
+		}
+
+		if err = operators.Delete(r.Context(), id); err != nil {
+			if err == repositories.ErrNotFound {
+				HandleError(w, "Operator not found", http.StatusNotFound)
+				return
+			}
+
+			log.Err(err).Msg("")
+			HandleError500(w)
+			return
+		}
+
+		log.Info().Str("operatorId", id).Int64("revoked", revoked).Msg("Operator deleted")
+
+		json.NewEncoder(w).Encode(&operatorActionResp{
+			Success: true,
+			Revoked: revoked,
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"github.com/zoundwavedj/cybersecurity/repositories"
+)
+
+type userActionResp struct {
+	Success bool `json:"success"`
+}
+
+// DeleteUserHandler function to delete a user, ?soft=true only hides it so it can be restored later
+func DeleteUserHandler(users repositories.UserRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		id := mux.Vars(r)["id"]
+		soft := r.URL.Query().Get("soft") == "true"
+
+		var err error
+
+		if soft {
+			err = users.SoftDelete(r.Context(), id, time.Now().Unix())
+		} else {
+			err = users.Delete(r.Context(), id)
+		}
+
+		if err != nil {
+			if err == repositories.ErrNotFound {
+				HandleError(w, "User not found", http.StatusNotFound)
+				return
+			}
+
+			log.Err(err).Msg("")
+			HandleError500(w)
+			return
+		}
+
+		log.Info().Str("userId", id).Bool("soft", soft).Msg("User deleted")
+
+		json.NewEncoder(w).Encode(&userActionResp{
+			Success: true,
+		})
+	}
+}
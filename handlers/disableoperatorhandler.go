@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"github.com/zoundwavedj/cybersecurity/configs"
+	"github.com/zoundwavedj/cybersecurity/repositories"
+)
+
+type operatorActionResp struct {
+	Success bool  `json:"success"`
+	Revoked int64 `json:"revokedTokens"`
+}
+
+// DisableOperatorHandler function to disable an operator account and revoke its tokens
+func DisableOperatorHandler(operators repositories.OperatorRepository, sessions repositories.SessionRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		id := mux.Vars(r)["id"]
+		if !checkOperatorTarget(w, r, id) {
+			return
+		}
+
+		if err := operators.Disable(r.Context(), id); err != nil {
+			if err == repositories.ErrNotFound {
+				HandleError(w, "Operator not found", http.StatusNotFound)
+				return
+			}
+
+			log.Err(err).Msg("")
+			HandleError500(w)
+			return
+		}
+
+		revoked, err := sessions.RevokeAll(r.Context(), id)
+		if err != nil {
+			log.Err(err).Msg("")
+			HandleError500(w)
+			return
+		}
+
+		log.Info().Str("operatorId", id).Int64("revoked", revoked).Msg("Operator disabled")
+
+		json.NewEncoder(w).Encode(&operatorActionResp{
+			Success: true,
+			Revoked: revoked,
+		})
+	}
+}
+
+// checkOperatorTarget stops operators from disabling or deleting themselves, which could leave no admin behind
+func checkOperatorTarget(w http.ResponseWriter, r *http.Request, id string) bool {
+	if claims, ok := configs.ClaimsFromContext(r.Context()); ok && claims.Subject == id {
+		HandleError(w, "You can't disable or delete your own account", http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
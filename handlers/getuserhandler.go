@@ -4,9 +4,9 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/gorilla/mux"
 	"github.com/rs/zerolog/log"
-	"github.com/zoundwavedj/cybersecurity/database"
-	"github.com/zoundwavedj/cybersecurity/utils"
+	"github.com/zoundwavedj/cybersecurity/repositories"
 )
 
 type getUserResp struct {
@@ -17,56 +17,52 @@ type getUserResp struct {
 	Ssn   string `json:"ssn,omitempty"`
 }
 
-// GetUserHandler function to retrieve a single user given ID
-func GetUserHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+// GetUserHandler function to retrieve a single user given ID, either as /users/{id} or the legacy /user?id=. The SSN is masked unless ?reveal=ssn
+func GetUserHandler(users repositories.UserRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
 
-	keys, ok := r.URL.Query()["id"]
-	if !ok || len(keys[0]) < 1 {
-		HandleError(w, "Invalid request", http.StatusBadRequest)
-		return
-	}
+		id := mux.Vars(r)["id"]
+		if id == "" {
+			id = r.URL.Query().Get("id")
+		}
 
-	id := keys[0]
+		if id == "" {
+			HandleError(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
 
-	rows, err := database.Db.Query("SELECT * FROM user WHERE id=?", id)
-	if err != nil {
-		log.Err(err).Msg("")
-		HandleError500(w)
-		return
-	}
-	defer rows.Close()
+		// The legacy route has no {id} for the audit middleware to pick up
+		auditTarget(r, id)
 
-	if !rows.Next() {
-		HandleError(w, "User not found", http.StatusNotFound)
-		return
-	}
+		reveal, msg, code := parseReveal(r)
+		if msg != "" {
+			HandleError(w, msg, code)
+			return
+		}
 
-	var (
-		name  string
-		dob   string
-		email string
-		ssn   string
-	)
+		user, err := users.Get(r.Context(), id)
+		if err != nil {
+			if err == repositories.ErrNotFound {
+				HandleError(w, "User not found", http.StatusNotFound)
+				return
+			}
 
-	if err = rows.Scan(&id, &name, &dob, &email, &ssn); err != nil {
-		log.Err(err).Msg("")
-		HandleError500(w)
-		return
-	}
+			log.Err(err).Msg("")
+			HandleError500(w)
+			return
+		}
 
-	decryptedSsn, err := utils.Decrypt(ssn)
-	if err != nil {
-		log.Err(err).Msg("")
-		HandleError500(w)
-		return
+		json.NewEncoder(w).Encode(newGetUserResp(r, user, reveal))
 	}
+}
 
-	json.NewEncoder(w).Encode(&getUserResp{
-		ID:    id,
-		Name:  name,
-		Dob:   dob,
-		Email: email,
-		Ssn:   decryptedSsn,
-	})
+func newGetUserResp(r *http.Request, user *repositories.User, reveal bool) *getUserResp {
+	return &getUserResp{
+		ID:    user.ID,
+		Name:  user.Name,
+		Dob:   user.Dob,
+		Email: user.Email,
+		Ssn:   shapeSsn(r, user, reveal),
+	}
 }
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/zoundwavedj/cybersecurity/configs"
+	"github.com/zoundwavedj/cybersecurity/repositories"
+	"github.com/zoundwavedj/cybersecurity/stores"
+)
+
+// issueTokens generates an access and refresh token pair for an operator and records both under the token family
+func issueTokens(ctx context.Context, sessions repositories.SessionRepository, operator *repositories.Operator, familyID string) (string, string, error) {
+	accessID, accessExpiry, accessToken, err := configs.GenerateAccessToken(operator.ID, operator.Role)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshID, refreshExpiry, refreshToken, err := configs.GenerateRefreshToken(operator.ID)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err = sessions.Issue(ctx, stores.Token{UserID: operator.ID, FamilyID: familyID, Token: accessID, ExpiresAt: accessExpiry}); err != nil {
+		return "", "", err
+	}
+
+	if err = sessions.Issue(ctx, stores.Token{UserID: operator.ID, FamilyID: familyID, Token: refreshID, ExpiresAt: refreshExpiry}); err != nil {
+		return "", "", err
+	}
+
+	return accessToken, refreshToken, nil
+}
@@ -0,0 +1,16 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/zoundwavedj/cybersecurity/configs"
+)
+
+// JwksHandler function to publish the public keys that verify access tokens
+func JwksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	json.NewEncoder(w).Encode(configs.PublicJWKS())
+}
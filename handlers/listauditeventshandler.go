@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"github.com/zoundwavedj/cybersecurity/repositories"
+)
+
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+)
+
+type auditEventResp struct {
+	Seq        int64  `json:"seq"`
+	OccurredAt int64  `json:"occurredAt"`
+	Actor      string `json:"actor"`
+	Action     string `json:"action"`
+	Target     string `json:"target"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"userAgent"`
+	Outcome    string `json:"outcome"`
+	Details    string `json:"details"`
+	PrevHash   string `json:"prevHash"`
+	Hash       string `json:"hash"`
+}
+
+type listAuditEventsResp struct {
+	Events    []auditEventResp `json:"events"`
+	NextAfter int64            `json:"nextAfter,omitempty"`
+}
+
+// ListAuditEventsHandler function to query the audit log in append order. Filters actor, action, target and outcome match exactly,
+// from and to bound occurredAt in unix seconds, and nextAfter is passed back as after to fetch the next page
+func ListAuditEventsHandler(audit repositories.AuditRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		params := r.URL.Query()
+
+		query := repositories.AuditQuery{
+			Limit:   defaultAuditPageSize,
+			Actor:   params.Get("actor"),
+			Action:  params.Get("action"),
+			Target:  params.Get("target"),
+			Outcome: params.Get("outcome"),
+		}
+
+		numbers := []struct {
+			name  string
+			value *int64
+		}{
+			{"after", &query.AfterSeq},
+			{"from", &query.From},
+			{"to", &query.To},
+		}
+
+		for _, number := range numbers {
+			if raw := params.Get(number.name); raw != "" {
+				n, err := strconv.ParseInt(raw, 10, 64)
+				if err != nil || n < 0 {
+					HandleError(w, number.name+" must be a positive integer", http.StatusBadRequest)
+					return
+				}
+
+				*number.value = n
+			}
+		}
+
+		if limit := params.Get("limit"); limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil || n < 1 || n > maxAuditPageSize {
+				HandleError(w, "limit must be between 1 and "+strconv.Itoa(maxAuditPageSize), http.StatusBadRequest)
+				return
+			}
+
+			query.Limit = n
+		}
+
+		// One extra row tells if there's a next page without a separate count
+		limit := query.Limit
+		query.Limit++
+
+		events, err := audit.List(r.Context(), query)
+		if err != nil {
+			log.Err(err).Msg("")
+			HandleError500(w)
+			return
+		}
+
+		resp := listAuditEventsResp{
+			Events: []auditEventResp{},
+		}
+
+		if len(events) > limit {
+			events = events[:limit]
+			resp.NextAfter = events[len(events)-1].Seq
+		}
+
+		for _, event := range events {
+			resp.Events = append(resp.Events, auditEventResp{
+				Seq:        event.Seq,
+				OccurredAt: event.OccurredAt,
+				Actor:      event.Actor,
+				Action:     event.Action,
+				Target:     event.Target,
+				IP:         event.IP,
+				UserAgent:  event.UserAgent,
+				Outcome:    event.Outcome,
+				Details:    event.Details,
+				PrevHash:   event.PrevHash,
+				Hash:       event.Hash,
+			})
+		}
+
+		json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+	"github.com/zoundwavedj/cybersecurity/repositories"
+)
+
+type operatorResp struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	Disabled  bool   `json:"disabled"`
+	CreatedAt int64  `json:"createdAt,omitempty"`
+}
+
+type listOperatorsResp struct {
+	Operators []operatorResp `json:"operators"`
+}
+
+// ListOperatorsHandler function to list operator accounts
+func ListOperatorsHandler(operators repositories.OperatorRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		operatorList, err := operators.List(r.Context())
+		if err != nil {
+			log.Err(err).Msg("")
+			HandleError500(w)
+			return
+		}
+
+		var resp listOperatorsResp
+		resp.Operators = []operatorResp{}
+
+		for _, operator := range operatorList {
+			resp.Operators = append(resp.Operators, operatorResp{
+				ID:        operator.ID,
+				Username:  operator.Username,
+				Role:      operator.Role,
+				Disabled:  operator.Disabled,
+				CreatedAt: operator.CreatedAt,
+			})
+		}
+
+		json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -1,43 +1,316 @@
 package handlers
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/rs/zerolog/log"
-	"github.com/zoundwavedj/cybersecurity/database"
+	"github.com/zoundwavedj/cybersecurity/configs"
+	"github.com/zoundwavedj/cybersecurity/repositories"
+	"github.com/zoundwavedj/cybersecurity/utils"
+)
+
+const (
+	defaultUsersPageSize = 50
+	maxUsersPageSize     = 500
+	// maxDobYears bounds the birth years a dobFrom/dobTo range expands into
+	maxDobYears = 150
 )
 
 type listUsersResp struct {
-	Users []string `json:"users"`
+	Users      interface{} `json:"users"`
+	NextCursor string      `json:"nextCursor,omitempty"`
 }
 
-// ListUsersHandler function to list all user IDs
-func ListUsersHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+type userSummary struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Ssn   string `json:"ssn,omitempty"`
+}
 
-	rows, err := database.Db.Query("SELECT id FROM user")
-	if err != nil {
-		log.Err(err).Msg("")
-		HandleError500(w)
-		return
-	}
-	defer rows.Close()
+// usersCursor is the opaque cursor handed to clients, it's bound to the order it was issued for
+type usersCursor struct {
+	Sort string `json:"s"`
+	ID   string `json:"id"`
+}
 
-	var resp listUsersResp
-	resp.Users = []string{}
+// ListUsersHandler function to list users one page at a time, as bare IDs or as summaries with ?view=summary. ?ssn=, ?email=, ?emailDomain=,
+// ?name= (a prefix) and ?dobFrom=/?dobTo= (years) match exactly through their blind indexes, ?dob= through its deterministic ciphertext
+func ListUsersHandler(users repositories.UserRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
 
-	for rows.Next() {
-		var id string
+		params := r.URL.Query()
+
+		if params.Get("reveal") != "" {
+			HandleError(w, "reveal is only supported on single users", http.StatusBadRequest)
+			return
+		}
+
+		query, msg := parseUserQuery(params)
+		if msg != "" {
+			HandleError(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		// Looking a user up by SSN discloses whether we hold it, so it needs the same access as reading it
+		if params.Get("ssn") != "" && !configs.HasPermission(r.Context(), configs.UsersReadPII) {
+			HandleError(w, "Looking users up by SSN requires "+string(configs.UsersReadPII), http.StatusForbidden)
+			return
+		}
 
-		if err = rows.Scan(&id); err != nil {
+		if err := blindIndexFilters(params, &query); err != nil {
+			if err == errEmptyFilter {
+				HandleError(w, "ssn, email, emailDomain and name must not be blank", http.StatusBadRequest)
+				return
+			}
+
+			log.Err(err).Msg("")
+			HandleError500(w)
+			return
+		}
+
+		sort := params.Get("sort")
+		if sort == "" {
+			sort = "id"
+		}
+
+		if cursor := params.Get("cursor"); cursor != "" {
+			after, err := decodeUsersCursor(cursor, sort)
+			if err != nil {
+				HandleError(w, "Invalid cursor", http.StatusBadRequest)
+				return
+			}
+
+			query.After = after
+		}
+
+		// One extra row tells if there's a next page without a separate count
+		limit := query.Limit
+		query.Limit++
+
+		summary := params.Get("view") == "summary"
+		query.IDOnly = !summary
+
+		page, err := users.List(r.Context(), query)
+		if err != nil {
 			log.Err(err).Msg("")
 			HandleError500(w)
 			return
 		}
 
-		resp.Users = append(resp.Users, id)
+		var resp listUsersResp
+
+		if len(page) > limit {
+			page = page[:limit]
+			resp.NextCursor = encodeUsersCursor(page[len(page)-1].ID, sort)
+		}
+
+		if summary {
+			resp.Users = summarizeUsers(r, page)
+		} else {
+			ids := make([]string, len(page))
+			for i, user := range page {
+				ids[i] = user.ID
+			}
+
+			resp.Users = ids
+		}
+
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func parseUserQuery(params url.Values) (repositories.UserQuery, string) {
+	query := repositories.UserQuery{
+		Limit: defaultUsersPageSize,
+		Dob:   params.Get("dob"),
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxUsersPageSize {
+			return query, "limit must be between 1 and " + strconv.Itoa(maxUsersPageSize)
+		}
+
+		query.Limit = n
+	}
+
+	if sort := params.Get("sort"); sort != "" {
+		query.Descending = strings.HasPrefix(sort, "-")
+
+		if strings.TrimPrefix(sort, "-") != "id" {
+			return query, "users can only be sorted by id, prefixed with - for descending order, names, dates of birth and emails are encrypted"
+		}
+	}
+
+	if _, err := time.Parse("2006-01-02", query.Dob); query.Dob != "" && err != nil {
+		return query, "dob must be formatted as YYYY-MM-DD"
+	}
+
+	// Names are encrypted, prefixes are matched through the indexes stored for each of their first characters
+	if name := strings.TrimSpace(params.Get("name")); name != "" {
+		if n := len([]rune(name)); n < utils.MinNamePrefix || n > utils.MaxNamePrefix {
+			return query, "name must be " + strconv.Itoa(utils.MinNamePrefix) + " to " + strconv.Itoa(utils.MaxNamePrefix) + " characters long"
+		}
+	}
+
+	if _, _, msg := dobYearRange(params); msg != "" {
+		return query, msg
+	}
+
+	return query, ""
+}
+
+// dobYearRange reads the inclusive range of birth years in dobFrom and dobTo, dates of birth are encrypted and only indexed by year.
+// An open end extends to the current year or maxDobYears before the other end
+func dobYearRange(params url.Values) (from int, to int, msg string) {
+	fromParam, toParam := params.Get("dobFrom"), params.Get("dobTo")
+	if fromParam == "" && toParam == "" {
+		return 0, 0, ""
+	}
+
+	msg = "dobFrom and dobTo must be years formatted as YYYY, at most " + strconv.Itoa(maxDobYears) + " apart"
+	to = time.Now().Year()
+
+	for _, param := range []struct {
+		value  string
+		target *int
+	}{{fromParam, &from}, {toParam, &to}} {
+		if param.value == "" {
+			continue
+		}
+
+		year, err := time.Parse("2006", param.value)
+		if err != nil {
+			return 0, 0, msg
+		}
+
+		*param.target = year.Year()
+	}
+
+	if fromParam == "" {
+		from = to - maxDobYears + 1
+	}
+
+	if from > to || to-from >= maxDobYears {
+		return 0, 0, msg
+	}
+
+	return from, to, ""
+}
+
+var errEmptyFilter = errors.New("Blank exact match filter")
+
+// blindIndexFilters turns the ssn, email, emailDomain, name and dob year params into blind index lookups, a value that indexes to nothing
+// would otherwise match everyone
+func blindIndexFilters(params url.Values, query *repositories.UserQuery) error {
+	var err error
+
+	if name := params.Get("name"); name != "" {
+		if query.NamePrefixIndex, err = utils.NamePrefixIndex(name); err != nil {
+			return err
+		}
+
+		if query.NamePrefixIndex == "" {
+			return errEmptyFilter
+		}
+	}
+
+	if params.Get("dobFrom") != "" || params.Get("dobTo") != "" {
+		from, to, _ := dobYearRange(params)
+
+		for year := from; year <= to; year++ {
+			index, err := utils.YearIndex(year)
+			if err != nil {
+				return err
+			}
+
+			query.DobYearIndexes = append(query.DobYearIndexes, index)
+		}
+	}
+
+	if ssn := params.Get("ssn"); ssn != "" {
+		if query.SsnIndex, err = utils.SsnIndex(ssn); err != nil {
+			return err
+		}
+
+		if query.SsnIndex == "" {
+			return errEmptyFilter
+		}
+	}
+
+	if email := params.Get("email"); email != "" {
+		if query.EmailIndex, err = utils.EmailIndex(email); err != nil {
+			return err
+		}
+
+		if query.EmailIndex == "" {
+			return errEmptyFilter
+		}
+	}
+
+	if domain := params.Get("emailDomain"); domain != "" {
+		if query.EmailDomainIndex, err = utils.DomainIndex(strings.TrimPrefix(domain, "@")); err != nil {
+			return err
+		}
+
+		if query.EmailDomainIndex == "" {
+			return errEmptyFilter
+		}
+	}
+
+	return nil
+}
+
+func encodeUsersCursor(afterID string, sort string) string {
+	raw, _ := json.Marshal(&usersCursor{
+		Sort: sort,
+		ID:   afterID,
+	})
+
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeUsersCursor(encoded string, sort string) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	var cursor usersCursor
+
+	if err = json.Unmarshal(raw, &cursor); err != nil {
+		return "", err
+	}
+
+	// A cursor only makes sense for the ordering it was issued for
+	if cursor.Sort != sort || cursor.ID == "" {
+		return "", errors.New("Cursor doesn't match sort")
+	}
+
+	return cursor.ID, nil
+}
+
+// summarizeUsers builds summary records, SSNs are always masked in listings
+func summarizeUsers(r *http.Request, page []*repositories.User) []userSummary {
+	summaries := make([]userSummary, len(page))
+
+	for i, user := range page {
+		summaries[i] = userSummary{
+			ID:    user.ID,
+			Name:  user.Name,
+			Email: user.Email,
+			Ssn:   shapeSsn(r, user, false),
+		}
 	}
 
-	json.NewEncoder(w).Encode(resp)
+	return summaries
 }
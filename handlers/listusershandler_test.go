@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/zoundwavedj/cybersecurity/database"
+	"github.com/zoundwavedj/cybersecurity/repositories"
+)
+
+// recordingUsers remembers the last listing asked of the repository it wraps
+type recordingUsers struct {
+	repositories.UserRepository
+	last repositories.UserQuery
+}
+
+func (u *recordingUsers) List(ctx context.Context, query repositories.UserQuery) ([]*repositories.User, error) {
+	u.last = query
+	return u.UserRepository.List(ctx, query)
+}
+
+type listedUsers struct {
+	Users      json.RawMessage `json:"users"`
+	NextCursor string          `json:"nextCursor"`
+}
+
+// listIDs gets target as a caller with PII access and decodes the IDs it lists
+func listIDs(t *testing.T, router http.Handler, target string) ([]string, string) {
+	t.Helper()
+
+	w, _ := serveAs(router, http.MethodGet, target, "", readPII...)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s answered %d %s, want %d", target, w.Code, w.Body.String(), http.StatusOK)
+	}
+
+	var resp listedUsers
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []string{}
+	if err := json.Unmarshal(resp.Users, &ids); err != nil {
+		t.Fatalf("GET %s listed %s, want bare IDs", target, resp.Users)
+	}
+
+	return ids, resp.NextCursor
+}
+
+// walkPages follows the cursors from target until the last page, returning every ID listed
+func walkPages(t *testing.T, router http.Handler, target string) []string {
+	t.Helper()
+
+	var all []string
+
+	for page := 0; ; page++ {
+		if page > 100 {
+			t.Fatalf("pages of %s never ran out", target)
+		}
+
+		ids, cursor := listIDs(t, router, target)
+		all = append(all, ids...)
+
+		if cursor == "" {
+			return all
+		}
+
+		next, err := url.Parse(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		params := next.Query()
+		params.Set("cursor", cursor)
+		next.RawQuery = params.Encode()
+		target = next.String()
+	}
+}
+
+func newListFixture(t *testing.T) (*recordingUsers, http.Handler) {
+	t.Helper()
+
+	users := &recordingUsers{UserRepository: repositories.NewSQLUserRepository(testDB(t), database.SQLITE)}
+	createTestUsers(t, users,
+		repositories.User{ID: "u1", Name: "Ada", Dob: "1815-12-10", Email: "ada@example.com", Ssn: "111-11-1111"},
+		repositories.User{ID: "u2", Name: "Alan", Dob: "1912-06-23", Email: "alan@example.com"},
+		repositories.User{ID: "u3", Name: "Grace", Dob: "1906-12-09", Email: "grace@navy.mil", Ssn: "333-33-3333"},
+		repositories.User{ID: "u4", Name: "Abc", Dob: "1906-12-09"},
+		repositories.User{ID: "u5", Name: "Abz", Dob: "1920-01-01"},
+	)
+
+	return users, usersRouter(users)
+}
+
+func TestListUsersLimits(t *testing.T) {
+	users, router := newListFixture(t)
+
+	for _, limit := range []string{"0", "-1", "501", "ten"} {
+		w, _ := serveAs(router, http.MethodGet, "/users?limit="+limit, "", readPII...)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("GET /users?limit=%s answered %d, want %d", limit, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	// One more row than the page is read to know whether there's a next one
+	if ids, cursor := listIDs(t, router, "/users"); len(ids) != 5 || cursor != "" || users.last.Limit != defaultUsersPageSize+1 {
+		t.Errorf("GET /users listed %v with cursor %q after reading %d rows, want every user on one page of %d", ids, cursor, users.last.Limit, defaultUsersPageSize)
+	}
+
+	if ids, cursor := listIDs(t, router, "/users?limit=500"); len(ids) != 5 || cursor != "" {
+		t.Errorf("GET /users?limit=500 listed %v with cursor %q, want every user", ids, cursor)
+	}
+
+	if ids, cursor := listIDs(t, router, "/users?limit=5"); len(ids) != 5 || cursor != "" {
+		t.Errorf("GET /users?limit=5 listed %v with cursor %q, want every user and no next page", ids, cursor)
+	}
+
+	if ids, cursor := listIDs(t, router, "/users?limit=4"); len(ids) != 4 || cursor == "" {
+		t.Errorf("GET /users?limit=4 listed %v with cursor %q, want 4 users and a next page", ids, cursor)
+	}
+}
+
+func TestListUsersPaging(t *testing.T) {
+	_, router := newListFixture(t)
+
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{"/users?limit=2", []string{"u1", "u2", "u3", "u4", "u5"}},
+		{"/users?limit=2&sort=id", []string{"u1", "u2", "u3", "u4", "u5"}},
+		{"/users?limit=2&sort=-id", []string{"u5", "u4", "u3", "u2", "u1"}},
+		{"/users?limit=1&sort=-id&name=ab", []string{"u5", "u4"}},
+	}
+
+	for _, test := range tests {
+		if got := walkPages(t, router, test.target); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("pages of %s listed %v, want %v", test.target, got, test.want)
+		}
+	}
+
+	// A cursor only carries on the order it was issued for
+	_, ascending := listIDs(t, router, "/users?limit=2")
+	_, descending := listIDs(t, router, "/users?limit=2&sort=-id")
+
+	for _, target := range []string{
+		"/users?limit=2&sort=-id&cursor=" + ascending,
+		"/users?limit=2&cursor=" + descending,
+		"/users?limit=2&cursor=not-a-cursor",
+		"/users?limit=2&sort=name",
+	} {
+		if w, _ := serveAs(router, http.MethodGet, target, "", readPII...); w.Code != http.StatusBadRequest {
+			t.Errorf("GET %s answered %d, want %d", target, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestListUsersFilters(t *testing.T) {
+	users, router := newListFixture(t)
+
+	tests := []struct {
+		params string
+		want   []string
+	}{
+		{"name=ab", []string{"u4", "u5"}},
+		{"name=AL", []string{"u2"}},
+		{"email=ADA@example.com", []string{"u1"}},
+		{"emailDomain=example.com", []string{"u1", "u2"}},
+		{"emailDomain=@navy.mil", []string{"u3"}},
+		{"ssn=333333333", []string{"u3"}},
+		{"dob=1906-12-09", []string{"u3", "u4"}},
+		{"dobFrom=1900&dobTo=1915", []string{"u2", "u3", "u4"}},
+		{"dobTo=1850", []string{"u1"}},
+		{"name=ab&dobFrom=1910", []string{"u5"}},
+		{"name=zz", []string{}},
+	}
+
+	for _, test := range tests {
+		if got, _ := listIDs(t, router, "/users?"+test.params); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("GET /users?%s listed %v, want %v", test.params, got, test.want)
+		}
+
+		// Bare IDs are all a listing needs, nothing else is read or decrypted
+		if !users.last.IDOnly {
+			t.Errorf("GET /users?%s read every field, want the IDs only", test.params)
+		}
+	}
+
+	for _, params := range []string{"name=a", "name=abcdefghijk", "ssn=---", "email=%20", "dob=1906", "dobFrom=19", "dobFrom=1915&dobTo=1900", "dobFrom=1700&dobTo=1900", "reveal=ssn"} {
+		if w, _ := serveAs(router, http.MethodGet, "/users?"+params, "", readPII...); w.Code != http.StatusBadRequest {
+			t.Errorf("GET /users?%s answered %d, want %d", params, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	// Looking up by SSN tells whether it's on file, so it takes PII access
+	if w, _ := serveAs(router, http.MethodGet, "/users?ssn=333333333", "", readOnly...); w.Code != http.StatusForbidden {
+		t.Errorf("GET /users?ssn= without PII access answered %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestListUsersSummaries(t *testing.T) {
+	users, router := newListFixture(t)
+
+	w, _ := serveAs(router, http.MethodGet, "/users?view=summary", "", revealer...)
+
+	var resp struct {
+		Users []userSummary `json:"users"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []userSummary{
+		{ID: "u1", Name: "Ada", Email: "ada@example.com", Ssn: "***-**-1111"},
+		{ID: "u2", Name: "Alan", Email: "alan@example.com"},
+		{ID: "u3", Name: "Grace", Email: "grace@navy.mil", Ssn: "***-**-3333"},
+		{ID: "u4", Name: "Abc"},
+		{ID: "u5", Name: "Abz"},
+	}
+
+	if !reflect.DeepEqual(resp.Users, want) || users.last.IDOnly {
+		t.Errorf("GET /users?view=summary listed %+v, want %+v with SSNs masked", resp.Users, want)
+	}
+
+	w, _ = serveAs(router, http.MethodGet, "/users?view=summary&name=ada", "", readOnly...)
+	if body := w.Body.String(); body != `{"users":[{"id":"u1","name":"Ada","email":"ada@example.com"}]}`+"\n" {
+		t.Errorf("GET /users?view=summary without PII access answered %s, want no SSN", body)
+	}
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/zoundwavedj/cybersecurity/configs"
+	"github.com/zoundwavedj/cybersecurity/database"
+	"github.com/zoundwavedj/cybersecurity/utils"
+)
+
+func TestMain(m *testing.M) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	if err := configs.SetupSigningKeys(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	keys, err := utils.NewStaticKeyProvider("1", map[string][]byte{"1": bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	utils.Keys = keys
+
+	os.Setenv("DETERMINISTIC_KEY", strings.Repeat("02", 32))
+	os.Setenv("BLIND_INDEX_KEY", strings.Repeat("03", 32))
+
+	os.Exit(m.Run())
+}
+
+// testDB opens a fresh, fully migrated SQLite database and points database.Db at it for the length of the test
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open(string(database.SQLITE), "file:"+filepath.Join(t.TempDir(), "test.db")+"?cache=shared&mode=rwc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previousDb, previousDriver := database.Db, database.Driver
+	database.Db, database.Driver = db, database.SQLITE
+
+	t.Cleanup(func() {
+		db.Close()
+		database.Db, database.Driver = previousDb, previousDriver
+	})
+
+	if _, err = database.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/zoundwavedj/cybersecurity/configs"
+	"github.com/zoundwavedj/cybersecurity/repositories"
+	"github.com/zoundwavedj/cybersecurity/utils"
+)
+
+// revealSsn is the only field ?reveal= unmasks
+const revealSsn = "ssn"
+
+// parseReveal checks ?reveal= before anything else runs, returns whether the full SSN was asked for or an error message and status
+func parseReveal(r *http.Request) (bool, string, int) {
+	switch r.URL.Query().Get("reveal") {
+	case "":
+		return false, "", 0
+	case revealSsn:
+		if !configs.HasPermission(r.Context(), configs.UsersRevealPII) {
+			return false, "Revealing SSNs requires " + string(configs.UsersRevealPII), http.StatusForbidden
+		}
+
+		return true, "", 0
+	}
+
+	return false, "reveal only supports ssn", http.StatusBadRequest
+}
+
+// shapeSsn applies the redaction policy to a user's SSN: left out without PII access, masked by default,
+// and only in full when explicitly revealed by a caller allowed to, which is recorded in the request's audit event
+func shapeSsn(r *http.Request, user *repositories.User, reveal bool) string {
+	if reveal {
+		auditDetail(r, "reveal", revealSsn)
+		return user.Ssn
+	}
+
+	if configs.HasPermission(r.Context(), configs.UsersReadPII) {
+		return utils.MaskSsn(user.Ssn)
+	}
+
+	return ""
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/zoundwavedj/cybersecurity/configs"
+	"github.com/zoundwavedj/cybersecurity/database"
+	"github.com/zoundwavedj/cybersecurity/repositories"
+)
+
+// usersRouter routes the user handlers the way main.go does, minus the middlewares
+func usersRouter(users repositories.UserRepository) *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/users", ListUsersHandler(users)).Methods(http.MethodGet)
+	r.HandleFunc("/users", CreateUserHandler(users)).Methods(http.MethodPost)
+	r.HandleFunc("/users/{id}", GetUserHandler(users)).Methods(http.MethodGet)
+	r.HandleFunc("/users/{id}", UpdateUserHandler(users)).Methods(http.MethodPut, http.MethodPatch)
+
+	return r
+}
+
+// serveAs sends a request through router as a caller holding permissions, returning the response and the request's audit event
+func serveAs(router http.Handler, method string, target string, body string, permissions ...configs.Permission) (*httptest.ResponseRecorder, *repositories.AuditEvent) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+
+	event := &repositories.AuditEvent{}
+	claims := &configs.Claims{Permissions: permissions}
+	req = req.WithContext(configs.WithAuditEvent(configs.WithClaims(req.Context(), claims), event))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	return w, event
+}
+
+func createTestUsers(t *testing.T, users repositories.UserRepository, list ...repositories.User) {
+	t.Helper()
+
+	for _, user := range list {
+		user := user
+
+		if err := IndexUser(&user); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := users.Create(context.Background(), &user); err != nil {
+			t.Fatalf("Create of %s returned %v", user.ID, err)
+		}
+	}
+}
+
+// responseSsn decodes the ssn field of a user response, ok is false when it's left out
+func responseSsn(t *testing.T, w *httptest.ResponseRecorder) (ssn string, ok bool) {
+	t.Helper()
+
+	var resp map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("response %q isn't JSON: %v", w.Body.String(), err)
+	}
+
+	value, ok := resp["ssn"]
+	if !ok {
+		return "", false
+	}
+
+	ssn, _ = value.(string)
+
+	return ssn, true
+}
+
+var (
+	readOnly = []configs.Permission{configs.UsersRead, configs.UsersWrite}
+	readPII  = []configs.Permission{configs.UsersRead, configs.UsersWrite, configs.UsersReadPII}
+	revealer = []configs.Permission{configs.UsersRead, configs.UsersWrite, configs.UsersReadPII, configs.UsersRevealPII}
+)
+
+func TestGetUserRedactsSsn(t *testing.T) {
+	users := repositories.NewSQLUserRepository(testDB(t), database.SQLITE)
+	createTestUsers(t, users,
+		repositories.User{ID: "u1", Name: "Ada", Ssn: "123-45-6789"},
+		repositories.User{ID: "u2", Name: "Bob"},
+		repositories.User{ID: "u3", Name: "Cy", Ssn: "12"},
+	)
+
+	router := usersRouter(users)
+
+	tests := []struct {
+		name        string
+		target      string
+		permissions []configs.Permission
+		status      int
+		ssn         string
+		revealed    bool
+	}{
+		{"masked by default", "/users/u1", revealer, http.StatusOK, "***-**-6789", false},
+		{"masked with PII access", "/users/u1", readPII, http.StatusOK, "***-**-6789", false},
+		{"left out without PII access", "/users/u1", readOnly, http.StatusOK, "", false},
+		{"revealed on request", "/users/u1?reveal=ssn", revealer, http.StatusOK, "123-45-6789", true},
+		{"no reveal without the permission", "/users/u1?reveal=ssn", readPII, http.StatusForbidden, "", false},
+		{"no reveal of other fields", "/users/u1?reveal=name", revealer, http.StatusBadRequest, "", false},
+		{"no SSN on file", "/users/u2", revealer, http.StatusOK, "", false},
+		{"fewer than four digits on file", "/users/u3", revealer, http.StatusOK, "***-**-****", false},
+	}
+
+	for _, test := range tests {
+		w, event := serveAs(router, http.MethodGet, test.target, "", test.permissions...)
+
+		if w.Code != test.status {
+			t.Fatalf("GET %s %s answered %d %s, want %d", test.target, test.name, w.Code, w.Body.String(), test.status)
+		}
+
+		if strings.Contains(w.Body.String(), "6789") && test.ssn == "" {
+			t.Errorf("GET %s %s answered %s, want no SSN digits", test.target, test.name, w.Body.String())
+		}
+
+		if test.status == http.StatusOK {
+			if ssn, ok := responseSsn(t, w); ssn != test.ssn || ok != (test.ssn != "") {
+				t.Errorf("GET %s %s answered ssn %q (present %v), want %q", test.target, test.name, ssn, ok, test.ssn)
+			}
+		}
+
+		// Every reveal, and only a reveal, is noted in the audit event
+		if revealed := strings.Contains(event.Details, "reveal=ssn"); revealed != test.revealed {
+			t.Errorf("GET %s %s left audit details %q, want a reveal noted %v", test.target, test.name, event.Details, test.revealed)
+		}
+	}
+}
+
+func TestCreateAndUpdateUserRedactSsn(t *testing.T) {
+	db := testDB(t)
+	router := usersRouter(repositories.NewSQLUserRepository(db, database.SQLITE))
+
+	// The response is built from what was sent, not what was stored, and the SSN goes through the same policy
+	w, _ := serveAs(router, http.MethodPost, "/users", `{"name":"Ada","email":"ada@example.com","ssn":"123-45-6789"}`, readPII...)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /users answered %d %s", w.Code, w.Body.String())
+	}
+
+	var created getUserResp
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+
+	if created.Name != "Ada" || created.Email != "ada@example.com" || created.Ssn != "***-**-6789" {
+		t.Errorf("POST /users answered %+v, want Ada's plaintext name and email and a masked SSN", created)
+	}
+
+	var stored string
+	if err := db.QueryRow(`SELECT ssn FROM "user" WHERE id=?`, created.ID).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+
+	if stored == "" || strings.Contains(w.Body.String(), stored) {
+		t.Errorf("POST /users answered %s, want the stored ciphertext %q kept out of it", w.Body.String(), stored)
+	}
+
+	w, event := serveAs(router, http.MethodPost, "/users?reveal=ssn", `{"name":"Bob","ssn":"987-65-4321"}`, revealer...)
+	if ssn, _ := responseSsn(t, w); ssn != "987-65-4321" || !strings.Contains(event.Details, "reveal=ssn") {
+		t.Errorf("POST /users?reveal=ssn answered ssn %q with audit details %q, want it revealed and noted", ssn, event.Details)
+	}
+
+	w, _ = serveAs(router, http.MethodPost, "/users?reveal=ssn", `{"name":"Cy","ssn":"111-22-3333"}`, readPII...)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("POST /users?reveal=ssn without the permission answered %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	w, _ = serveAs(router, http.MethodPatch, "/users/"+created.ID, `{"ssn":"123-45-0000"}`, readPII...)
+	if ssn, _ := responseSsn(t, w); w.Code != http.StatusOK || ssn != "***-**-0000" {
+		t.Errorf("PATCH /users/%s answered %d with ssn %q, want the new SSN masked", created.ID, w.Code, ssn)
+	}
+
+	w, event = serveAs(router, http.MethodPatch, "/users/"+created.ID+"?reveal=ssn", `{"name":"Ada L."}`, revealer...)
+	if ssn, _ := responseSsn(t, w); ssn != "123-45-0000" || !strings.Contains(event.Details, "reveal=ssn") {
+		t.Errorf("PATCH /users/%s?reveal=ssn answered ssn %q with audit details %q, want it revealed and noted", created.ID, ssn, event.Details)
+	}
+}
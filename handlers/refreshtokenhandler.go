@@ -6,7 +6,7 @@ import (
 
 	"github.com/rs/zerolog/log"
 	"github.com/zoundwavedj/cybersecurity/configs"
-	"github.com/zoundwavedj/cybersecurity/database"
+	"github.com/zoundwavedj/cybersecurity/repositories"
 )
 
 type refreshTokenReq struct {
@@ -18,91 +18,66 @@ type refreshTokenResp struct {
 	RefreshToken string `json:"refreshToken,omitempty"`
 }
 
-var (
-	accessID      string
-	accessExpiry  int64
-	accessToken   string
-	refreshID     string
-	refreshExpiry int64
-	refreshToken  string
-)
-
 // RefreshTokenHandler func to recreate an expired token
-func RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+func RefreshTokenHandler(operators repositories.OperatorRepository, sessions repositories.SessionRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
 
-	var req refreshTokenReq
+		var req refreshTokenReq
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		HandleError(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			HandleError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
 
-	userID, oldRefreshID, err := configs.ValidateRefreshToken(req.Token)
-	if err != nil {
-		if err == configs.ErrTokenExpired || err == configs.ErrTokenMissing {
-			HandleError(w, err.Error(), http.StatusBadRequest)
+		stored, err := configs.ValidateRefreshToken(req.Token)
+		if err != nil {
+			handleRefreshError(w, err)
 			return
 		}
 
-		log.Err(err).Msg("")
-		HandleError500(w)
-		return
-	}
+		auditActor(r, stored.UserID)
 
-	accessID, accessExpiry, accessToken, err = configs.GenerateAccessToken()
-	if err != nil {
-		log.Err(err).Msg("")
-		HandleError500(w)
-		return
-	}
+		if err = configs.RotateRefreshToken(stored); err != nil {
+			handleRefreshError(w, err)
+			return
+		}
 
-	refreshID, refreshExpiry, refreshToken, err = configs.GenerateRefreshToken()
-	if err != nil {
-		log.Err(err).Msg("")
-		HandleError500(w)
-		return
-	}
+		// Operator is re-read so role changes and disabled accounts take effect on the next refresh
+		operator, err := operators.Get(r.Context(), stored.UserID)
+		if err != nil && err != repositories.ErrNotFound {
+			log.Err(err).Msg("")
+			HandleError500(w)
+			return
+		}
 
-	statement, err := database.Db.Prepare("INSERT INTO authentication (userId, token, expiresAt) VALUES (?, ?, ?)")
-	if err != nil {
-		log.Err(err).Msg("")
-		HandleError500(w)
-		return
-	}
-	defer statement.Close()
+		if operator == nil || operator.Disabled {
+			handleRefreshError(w, configs.ErrTokenMissing)
+			return
+		}
 
-	if _, err = statement.Exec(userID, accessID, accessExpiry); err != nil {
-		log.Err(err).Msg("")
-		HandleError500(w)
-		return
-	}
+		accessToken, refreshToken, err := issueTokens(r.Context(), sessions, operator, stored.FamilyID)
+		if err != nil {
+			log.Err(err).Msg("")
+			HandleError500(w)
+			return
+		}
 
-	if _, err = statement.Exec(userID, refreshID, refreshExpiry); err != nil {
-		log.Err(err).Msg("")
-		HandleError500(w)
-		return
-	}
+		resp := &refreshTokenResp{
+			AccessToken:  accessToken,
+			RefreshToken: refreshToken,
+		}
 
-	statement.Close()
-	statement, err = database.Db.Prepare("DELETE FROM authentication WHERE token=?")
-	if err != nil {
-		log.Err(err).Msg("")
-		HandleError500(w)
-		return
+		json.NewEncoder(w).Encode(resp)
 	}
-	defer statement.Close()
+}
 
-	if _, err = statement.Exec(oldRefreshID); err != nil {
-		log.Err(err).Msg("")
-		HandleError500(w)
+func handleRefreshError(w http.ResponseWriter, err error) {
+	if err == configs.ErrTokenExpired || err == configs.ErrTokenMissing || err == configs.ErrTokenReused {
+		HandleError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	resp := &refreshTokenResp{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-	}
-
-	json.NewEncoder(w).Encode(resp)
+	log.Err(err).Msg("")
+	HandleError500(w)
 }
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/zoundwavedj/cybersecurity/configs"
+)
+
+type keyResp struct {
+	ID       string     `json:"kid"`
+	Alg      string     `json:"alg"`
+	Active   bool       `json:"active"`
+	RetireAt *time.Time `json:"retireAt,omitempty"`
+}
+
+type reloadKeysResp struct {
+	Access  []keyResp `json:"access"`
+	Refresh []keyResp `json:"refresh"`
+}
+
+// ReloadKeysHandler function to re-read the keyring file so signing keys can be rotated without a restart
+func ReloadKeysHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := configs.ReloadKeyrings(); err != nil {
+		if err == configs.ErrNoKeyringFile {
+			HandleError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		log.Err(err).Msg("")
+		HandleError(w, "Keyring reload failed, previous keys are still in use", http.StatusUnprocessableEntity)
+		return
+	}
+
+	log.Info().Msg("Signing keys reloaded")
+
+	json.NewEncoder(w).Encode(&reloadKeysResp{
+		Access:  toKeyResps(configs.AccessKeyring().Keys()),
+		Refresh: toKeyResps(configs.RefreshKeyring().Keys()),
+	})
+}
+
+func toKeyResps(keys []*configs.SigningKey) []keyResp {
+	resps := []keyResp{}
+
+	for _, key := range keys {
+		resp := keyResp{
+			ID:     key.ID,
+			Alg:    key.Method.Alg(),
+			Active: key.Active,
+		}
+
+		if !key.RetireAt.IsZero() {
+			retireAt := key.RetireAt
+			resp.RetireAt = &retireAt
+		}
+
+		resps = append(resps, resp)
+	}
+
+	return resps
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"github.com/zoundwavedj/cybersecurity/repositories"
+)
+
+// RestoreUserHandler function to bring back a soft deleted user
+func RestoreUserHandler(users repositories.UserRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		id := mux.Vars(r)["id"]
+
+		if err := users.Restore(r.Context(), id); err != nil {
+			if err == repositories.ErrNotFound {
+				HandleError(w, "No deleted user with this ID", http.StatusNotFound)
+				return
+			}
+
+			log.Err(err).Msg("")
+			HandleError500(w)
+			return
+		}
+
+		log.Info().Str("userId", id).Msg("User restored")
+
+		json.NewEncoder(w).Encode(&userActionResp{
+			Success: true,
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/zoundwavedj/cybersecurity/repositories"
+)
+
+type unlockLoginReq struct {
+	Username string `json:"username,omitempty"`
+	IP       string `json:"ip,omitempty"`
+}
+
+// UnlockLoginHandler function to lift the login lockout of a username, an IP or both before it expires, their failure counts start over
+func UnlockLoginHandler(failures repositories.LoginFailureRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var req unlockLoginReq
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			HandleError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if strings.TrimSpace(req.Username) == "" && req.IP == "" {
+			HandleError(w, "username or ip is required", http.StatusBadRequest)
+			return
+		}
+
+		var subjects []string
+
+		if strings.TrimSpace(req.Username) != "" {
+			auditDetail(r, "username", strconv.Quote(req.Username))
+			subjects = append(subjects, userLockoutPrefix+strings.ToLower(req.Username))
+		}
+
+		if req.IP != "" {
+			ip := net.ParseIP(req.IP)
+			if ip == nil {
+				HandleError(w, "ip must be a valid IP address", http.StatusBadRequest)
+				return
+			}
+
+			auditDetail(r, "ip", ip.String())
+			subjects = append(subjects, ipLockoutPrefix+ip.String())
+		}
+
+		for _, subject := range subjects {
+			if err := failures.Clear(r.Context(), subject); err != nil {
+				log.Err(err).Msg("")
+				HandleError500(w)
+				return
+			}
+
+			log.Info().Str("subject", subject).Msg("Login lockout lifted")
+		}
+
+		json.NewEncoder(w).Encode(&userActionResp{
+			Success: true,
+		})
+	}
+}
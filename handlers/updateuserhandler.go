@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"github.com/zoundwavedj/cybersecurity/repositories"
+)
+
+type updateUserReq struct {
+	Name  *string `json:"name"`
+	Dob   *string `json:"dob"`
+	Email *string `json:"email"`
+	Ssn   *string `json:"ssn"`
+}
+
+// UpdateUserHandler function to update a user, PUT replaces every field while PATCH only touches the fields sent
+func UpdateUserHandler(users repositories.UserRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		reveal, msg, code := parseReveal(r)
+		if msg != "" {
+			HandleError(w, msg, code)
+			return
+		}
+
+		var req updateUserReq
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			HandleError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if r.Method == http.MethodPut && (req.Name == nil || req.Dob == nil || req.Email == nil || req.Ssn == nil) {
+			HandleError(w, "PUT requires name, dob, email and ssn, use PATCH for partial updates", http.StatusBadRequest)
+			return
+		}
+
+		user, err := users.Get(r.Context(), mux.Vars(r)["id"])
+		if err != nil {
+			if err == repositories.ErrNotFound {
+				HandleError(w, "User not found", http.StatusNotFound)
+				return
+			}
+
+			log.Err(err).Msg("")
+			HandleError500(w)
+			return
+		}
+
+		if req.Name != nil {
+			user.Name = *req.Name
+		}
+
+		if req.Dob != nil {
+			user.Dob = *req.Dob
+		}
+
+		if req.Email != nil {
+			user.Email = *req.Email
+		}
+
+		if req.Ssn != nil {
+			user.Ssn = *req.Ssn
+		}
+
+		if err = IndexUser(user); err != nil {
+			log.Err(err).Msg("")
+			HandleError500(w)
+			return
+		}
+
+		if err = users.Update(r.Context(), user); err != nil {
+			if err == repositories.ErrNotFound {
+				HandleError(w, "User not found", http.StatusNotFound)
+				return
+			}
+
+			if err == repositories.ErrConflict {
+				HandleError(w, "A user with this SSN already exists", http.StatusConflict)
+				return
+			}
+
+			log.Err(err).Msg("")
+			HandleError500(w)
+			return
+		}
+
+		json.NewEncoder(w).Encode(newGetUserResp(r, user, reveal))
+	}
+}
@@ -7,11 +7,15 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"github.com/zoundwavedj/cybersecurity/configs"
-	"github.com/zoundwavedj/cybersecurity/database"
+	"github.com/zoundwavedj/cybersecurity/repositories"
 	"golang.org/x/crypto/argon2"
 )
 
@@ -25,104 +29,174 @@ type authenticateUserResp struct {
 	RefreshToken string `json:"refreshToken,omitempty"`
 }
 
-// UserLoginHandler function handle user login and authentication
-func UserLoginHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+const (
+	userLockoutPrefix = "user:"
+	ipLockoutPrefix   = "ip:"
+)
 
-	var req authenticateUserReq
+// dummyHash is checked when a username doesn't exist, so unknown usernames take as long to refuse as wrong passwords
+var dummyHash struct {
+	once sync.Once
+	hash string
+	err  error
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		HandleError(w, "Make sure your request body is valid", http.StatusBadRequest)
-		return
-	}
+// UserLoginHandler function handle user login and authentication. Repeated failures lock out the username and the IP
+// they came from, locks are keyed on the username as typed so they say nothing about whether it exists
+func UserLoginHandler(operators repositories.OperatorRepository, sessions repositories.SessionRepository,
+	failures repositories.LoginFailureRepository, policy configs.LockoutPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
 
-	if strings.TrimSpace(req.Username) == "" && strings.TrimSpace(req.Password) == "" {
-		HandleError(w, "Make sure your request body is valid", http.StatusBadRequest)
-		return
-	}
+		var req authenticateUserReq
 
-	rows, err := database.Db.Query("SELECT id, password FROM superuser WHERE username=? LIMIT 1", req.Username)
-	if err != nil {
-		log.Error().Err(err).Msg("")
-		HandleError500(w)
-		return
-	}
-	defer rows.Close()
-
-	var (
-		accessID      string
-		accessExpiry  int64
-		accessToken   string
-		refreshID     string
-		refreshExpiry int64
-		refreshToken  string
-	)
-
-	if rows.Next() {
-		var id string
-		var hashed string
-
-		if err = rows.Scan(&id, &hashed); err != nil {
-			log.Err(err).Msg("")
-			HandleError500(w)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			HandleError(w, "Make sure your request body is valid", http.StatusBadRequest)
+			return
+		}
+
+		if strings.TrimSpace(req.Username) == "" && strings.TrimSpace(req.Password) == "" {
+			HandleError(w, "Make sure your request body is valid", http.StatusBadRequest)
 			return
 		}
 
-		success, err := validateHash(req.Password, hashed)
+		auditDetail(r, "username", strconv.Quote(req.Username))
+
+		var (
+			now      = time.Now()
+			subjects = []lockoutSubject{
+				{userLockoutPrefix + strings.ToLower(req.Username), policy.UserMaxFailures},
+				{ipLockoutPrefix + configs.ClientIP(r), policy.IPMaxFailures},
+			}
+		)
+
+		retryAfter, err := lockedFor(r, failures, subjects, now)
 		if err != nil {
 			log.Err(err).Msg("")
 			HandleError500(w)
 			return
 		}
 
-		if !success {
-			HandleError(w, "Invalid login", http.StatusBadRequest)
+		if retryAfter > 0 {
+			auditDetail(r, "locked", "true")
+			w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+			HandleError(w, "Too many failed logins, try again later", http.StatusTooManyRequests)
 			return
 		}
 
-		accessID, accessExpiry, accessToken, err = configs.GenerateAccessToken()
-		if err != nil {
-			log.Err(err).Msg("")
+		operator, err := operators.GetByUsername(r.Context(), req.Username)
+		if err != nil && err != repositories.ErrNotFound {
+			log.Error().Err(err).Msg("")
 			HandleError500(w)
 			return
 		}
 
-		refreshID, refreshExpiry, refreshToken, err = configs.GenerateRefreshToken()
-		if err != nil {
-			log.Err(err).Msg("")
+		dummyHash.once.Do(func() {
+			dummyHash.hash, dummyHash.err = generateHash(uuid.New().String())
+		})
+
+		hash := dummyHash.hash
+		if operator != nil {
+			auditActor(r, operator.ID)
+			hash = operator.Password
+		} else if dummyHash.err != nil {
+			log.Err(dummyHash.err).Msg("")
 			HandleError500(w)
 			return
 		}
 
-		statement, err := database.Db.Prepare("INSERT INTO authentication (userId, token, expiresAt) VALUES (?, ?, ?)")
+		// The password is checked whatever happened above so every refusal costs the same
+		success, err := validateHash(req.Password, hash)
 		if err != nil {
 			log.Err(err).Msg("")
 			HandleError500(w)
 			return
 		}
-		defer statement.Close()
 
-		if _, err = statement.Exec(id, accessID, accessExpiry); err != nil {
+		if operator == nil || operator.Disabled || !success {
+			if err = recordLoginFailure(r, failures, policy, subjects, now); err != nil {
+				log.Err(err).Msg("")
+				HandleError500(w)
+				return
+			}
+
+			HandleError(w, "Invalid login", http.StatusBadRequest)
+			return
+		}
+
+		// Only the username starts over, a working account mustn't reset the count of the IP guessing others
+		if err = failures.Clear(r.Context(), subjects[0].subject); err != nil {
 			log.Err(err).Msg("")
 			HandleError500(w)
 			return
 		}
 
-		if _, err = statement.Exec(id, refreshID, refreshExpiry); err != nil {
+		accessToken, refreshToken, err := issueTokens(r.Context(), sessions, operator, uuid.New().String())
+		if err != nil {
 			log.Err(err).Msg("")
 			HandleError500(w)
 			return
 		}
-	} else {
-		HandleError(w, "Invalid login", http.StatusBadRequest)
-		return
+
+		resp := authenticateUserResp{
+			AccessToken:  accessToken,
+			RefreshToken: refreshToken,
+		}
+		json.NewEncoder(w).Encode(resp)
 	}
+}
 
-	resp := authenticateUserResp{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
+type lockoutSubject struct {
+	subject     string
+	maxFailures int
+}
+
+// lockedFor returns how many seconds are left on the longest lock among subjects, zero when none is locked
+func lockedFor(r *http.Request, failures repositories.LoginFailureRepository, subjects []lockoutSubject, now time.Time) (int64, error) {
+	var retryAfter int64
+
+	for _, s := range subjects {
+		failure, err := failures.Get(r.Context(), s.subject)
+		if err == repositories.ErrNotFound {
+			continue
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		if left := failure.LockedUntil - now.Unix(); left > retryAfter {
+			retryAfter = left
+		}
 	}
-	json.NewEncoder(w).Encode(resp)
+
+	return retryAfter, nil
+}
+
+// recordLoginFailure counts a failure against every subject and locks the ones past their limit, the lock doubling with each further failure
+func recordLoginFailure(r *http.Request, failures repositories.LoginFailureRepository, policy configs.LockoutPolicy, subjects []lockoutSubject, now time.Time) error {
+	for _, s := range subjects {
+		count, err := failures.RecordFailure(r.Context(), s.subject, now.Unix(), now.Add(-policy.Window).Unix())
+		if err != nil {
+			return err
+		}
+
+		lock := policy.LockDuration(count, s.maxFailures)
+		if lock == 0 {
+			continue
+		}
+
+		until := now.Add(lock)
+
+		if err = failures.Lock(r.Context(), s.subject, until.Unix()); err != nil {
+			return err
+		}
+
+		log.Warn().Str("subject", s.subject).Int("failures", count).Time("until", until).Msg("Login locked out")
+		auditDetail(r, "lockout", strings.SplitN(s.subject, ":", 2)[0])
+	}
+
+	return nil
 }
 
 func validateHash(plain string, hash string) (bool, error) {
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zoundwavedj/cybersecurity/configs"
+	"github.com/zoundwavedj/cybersecurity/database"
+	"github.com/zoundwavedj/cybersecurity/repositories"
+	"github.com/zoundwavedj/cybersecurity/stores"
+)
+
+var testLockoutPolicy = configs.LockoutPolicy{
+	UserMaxFailures: 3,
+	IPMaxFailures:   5,
+	Window:          15 * time.Minute,
+	Lockout:         time.Minute,
+	MaxLockout:      10 * time.Minute,
+}
+
+// loginFixture is a login handler on a fresh database holding the operators admin and viewer, whose password is "right"
+type loginFixture struct {
+	db       *sql.DB
+	failures repositories.LoginFailureRepository
+	handler  http.HandlerFunc
+}
+
+func newLoginFixture(t *testing.T, policy configs.LockoutPolicy) *loginFixture {
+	t.Helper()
+
+	db := testDB(t)
+	operators := repositories.NewSQLOperatorRepository(db, database.SQLITE)
+
+	hash, err := generateHash("right")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, username := range []string{"admin", "viewer"} {
+		if err = operators.Create(context.Background(), &repositories.Operator{ID: username, Username: username, Password: hash, Role: "admin"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := stores.NewMemoryTokenStore(time.Hour)
+	t.Cleanup(func() {
+		store.Close()
+	})
+
+	failures := repositories.NewSQLLoginFailureRepository(db, database.SQLITE)
+
+	return &loginFixture{
+		db:       db,
+		failures: failures,
+		handler:  UserLoginHandler(operators, repositories.NewTokenSessionRepository(store), failures, policy),
+	}
+}
+
+func (f *loginFixture) login(username string, password string, ip string) *httptest.ResponseRecorder {
+	body := `{"username":` + strconv.Quote(username) + `,"password":` + strconv.Quote(password) + `}`
+
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body))
+	req.RemoteAddr = ip + ":1234"
+
+	w := httptest.NewRecorder()
+	f.handler(w, req)
+
+	return w
+}
+
+// expectLogin checks a login is answered with status, and for a 429 that Retry-After is about retryAfter
+func (f *loginFixture) expectLogin(t *testing.T, username string, password string, ip string, status int, retryAfter time.Duration) {
+	t.Helper()
+
+	w := f.login(username, password, ip)
+	if w.Code != status {
+		t.Fatalf("login of %s with %q from %s answered %d %s, want %d", username, password, ip, w.Code, w.Body.String(), status)
+	}
+
+	if status != http.StatusTooManyRequests {
+		if w.Header().Get("Retry-After") != "" {
+			t.Errorf("login of %s from %s answered %d with Retry-After %s, want none", username, ip, status, w.Header().Get("Retry-After"))
+		}
+
+		return
+	}
+
+	seconds, err := strconv.ParseInt(w.Header().Get("Retry-After"), 10, 64)
+	if want := int64(retryAfter / time.Second); err != nil || seconds > want || seconds < want-2 {
+		t.Errorf("locked out login of %s from %s has Retry-After %q, want about %d", username, ip, w.Header().Get("Retry-After"), want)
+	}
+}
+
+// expire moves subject's lock and failures into the past, as if time had gone by. elapsed beyond the window starts the count over
+func (f *loginFixture) expire(t *testing.T, subject string, elapsed time.Duration) {
+	t.Helper()
+
+	then := time.Now().Add(-elapsed).Unix()
+	if _, err := f.db.Exec("UPDATE login_failure SET lastFailureAt=?, lockedUntil=? WHERE subject=?", then, then, subject); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (f *loginFixture) expectFailures(t *testing.T, subject string, want int) {
+	t.Helper()
+
+	failure, err := f.failures.Get(context.Background(), subject)
+	if want == 0 {
+		if err != repositories.ErrNotFound {
+			t.Errorf("%s has failures %+v, %v, want none", subject, failure, err)
+		}
+
+		return
+	}
+
+	if err != nil || failure.Failures != want {
+		t.Errorf("%s has failures %+v, %v, want %d", subject, failure, err, want)
+	}
+}
+
+func TestLoginLocksOutUsername(t *testing.T) {
+	f := newLoginFixture(t, testLockoutPolicy)
+
+	// Failures count against the username whatever IP they come from, and however it's capitalised
+	f.expectLogin(t, "admin", "wrong", "10.0.0.1", http.StatusBadRequest, 0)
+	f.expectLogin(t, "Admin", "wrong", "10.0.0.2", http.StatusBadRequest, 0)
+	f.expectLogin(t, "admin", "wrong", "10.0.0.3", http.StatusBadRequest, 0)
+
+	// Locked, even the right password is refused, from any IP
+	f.expectLogin(t, "admin", "right", "10.0.0.4", http.StatusTooManyRequests, time.Minute)
+	f.expectFailures(t, "user:admin", 3)
+
+	// Other usernames and the IPs involved aren't locked
+	f.expectLogin(t, "viewer", "right", "10.0.0.1", http.StatusOK, 0)
+
+	// Once the lock is over the right password works, and clears the username's count but not the IP's
+	f.expire(t, "user:admin", time.Second)
+	f.expectLogin(t, "admin", "right", "10.0.0.1", http.StatusOK, 0)
+	f.expectFailures(t, "user:admin", 0)
+	f.expectFailures(t, "ip:10.0.0.1", 1)
+}
+
+func TestLoginLocksOutIP(t *testing.T) {
+	f := newLoginFixture(t, testLockoutPolicy)
+
+	// A different username every time stays under the username limit but not under the IP's
+	for i := 0; i < testLockoutPolicy.IPMaxFailures; i++ {
+		f.expectLogin(t, "guess"+strconv.Itoa(i), "wrong", "10.0.0.1", http.StatusBadRequest, 0)
+	}
+
+	f.expectLogin(t, "admin", "right", "10.0.0.1", http.StatusTooManyRequests, time.Minute)
+	f.expectLogin(t, "admin", "right", "10.0.0.2", http.StatusOK, 0)
+
+	// A successful login doesn't reset the IP
+	f.expectFailures(t, "ip:10.0.0.1", testLockoutPolicy.IPMaxFailures)
+}
+
+func TestLoginLockBacksOff(t *testing.T) {
+	f := newLoginFixture(t, testLockoutPolicy)
+
+	for i := 0; i < testLockoutPolicy.UserMaxFailures; i++ {
+		f.expectLogin(t, "admin", "wrong", "10.0.0.1", http.StatusBadRequest, 0)
+	}
+
+	f.expectLogin(t, "admin", "wrong", "10.0.0.1", http.StatusTooManyRequests, time.Minute)
+
+	// Failing again right after the lock, within the window, doubles the next one each time up to the maximum
+	// Each from another IP, so only the username's lock is at play
+	for i, lock := range []time.Duration{2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute} {
+		ip := "10.0.1." + strconv.Itoa(i)
+
+		f.expire(t, "user:admin", time.Second)
+		f.expectLogin(t, "admin", "wrong", ip, http.StatusBadRequest, 0)
+		f.expectLogin(t, "admin", "right", ip, http.StatusTooManyRequests, lock)
+	}
+}
+
+func TestLoginFailuresStartOverAfterTheWindow(t *testing.T) {
+	f := newLoginFixture(t, testLockoutPolicy)
+
+	for i := 0; i < testLockoutPolicy.UserMaxFailures; i++ {
+		f.expectLogin(t, "admin", "wrong", "10.0.0.1", http.StatusBadRequest, 0)
+	}
+
+	// A window after the last failure and the end of the lock, the next failure is counted as the first
+	f.expire(t, "user:admin", testLockoutPolicy.Window+time.Second)
+	f.expire(t, "ip:10.0.0.1", testLockoutPolicy.Window+time.Second)
+
+	f.expectLogin(t, "admin", "wrong", "10.0.0.1", http.StatusBadRequest, 0)
+	f.expectFailures(t, "user:admin", 1)
+	f.expectFailures(t, "ip:10.0.0.1", 1)
+
+	f.expectLogin(t, "admin", "right", "10.0.0.1", http.StatusOK, 0)
+}
+
+func TestLoginUnknownUsername(t *testing.T) {
+	f := newLoginFixture(t, testLockoutPolicy)
+
+	wrong := f.login("admin", "wrong", "10.0.0.1")
+	unknown := f.login("ghost", "wrong", "10.0.0.1")
+
+	// Refused exactly like a wrong password, and counted the same so unknown usernames lock out too
+	if unknown.Code != wrong.Code || unknown.Body.String() != wrong.Body.String() {
+		t.Errorf("login of an unknown username answered %d %s, want %d %s like a wrong password", unknown.Code, unknown.Body.String(), wrong.Code, wrong.Body.String())
+	}
+
+	f.expectFailures(t, "user:ghost", 1)
+
+	// The password was still checked against a hash as costly as a real one
+	if !strings.HasPrefix(dummyHash.hash, "$argon2id$") || dummyHash.err != nil {
+		t.Errorf("dummy hash is %q, %v, want an argon2id hash", dummyHash.hash, dummyHash.err)
+	}
+
+	hash, err := generateHash("right")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parameters := strings.Split(hash, "$")[3]; strings.Split(dummyHash.hash, "$")[3] != parameters {
+		t.Errorf("dummy hash %q doesn't use the parameters %s of real ones", dummyHash.hash, parameters)
+	}
+
+	for i := 1; i < testLockoutPolicy.UserMaxFailures; i++ {
+		f.login("ghost", "wrong", "10.0.0.2")
+	}
+
+	f.expectLogin(t, "ghost", "wrong", "10.0.0.3", http.StatusTooManyRequests, time.Minute)
+}
@@ -4,10 +4,9 @@ import (
 	"encoding/json"
 	"net/http"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/rs/zerolog/log"
 	"github.com/zoundwavedj/cybersecurity/configs"
-	"github.com/zoundwavedj/cybersecurity/database"
+	"github.com/zoundwavedj/cybersecurity/repositories"
 )
 
 type userLogoutReq struct {
@@ -20,65 +19,49 @@ type userLogoutResp struct {
 }
 
 // UserLogoutHandler function to handle user logouts
-func UserLogoutHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+func UserLogoutHandler(sessions repositories.SessionRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
 
-	var req userLogoutReq
+		var req userLogoutReq
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		HandleError(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	var (
-		claims    jwt.MapClaims
-		accessID  string
-		refreshID string
-	)
-
-	accessToken, err := configs.ParseToken(req.AccessToken, configs.ACCESS)
-	if err != nil {
-		log.Err(err).Msg("")
-		HandleError500(w)
-		return
-	}
-
-	claims, _ = accessToken.Claims.(jwt.MapClaims)
-	accessID, _ = claims["accessId"].(string)
-
-	refreshToken, err := configs.ParseToken(req.RefreshToken, configs.REFRESH)
-	if err != nil {
-		log.Err(err).Msg("")
-		HandleError500(w)
-		return
-	}
-
-	claims, _ = refreshToken.Claims.(jwt.MapClaims)
-	refreshID, _ = claims["refreshId"].(string)
-
-	if accessID != "" && refreshID != "" {
-		statement, err := database.Db.Prepare("DELETE FROM authentication WHERE token=?")
-		if err != nil {
-			log.Err(err).Msg("")
-			HandleError500(w)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			HandleError(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
-		defer statement.Close()
 
-		if _, err = statement.Exec(accessID); err != nil {
-			log.Err(err).Msg("")
-			HandleError500(w)
-			return
+		tokens := []struct {
+			token      string
+			secretType configs.SecretType
+		}{
+			{req.AccessToken, configs.ACCESS},
+			{req.RefreshToken, configs.REFRESH},
 		}
 
-		if _, err = statement.Exec(refreshID); err != nil {
-			log.Err(err).Msg("")
-			HandleError500(w)
-			return
+		for _, t := range tokens {
+			claims, err := configs.ParseToken(t.token, t.secretType)
+			if err != nil {
+				// Expired tokens are left for the reaper
+				if err == configs.ErrTokenExpired {
+					continue
+				}
+
+				log.Err(err).Msg("")
+				HandleError500(w)
+				return
+			}
+
+			auditActor(r, claims.Subject)
+
+			if err = sessions.Revoke(r.Context(), claims.Id); err != nil {
+				log.Err(err).Msg("")
+				HandleError500(w)
+				return
+			}
 		}
-	}
 
-	json.NewEncoder(w).Encode(&userLogoutResp{
-		Success: true,
-	})
+		json.NewEncoder(w).Encode(&userLogoutResp{
+			Success: true,
+		})
+	}
 }
@@ -0,0 +1,148 @@
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/zoundwavedj/cybersecurity/database"
+	"github.com/zoundwavedj/cybersecurity/repositories"
+)
+
+func expectApplied(t *testing.T, want int) {
+	t.Helper()
+
+	states, err := database.AppliedMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(states) != want {
+		t.Fatalf("%d migrations applied, want %d", len(states), want)
+	}
+
+	for i, state := range states {
+		if migration := database.Migrations()[i]; state.Version != migration.Version || state.Name != migration.Name {
+			t.Errorf("applied migration %d is %d (%s), want %d (%s)", i, state.Version, state.Name, migration.Version, migration.Name)
+		}
+	}
+}
+
+func TestMigrateAndRollback(t *testing.T) {
+	eachBackend(t, func(t *testing.T, db *sql.DB, dialect database.Dialect) {
+		total := len(database.Migrations())
+
+		expectApplied(t, total)
+
+		if count, err := database.Migrate(); err != nil || count != 0 {
+			t.Fatalf("Migrate of an up to date schema returned %d, %v, want 0", count, err)
+		}
+
+		// Down steps that rebuild tables have to keep their rows
+		ctx := context.Background()
+		users := repositories.NewSQLUserRepository(db, dialect)
+
+		ada := &repositories.User{ID: "u1", Name: "Ada", Dob: "1815-12-10", Email: "ada@example.com", EmailDomainIndex: "domain", NamePrefixIndexes: []string{"ad"}, DobYearIndex: "1815"}
+		if err := users.Create(ctx, ada); err != nil {
+			t.Fatal(err)
+		}
+
+		if count, err := database.Rollback(2); err != nil || count != 2 {
+			t.Fatalf("Rollback(2) returned %d, %v, want 2", count, err)
+		}
+
+		expectApplied(t, total-2)
+
+		if count, err := database.Migrate(); err != nil || count != 2 {
+			t.Fatalf("Migrate after Rollback(2) returned %d, %v, want 2", count, err)
+		}
+
+		user, err := users.Get(ctx, "u1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if user.Name != "Ada" || user.Dob != "1815-12-10" || user.Email != "ada@example.com" || user.EmailDomainIndex != "" || user.DobYearIndex != "" {
+			t.Errorf("user after a rollback round trip is %+v, want Ada with the dropped index columns empty", user)
+		}
+
+		if count, err := database.Rollback(total); err != nil || count != total {
+			t.Fatalf("Rollback(%d) returned %d, %v, want %d", total, count, err, total)
+		}
+
+		expectApplied(t, 0)
+
+		for _, table := range []string{"superuser", "authentication", "revocation", `"user"`, "user_name_prefix"} {
+			if _, err = db.Exec("SELECT COUNT(*) FROM " + table); err == nil {
+				t.Errorf("table %s survived rolling every migration back", table)
+			}
+		}
+
+		if count, err := database.Rollback(1); err != nil || count != 0 {
+			t.Errorf("Rollback of an empty schema returned %d, %v, want 0", count, err)
+		}
+
+		if count, err := database.Migrate(); err != nil || count != total {
+			t.Fatalf("Migrate of an empty schema returned %d, %v, want %d", count, err, total)
+		}
+
+		expectApplied(t, total)
+	})
+}
+
+func TestMigrateAdoptsBaseline(t *testing.T) {
+	// The tables local.db was created with on startup, before migrations existed and as they stood when they were introduced
+	baselines := map[string][]string{
+		"original": {
+			"CREATE TABLE IF NOT EXISTS superuser (id TEXT PRIMARY KEY, username TEXT UNIQUE, password TEXT)",
+			"CREATE TABLE IF NOT EXISTS authentication (userId TEXT, token TEXT, expiresAt INTEGER, FOREIGN KEY (userId) REFERENCES superuser(id))",
+			"CREATE TABLE IF NOT EXISTS user (id TEXT PRIMARY KEY, name TEXT, dob TEXT, email TEXT, ssn TEXT)",
+		},
+		"latest": {
+			"CREATE TABLE IF NOT EXISTS superuser (id TEXT PRIMARY KEY, username TEXT UNIQUE, password TEXT, role TEXT NOT NULL DEFAULT 'admin', disabled INTEGER NOT NULL DEFAULT 0, createdAt INTEGER)",
+			"CREATE TABLE IF NOT EXISTS authentication (userId TEXT, familyId TEXT, token TEXT, expiresAt INTEGER, rotated INTEGER DEFAULT 0, FOREIGN KEY (userId) REFERENCES superuser(id))",
+			"CREATE TABLE IF NOT EXISTS revocation (token TEXT PRIMARY KEY, expiresAt INTEGER, revokedAt INTEGER)",
+			"CREATE TABLE IF NOT EXISTS user (id TEXT PRIMARY KEY, name TEXT, dob TEXT, email TEXT, ssn TEXT)",
+		},
+	}
+
+	for name, statements := range baselines {
+		statements := statements
+
+		t.Run(name, func(t *testing.T) {
+			db := open(t, backends[0])
+
+			for _, statement := range append(statements,
+				"INSERT INTO superuser (id, username, password) VALUES ('o1', 'admin', 'hash')",
+				"INSERT INTO user (id, name) VALUES ('u1', 'Ada')",
+			) {
+				if _, err := db.Exec(statement); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Migrations 1 to 4 created the baseline tables, they're stamped rather than run
+			total := len(database.Migrations())
+
+			if count, err := database.Migrate(); err != nil || count != total-4 {
+				t.Fatalf("Migrate of a %s baseline returned %d, %v, want the %d migrations after it", name, count, err, total-4)
+			}
+
+			expectApplied(t, total)
+
+			operator, err := repositories.NewSQLOperatorRepository(db, database.SQLITE).Get(context.Background(), "o1")
+			if err != nil || operator.Username != "admin" || operator.Role != "admin" || operator.Disabled {
+				t.Errorf("operator from a %s baseline is %+v, %v, want an enabled admin", name, operator, err)
+			}
+
+			var userName string
+			if err = db.QueryRow(`SELECT name FROM "user" WHERE id='u1'`).Scan(&userName); err != nil || userName != "Ada" {
+				t.Errorf("user from a %s baseline has name %q, %v, want it kept", name, userName, err)
+			}
+
+			if count, err := database.Migrate(); err != nil || count != 0 {
+				t.Errorf("Migrate of an adopted baseline returned %d, %v, want 0", count, err)
+			}
+		})
+	}
+}
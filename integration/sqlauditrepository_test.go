@@ -0,0 +1,146 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/zoundwavedj/cybersecurity/database"
+	"github.com/zoundwavedj/cybersecurity/repositories"
+)
+
+var auditKey = bytes.Repeat([]byte{3}, 32)
+
+func appendEvents(t *testing.T, audit repositories.AuditRepository, events ...repositories.AuditEvent) {
+	t.Helper()
+
+	for _, event := range events {
+		event := event
+
+		if err := audit.Append(context.Background(), &event); err != nil {
+			t.Fatalf("Append of %s returned %v", event.Action, err)
+		}
+	}
+}
+
+func TestAuditRepositoryChain(t *testing.T) {
+	eachBackend(t, func(t *testing.T, db *sql.DB, dialect database.Dialect) {
+		audit := repositories.NewSQLAuditRepository(db, dialect, auditKey)
+
+		appendEvents(t, audit,
+			repositories.AuditEvent{OccurredAt: 1700000000, Actor: "o1", Action: "users.create", Target: "u1", Outcome: "success"},
+			repositories.AuditEvent{OccurredAt: 1700000001, Actor: "o1", Action: "users.update", Target: "u1", Outcome: "success"},
+			repositories.AuditEvent{OccurredAt: 1700000002, Actor: "o2", Action: "users.read", Target: "u1", Outcome: "denied", Details: "reveal=ssn"},
+		)
+
+		events, err := audit.List(context.Background(), repositories.AuditQuery{Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(events) != 3 {
+			t.Fatalf("List returned %d events, want 3", len(events))
+		}
+
+		prevHash := ""
+		for i, event := range events {
+			if event.PrevHash != prevHash {
+				t.Errorf("event %d chains onto %q, want %q", i, event.PrevHash, prevHash)
+			}
+
+			if want := repositories.AuditHash(auditKey, event); event.Hash != want {
+				t.Errorf("event %d has hash %q, want %q", i, event.Hash, want)
+			}
+
+			prevHash = event.Hash
+		}
+
+		filtered, err := audit.List(context.Background(), repositories.AuditQuery{Limit: 10, Actor: "o1", AfterSeq: events[0].Seq})
+		if err != nil || len(filtered) != 1 || filtered[0].Action != "users.update" {
+			t.Errorf("List of o1's events after the first returned %v, %v, want the update", filtered, err)
+		}
+
+		// The log is append-only
+		for _, statement := range []string{"UPDATE audit_log SET actor='o3'", "DELETE FROM audit_log"} {
+			if _, err = db.Exec(statement); err == nil {
+				t.Errorf("%s succeeded, want the append-only trigger to refuse it", statement)
+			}
+		}
+	})
+}
+
+func TestAuditHash(t *testing.T) {
+	event := repositories.AuditEvent{
+		OccurredAt: 1700000000, Actor: "o1", Action: "users.update", Target: "u1", IP: "10.0.0.1",
+		UserAgent: "test", Outcome: "success", Details: "reveal=ssn", PrevHash: "prev",
+	}
+
+	hash := repositories.AuditHash(auditKey, &event)
+
+	tests := []struct {
+		name   string
+		change func(e *repositories.AuditEvent)
+	}{
+		{"prevHash", func(e *repositories.AuditEvent) { e.PrevHash = "other" }},
+		{"occurredAt", func(e *repositories.AuditEvent) { e.OccurredAt++ }},
+		{"actor", func(e *repositories.AuditEvent) { e.Actor = "o2" }},
+		{"action", func(e *repositories.AuditEvent) { e.Action = "users.delete" }},
+		{"target", func(e *repositories.AuditEvent) { e.Target = "u2" }},
+		{"ip", func(e *repositories.AuditEvent) { e.IP = "10.0.0.2" }},
+		{"userAgent", func(e *repositories.AuditEvent) { e.UserAgent = "other" }},
+		{"outcome", func(e *repositories.AuditEvent) { e.Outcome = "failure" }},
+		{"details", func(e *repositories.AuditEvent) { e.Details = "" }},
+		// Fields are length-prefixed, moving bytes from one field to the next changes the hash
+		{"a boundary between fields", func(e *repositories.AuditEvent) { e.Actor, e.Action = "o1u", "sers.update" }},
+	}
+
+	for _, test := range tests {
+		changed := event
+		test.change(&changed)
+
+		if repositories.AuditHash(auditKey, &changed) == hash {
+			t.Errorf("AuditHash with another %s is unchanged", test.name)
+		}
+	}
+
+	if repositories.AuditHash(bytes.Repeat([]byte{4}, 32), &event) == hash {
+		t.Error("AuditHash under another key is unchanged")
+	}
+
+	// Seq and the stored hash are left out, they aren't known before the insert
+	event.Seq, event.Hash = 42, "stored"
+	if repositories.AuditHash(auditKey, &event) != hash {
+		t.Error("AuditHash changed with the seq or the stored hash")
+	}
+}
+
+func TestAuditRepositoryRetriesOnFork(t *testing.T) {
+	eachBackend(t, func(t *testing.T, db *sql.DB, dialect database.Dialect) {
+		audit := repositories.NewSQLAuditRepository(db, dialect, auditKey)
+		appendEvents(t, audit, repositories.AuditEvent{OccurredAt: 1700000000, Actor: "o1", Action: "users.create", Target: "u1", Outcome: "success"})
+
+		// Another instance racing to the same head looks like a row already chained onto it, that the head query doesn't see.
+		// Every attempt then collides on the unique prevHash, and Append gives up rather than fork the chain
+		var head string
+		if err := db.QueryRow("SELECT hash FROM audit_log").Scan(&head); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := db.Exec(database.Rebind(dialect, `INSERT INTO audit_log (seq, occurredAt, actor, action, target, ip, userAgent, outcome, details, prevHash, hash)
+			VALUES (0, 1700000000, 'o2', 'users.delete', 'u1', '', '', 'success', '', ?, 'fork')`), head)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		event := repositories.AuditEvent{OccurredAt: 1700000001, Actor: "o1", Action: "users.update", Target: "u1", Outcome: "success"}
+		if err = audit.Append(context.Background(), &event); err != repositories.ErrAuditContention {
+			t.Errorf("Append onto a head another row chained onto returned %v, want %v", err, repositories.ErrAuditContention)
+		}
+
+		var count int
+		if err = db.QueryRow("SELECT COUNT(*) FROM audit_log").Scan(&count); err != nil || count != 2 {
+			t.Errorf("audit_log holds %d rows (%v), want 2", count, err)
+		}
+	})
+}
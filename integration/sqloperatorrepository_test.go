@@ -0,0 +1,99 @@
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/zoundwavedj/cybersecurity/database"
+	"github.com/zoundwavedj/cybersecurity/repositories"
+)
+
+func createOperators(t *testing.T, operators repositories.OperatorRepository, list ...repositories.Operator) {
+	t.Helper()
+
+	for _, operator := range list {
+		operator := operator
+
+		if err := operators.Create(context.Background(), &operator); err != nil {
+			t.Fatalf("Create of %s returned %v", operator.Username, err)
+		}
+	}
+}
+
+func TestOperatorRepository(t *testing.T) {
+	eachBackend(t, func(t *testing.T, db *sql.DB, dialect database.Dialect) {
+		ctx := context.Background()
+		operators := repositories.NewSQLOperatorRepository(db, dialect)
+
+		admin := repositories.Operator{ID: "o1", Username: "admin", Password: "hash", Role: "admin", CreatedAt: 1700000200}
+		createOperators(t, operators,
+			admin,
+			repositories.Operator{ID: "o2", Username: "viewer", Password: "hash", Role: "viewer", CreatedAt: 1700000100},
+		)
+
+		if err := operators.Create(ctx, &repositories.Operator{ID: "o3", Username: "admin", Role: "viewer"}); err != repositories.ErrConflict {
+			t.Errorf("Create with a taken username returned %v, want %v", err, repositories.ErrConflict)
+		}
+
+		operator, err := operators.Get(ctx, "o1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if *operator != admin {
+			t.Errorf("Get returned %+v, want %+v", *operator, admin)
+		}
+
+		if operator, err = operators.GetByUsername(ctx, "viewer"); err != nil || operator.ID != "o2" {
+			t.Errorf("GetByUsername returned %+v, %v, want o2", operator, err)
+		}
+
+		if _, err = operators.Get(ctx, "missing"); err != repositories.ErrNotFound {
+			t.Errorf("Get of a missing operator returned %v, want %v", err, repositories.ErrNotFound)
+		}
+
+		if _, err = operators.GetByUsername(ctx, "missing"); err != repositories.ErrNotFound {
+			t.Errorf("GetByUsername of a missing operator returned %v, want %v", err, repositories.ErrNotFound)
+		}
+
+		list, err := operators.List(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(list) != 2 || list[0].ID != "o2" || list[1].ID != "o1" {
+			t.Errorf("List returned %v, want o2 then o1, oldest first", list)
+		}
+
+		if err = operators.Disable(ctx, "o2"); err != nil {
+			t.Fatal(err)
+		}
+
+		if operator, err = operators.Get(ctx, "o2"); err != nil || !operator.Disabled {
+			t.Errorf("Get after Disable returned %+v, %v, want a disabled operator", operator, err)
+		}
+
+		if err = operators.Disable(ctx, "missing"); err != repositories.ErrNotFound {
+			t.Errorf("Disable of a missing operator returned %v, want %v", err, repositories.ErrNotFound)
+		}
+
+		for role, want := range map[string]bool{"admin": true, "viewer": true, "auditor": false} {
+			if exists, err := operators.ExistsWithRole(ctx, role); err != nil || exists != want {
+				t.Errorf("ExistsWithRole(%s) returned %v, %v, want %v", role, exists, err, want)
+			}
+		}
+
+		if err = operators.Delete(ctx, "o1"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = operators.Delete(ctx, "o1"); err != repositories.ErrNotFound {
+			t.Errorf("second Delete returned %v, want %v", err, repositories.ErrNotFound)
+		}
+
+		if exists, err := operators.ExistsWithRole(ctx, "admin"); err != nil || exists {
+			t.Errorf("ExistsWithRole(admin) after Delete returned %v, %v, want false", exists, err)
+		}
+	})
+}
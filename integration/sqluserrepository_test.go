@@ -0,0 +1,367 @@
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zoundwavedj/cybersecurity/database"
+	"github.com/zoundwavedj/cybersecurity/repositories"
+	"github.com/zoundwavedj/cybersecurity/utils"
+)
+
+func createUsers(t *testing.T, users repositories.UserRepository, list ...repositories.User) {
+	t.Helper()
+
+	for _, user := range list {
+		user := user
+
+		if err := users.Create(context.Background(), &user); err != nil {
+			t.Fatalf("Create of %s returned %v", user.ID, err)
+		}
+	}
+}
+
+func expectIDs(t *testing.T, name string, got []*repositories.User, want ...string) {
+	t.Helper()
+
+	ids := []string{}
+	for _, user := range got {
+		ids = append(ids, user.ID)
+	}
+
+	if len(ids) != len(want) {
+		t.Errorf("%s listed %v, want %v", name, ids, want)
+		return
+	}
+
+	for i := range ids {
+		if ids[i] != want[i] {
+			t.Errorf("%s listed %v, want %v", name, ids, want)
+			return
+		}
+	}
+}
+
+func TestUserRepositoryCRUD(t *testing.T) {
+	eachBackend(t, func(t *testing.T, db *sql.DB, dialect database.Dialect) {
+		ctx := context.Background()
+		users := repositories.NewSQLUserRepository(db, dialect)
+
+		ada := repositories.User{ID: "u1", Name: "Ada", Dob: "1815-12-10", Email: "ada@example.com", Ssn: "123-45-6789",
+			SsnIndex: "ssn1", EmailIndex: "email1", EmailDomainIndex: "domain1", NamePrefixIndexes: []string{"ad", "ada"}, DobYearIndex: "1815"}
+		createUsers(t, users, ada)
+
+		user, err := users.Get(ctx, "u1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Name prefix indexes are only written
+		want := ada
+		want.NamePrefixIndexes = nil
+
+		if !reflect.DeepEqual(*user, want) {
+			t.Errorf("Get returned %+v, want %+v", *user, want)
+		}
+
+		stored, err := users.ListStoredAfter(ctx, "", 10)
+		if err != nil || len(stored) != 1 {
+			t.Fatalf("ListStoredAfter returned %v, %v, want the user", stored, err)
+		}
+
+		// Dates of birth are deterministic so they can be matched, everything else is randomized
+		for _, field := range []struct{ column, value, prefix string }{
+			{"name", stored[0].Name, "v3:"},
+			{"dob", stored[0].Dob, "d1:"},
+			{"email", stored[0].Email, "v3:"},
+			{"ssn", stored[0].Ssn, "v3:"},
+		} {
+			if !strings.HasPrefix(field.value, field.prefix) {
+				t.Errorf("stored %s is %q, want it encrypted with a %s prefix", field.column, field.value, field.prefix)
+			}
+		}
+
+		if err = users.Create(ctx, &repositories.User{ID: "u2", SsnIndex: "ssn1"}); err != repositories.ErrConflict {
+			t.Errorf("Create with a taken SSN returned %v, want %v", err, repositories.ErrConflict)
+		}
+
+		// Empty indexes are stored as NULL, which the unique index lets through any number of times
+		createUsers(t, users, repositories.User{ID: "u2", Name: "Bob"}, repositories.User{ID: "u3", Name: "Cy"})
+
+		ada.Email = "ada@lovelace.org"
+		ada.NamePrefixIndexes = []string{"lo"}
+		if err = users.Update(ctx, &ada); err != nil {
+			t.Fatal(err)
+		}
+
+		if user, err = users.Get(ctx, "u1"); err != nil || user.Email != "ada@lovelace.org" {
+			t.Errorf("Get after Update returned %+v, %v", user, err)
+		}
+
+		for index, want := range map[string][]string{"ad": {}, "lo": {"u1"}} {
+			list, err := users.List(ctx, repositories.UserQuery{Limit: 10, NamePrefixIndex: index})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expectIDs(t, "name prefix "+index+" after Update", list, want...)
+		}
+
+		if err = users.Update(ctx, &repositories.User{ID: "u2", SsnIndex: "ssn1"}); err != repositories.ErrConflict {
+			t.Errorf("Update to a taken SSN returned %v, want %v", err, repositories.ErrConflict)
+		}
+
+		if err = users.Update(ctx, &repositories.User{ID: "missing"}); err != repositories.ErrNotFound {
+			t.Errorf("Update of a missing user returned %v, want %v", err, repositories.ErrNotFound)
+		}
+
+		if err = users.SoftDelete(ctx, "u1", 1700000000); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = users.Get(ctx, "u1"); err != repositories.ErrNotFound {
+			t.Errorf("Get of a soft deleted user returned %v, want %v", err, repositories.ErrNotFound)
+		}
+
+		for name, err := range map[string]error{
+			"SoftDelete of a soft deleted user": users.SoftDelete(ctx, "u1", 1700000000),
+			"Update of a soft deleted user":     users.Update(ctx, &ada),
+			"Restore of a live user":            users.Restore(ctx, "u2"),
+		} {
+			if err != repositories.ErrNotFound {
+				t.Errorf("%s returned %v, want %v", name, err, repositories.ErrNotFound)
+			}
+		}
+
+		if count, err := users.Count(ctx); err != nil || count != 3 {
+			t.Errorf("Count returned %d, %v, want 3 with the soft deleted user", count, err)
+		}
+
+		if err = users.Restore(ctx, "u1"); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = users.Get(ctx, "u1"); err != nil {
+			t.Errorf("Get of a restored user returned %v", err)
+		}
+
+		if err = users.Delete(ctx, "u3"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = users.Delete(ctx, "u3"); err != repositories.ErrNotFound {
+			t.Errorf("second Delete returned %v, want %v", err, repositories.ErrNotFound)
+		}
+
+		createUsers(t, users, repositories.User{ID: "u4", NamePrefixIndexes: []string{"cy"}})
+
+		if err = users.Delete(ctx, "u4"); err != nil {
+			t.Fatal(err)
+		}
+
+		var prefixes int
+		if err = db.QueryRow(database.Rebind(dialect, "SELECT COUNT(*) FROM user_name_prefix WHERE userId=?"), "u4").Scan(&prefixes); err != nil || prefixes != 0 {
+			t.Errorf("%d name prefixes left after Delete (%v), want none", prefixes, err)
+		}
+	})
+}
+
+func TestUserRepositoryList(t *testing.T) {
+	eachBackend(t, func(t *testing.T, db *sql.DB, dialect database.Dialect) {
+		ctx := context.Background()
+		users := repositories.NewSQLUserRepository(db, dialect)
+
+		// The repository stores whatever indexes it's given, readable stand-ins keep the cases legible
+		createUsers(t, users,
+			repositories.User{ID: "u1", Name: "Ada", Dob: "1815-12-10", EmailIndex: "ada", EmailDomainIndex: "example", NamePrefixIndexes: []string{"ad", "ada"}, DobYearIndex: "1815"},
+			repositories.User{ID: "u2", Name: "Alan", Dob: "1912-06-23", EmailDomainIndex: "example", NamePrefixIndexes: []string{"al", "ala", "alan"}, DobYearIndex: "1912"},
+			repositories.User{ID: "u3", Name: "Grace", Dob: "1906-12-09", SsnIndex: "grace", EmailDomainIndex: "navy", NamePrefixIndexes: []string{"gr", "gra"}, DobYearIndex: "1906"},
+			repositories.User{ID: "u4", Name: "Abc", Dob: "1906-12-09", NamePrefixIndexes: []string{"ab", "abc"}, DobYearIndex: "1906"},
+			repositories.User{ID: "u5", Name: "Abe", Dob: "1809-02-12", NamePrefixIndexes: []string{"ab", "abe"}, DobYearIndex: "1809"},
+			repositories.User{ID: "u6", Name: "Abz", Dob: "1920-01-01", NamePrefixIndexes: []string{"ab", "abz"}, DobYearIndex: "1920"},
+		)
+
+		if err := users.SoftDelete(ctx, "u5", 1700000000); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name  string
+			query repositories.UserQuery
+			want  []string
+		}{
+			{"everyone", repositories.UserQuery{}, []string{"u1", "u2", "u3", "u4", "u6"}},
+			{"descending", repositories.UserQuery{Descending: true}, []string{"u6", "u4", "u3", "u2", "u1"}},
+			{"name prefix index", repositories.UserQuery{NamePrefixIndex: "ab"}, []string{"u4", "u6"}},
+			{"exact dob", repositories.UserQuery{Dob: "1906-12-09"}, []string{"u3", "u4"}},
+			{"dob matches the whole date", repositories.UserQuery{Dob: "1906-12-10"}, []string{}},
+			{"dob years", repositories.UserQuery{DobYearIndexes: []string{"1905", "1906", "1912"}}, []string{"u2", "u3", "u4"}},
+			{"name prefix and dob year", repositories.UserQuery{NamePrefixIndex: "ab", DobYearIndexes: []string{"1906"}}, []string{"u4"}},
+			{"ssn index", repositories.UserQuery{SsnIndex: "grace"}, []string{"u3"}},
+			{"email index", repositories.UserQuery{EmailIndex: "ada"}, []string{"u1"}},
+			{"email domain index", repositories.UserQuery{EmailDomainIndex: "example"}, []string{"u1", "u2"}},
+			{"IDs only", repositories.UserQuery{IDOnly: true, NamePrefixIndex: "ab", Descending: true}, []string{"u6", "u4"}},
+		}
+
+		for _, test := range tests {
+			if test.query.Limit == 0 {
+				test.query.Limit = 10
+			}
+
+			list, err := users.List(ctx, test.query)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+
+			expectIDs(t, test.name, list, test.want...)
+
+			for _, user := range list {
+				if decrypted := user.Name != ""; decrypted == test.query.IDOnly {
+					t.Errorf("%s: List returned %+v, want every field read %v", test.name, user, !test.query.IDOnly)
+				}
+			}
+		}
+
+		// Walking the pages visits every user once, in either direction and with filters applied
+		for _, query := range []repositories.UserQuery{{}, {Descending: true}, {NamePrefixIndex: "ab", Descending: true}} {
+			all, err := users.List(ctx, repositories.UserQuery{Limit: 10, Descending: query.Descending, NamePrefixIndex: query.NamePrefixIndex})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var paged []*repositories.User
+
+			for query.Limit = 2; ; {
+				page, err := users.List(ctx, query)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if len(page) == 0 {
+					break
+				}
+
+				if len(paged) >= len(all) {
+					t.Fatalf("pages of %+v never ran out", query)
+				}
+
+				paged = append(paged, page...)
+				query.After = page[len(page)-1].ID
+			}
+
+			want := []string{}
+			for _, user := range all {
+				want = append(want, user.ID)
+			}
+
+			expectIDs(t, "pages", paged, want...)
+		}
+	})
+}
+
+func TestUserRepositoryIndexesAndReencrypt(t *testing.T) {
+	eachBackend(t, func(t *testing.T, db *sql.DB, dialect database.Dialect) {
+		ctx := context.Background()
+		users := repositories.NewSQLUserRepository(db, dialect)
+
+		createUsers(t, users,
+			repositories.User{ID: "u1", Email: "ada@example.com", SsnIndex: "s1", EmailIndex: "e1", EmailDomainIndex: "d1", NamePrefixIndexes: []string{"n1"}, DobYearIndex: "y1"},
+			repositories.User{ID: "u2", Email: "bob@example.com", Ssn: "123-45-6789"},
+			repositories.User{ID: "u3", Email: "cy@example.com", SsnIndex: "s3", EmailIndex: "e3"},
+		)
+
+		if err := users.SoftDelete(ctx, "u3", 1700000000); err != nil {
+			t.Fatal(err)
+		}
+
+		unindexed, err := users.ListUnindexed(ctx, "", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectIDs(t, "ListUnindexed", unindexed, "u2", "u3")
+
+		if unindexed[0].Ssn != "123-45-6789" {
+			t.Errorf("ListUnindexed returned SSN %q, want it decrypted", unindexed[0].Ssn)
+		}
+
+		if unindexed, err = users.ListUnindexed(ctx, "u2", 10); err != nil {
+			t.Fatal(err)
+		}
+
+		expectIDs(t, "ListUnindexed after u2", unindexed, "u3")
+
+		if err = users.UpdateIndexes(ctx, &repositories.User{ID: "u2", SsnIndex: "s1"}); err != repositories.ErrConflict {
+			t.Errorf("UpdateIndexes to a taken SSN returned %v, want %v", err, repositories.ErrConflict)
+		}
+
+		for _, user := range []*repositories.User{
+			{ID: "u2", SsnIndex: "s2", EmailIndex: "e2", EmailDomainIndex: "d2", NamePrefixIndexes: []string{"n2"}, DobYearIndex: "y2"},
+			{ID: "u3", SsnIndex: "s3", EmailIndex: "e3", EmailDomainIndex: "d3", NamePrefixIndexes: []string{"n3"}, DobYearIndex: "y3"},
+		} {
+			if err = users.UpdateIndexes(ctx, user); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if unindexed, err = users.ListUnindexed(ctx, "", 10); err != nil || len(unindexed) != 0 {
+			t.Errorf("ListUnindexed after indexing returned %v, %v, want none", unindexed, err)
+		}
+
+		stored, err := users.ListStoredAfter(ctx, "u1", 1)
+		if err != nil || len(stored) != 1 {
+			t.Fatalf("ListStoredAfter returned %v, %v, want u2", stored, err)
+		}
+
+		if err = users.Reencrypt(ctx, stored[0]); err != nil {
+			t.Fatal(err)
+		}
+
+		fresh, err := users.ListStoredAfter(ctx, "u1", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fresh[0].Email == stored[0].Email {
+			t.Error("Reencrypt left the email ciphertext as it was")
+		}
+
+		user, err := users.Get(ctx, "u2")
+		if err != nil || user.Email != "bob@example.com" || user.Ssn != "123-45-6789" {
+			t.Errorf("Get after Reencrypt returned %+v, %v", user, err)
+		}
+
+		// The row changed since stored was read, so the stale copy must not overwrite it
+		if err = users.Reencrypt(ctx, stored[0]); err != repositories.ErrNotFound {
+			t.Errorf("Reencrypt of a stale read returned %v, want %v", err, repositories.ErrNotFound)
+		}
+
+		// Users written while names and dates of birth were stored in the clear still read, and are encrypted on their way through Reencrypt
+		if _, err = db.Exec(database.Rebind(dialect, `INSERT INTO "user" (id, name, dob) VALUES (?, ?, ?)`), "u4", "Grace", "1906-12-09"); err != nil {
+			t.Fatal(err)
+		}
+
+		if user, err = users.Get(ctx, "u4"); err != nil || user.Name != "Grace" || user.Dob != "1906-12-09" {
+			t.Fatalf("Get of a user stored in the clear returned %+v, %v", user, err)
+		}
+
+		if stored, err = users.ListStoredAfter(ctx, "u3", 1); err != nil || !utils.FieldsNeedRotation(stored[0]) {
+			t.Fatalf("ListStoredAfter returned %v, %v, want u4 needing encryption", stored, err)
+		}
+
+		if err = users.Reencrypt(ctx, stored[0]); err != nil {
+			t.Fatal(err)
+		}
+
+		if stored, err = users.ListStoredAfter(ctx, "u3", 1); err != nil || utils.FieldsNeedRotation(stored[0]) || stored[0].Name == "Grace" {
+			t.Errorf("u4 after Reencrypt is stored as %+v, %v, want it encrypted", stored, err)
+		}
+
+		if user, err = users.Get(ctx, "u4"); err != nil || user.Name != "Grace" || user.Dob != "1906-12-09" {
+			t.Errorf("Get after Reencrypt returned %+v, %v", user, err)
+		}
+	})
+}
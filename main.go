@@ -5,14 +5,19 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/zoundwavedj/cybersecurity/configs"
 	"github.com/zoundwavedj/cybersecurity/database"
 	"github.com/zoundwavedj/cybersecurity/handlers"
 	"github.com/zoundwavedj/cybersecurity/middlewares"
+	"github.com/zoundwavedj/cybersecurity/repositories"
+	"github.com/zoundwavedj/cybersecurity/stores"
+	"github.com/zoundwavedj/cybersecurity/utils"
 )
 
 func main() {
@@ -20,24 +25,114 @@ func main() {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
 
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	log.Info().Msg("Starting up...")
 
-	database.Cleanup()
+	if err := configs.SetupSigningKeys(); err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	if err := utils.SetupKeyProvider(); err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	auditKey, err := configs.AuditKey()
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	if database.WipeOnBoot() {
+		database.Cleanup()
+	}
+
 	database.Setup()
 	defer database.Db.Close()
 
+	stores.Setup()
+	defer stores.Tokens.Close()
+
+	reaper := stores.NewReaper(stores.Tokens, stores.ReaperInterval())
+
+	var denylist *stores.Denylist
+	if configs.StatelessAccessTokens() {
+		denylist = stores.NewDenylist(stores.Tokens, stores.DenylistInterval())
+		if err := denylist.Start(); err != nil {
+			log.Fatal().Err(err).Msg("")
+		}
+
+		stores.Tokens = denylist.Wrap(stores.Tokens)
+		configs.UseDenylist(denylist)
+	}
+
+	var (
+		users     = repositories.NewSQLUserRepository(database.Db, database.Driver)
+		operators = repositories.NewSQLOperatorRepository(database.Db, database.Driver)
+		sessions  = repositories.NewTokenSessionRepository(stores.Tokens)
+		audit     = repositories.NewSQLAuditRepository(database.Db, database.Driver, auditKey)
+		failures  = repositories.NewSQLLoginFailureRepository(database.Db, database.Driver)
+		lockout   = configs.LoadLockoutPolicy()
+	)
+
+	if err := configs.SetupBootstrap(operators); err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	// Login failure counters that went quiet are dropped on the token reaper's schedule
+	reaper.Also("login_failures", func() (int64, error) {
+		return failures.Prune(context.Background(), time.Now().Add(-lockout.Window).Unix())
+	})
+	reaper.Start()
+
+	// Rate limits per route, token buckets of Limit requests refilled evenly over Period. Every IP also shares the global bucket across routes
+	var (
+		limiter        = middlewares.NewRateLimiter(middlewares.SystemClock)
+		globalLimit    = middlewares.RateLimitPolicy{Name: "global", Limit: 600, Period: time.Minute, Key: middlewares.ByIP}
+		bootstrapLimit = middlewares.RateLimitPolicy{Name: "bootstrap", Limit: 5, Period: time.Hour, Key: middlewares.ByIP}
+		loginLimit     = middlewares.RateLimitPolicy{Name: "login", Limit: 10, Period: time.Minute, Key: middlewares.ByIP}
+		sessionLimit   = middlewares.RateLimitPolicy{Name: "session", Limit: 30, Period: time.Minute, Key: middlewares.ByIP}
+		readLimit      = middlewares.RateLimitPolicy{Name: "read", Limit: 300, Period: time.Minute, Key: middlewares.ByPrincipal}
+		writeLimit     = middlewares.RateLimitPolicy{Name: "write", Limit: 60, Period: time.Minute, Key: middlewares.ByPrincipal}
+		adminLimit     = middlewares.RateLimitPolicy{Name: "admin", Limit: 30, Period: time.Minute, Key: middlewares.ByPrincipal}
+	)
+
+	// audited rate limits a route then records every request to it, guarded also requires a permission, denials included.
+	// Rate limited requests aren't audited so a flood can't flood the audit log too
+	audited := func(policy middlewares.RateLimitPolicy, action string, next http.HandlerFunc) http.HandlerFunc {
+		return limiter.Limit(policy, middlewares.Audit(audit, action, next))
+	}
+	guarded := func(policy middlewares.RateLimitPolicy, action string, permission configs.Permission, next http.HandlerFunc) http.HandlerFunc {
+		return audited(policy, action, middlewares.RequirePermission(permission, next))
+	}
+
 	r := mux.NewRouter()
-	r.HandleFunc("/superuser", handlers.CreateSuperUserHandler).Methods(http.MethodGet)
-	r.HandleFunc("/login", handlers.UserLoginHandler).Methods(http.MethodPost)
-	r.HandleFunc("/logout", handlers.UserLogoutHandler).Methods(http.MethodPost)
-	r.HandleFunc("/refresh", handlers.RefreshTokenHandler).Methods(http.MethodPost)
+	r.Use(limiter.Middleware(globalLimit))
+	r.HandleFunc("/superuser", audited(bootstrapLimit, configs.AuditSuperuserBootstrap, handlers.CreateSuperUserHandler(operators))).Methods(http.MethodPost)
+	r.HandleFunc("/login", audited(loginLimit, configs.AuditLogin, handlers.UserLoginHandler(operators, sessions, failures, lockout))).Methods(http.MethodPost)
+	r.HandleFunc("/logout", audited(sessionLimit, configs.AuditLogout, handlers.UserLogoutHandler(sessions))).Methods(http.MethodPost)
+	r.HandleFunc("/refresh", audited(sessionLimit, configs.AuditRefresh, handlers.RefreshTokenHandler(operators, sessions))).Methods(http.MethodPost)
+	r.HandleFunc("/.well-known/jwks.json", handlers.JwksHandler).Methods(http.MethodGet)
 	r.NotFoundHandler = http.HandlerFunc(handlers.NotFoundHandler)
 
 	ar := r.NewRoute().Subrouter()
 	ar.Use(middlewares.JwtMiddleware)
-	ar.HandleFunc("/user", handlers.CreateUserHandler).Methods(http.MethodPost)
-	ar.HandleFunc("/user", handlers.GetUserHandler).Methods(http.MethodGet).Queries("id", "")
-	ar.HandleFunc("/users", handlers.ListUsersHandler).Methods(http.MethodGet)
+	ar.HandleFunc("/user", guarded(writeLimit, configs.AuditUsersCreate, configs.UsersWrite, handlers.CreateUserHandler(users))).Methods(http.MethodPost)
+	ar.HandleFunc("/user", guarded(readLimit, configs.AuditUsersRead, configs.UsersRead, handlers.GetUserHandler(users))).Methods(http.MethodGet).Queries("id", "")
+	ar.HandleFunc("/users", guarded(readLimit, configs.AuditUsersList, configs.UsersRead, handlers.ListUsersHandler(users))).Methods(http.MethodGet)
+	ar.HandleFunc("/users", guarded(writeLimit, configs.AuditUsersCreate, configs.UsersWrite, handlers.CreateUserHandler(users))).Methods(http.MethodPost)
+	ar.HandleFunc("/users/{id}", guarded(readLimit, configs.AuditUsersRead, configs.UsersRead, handlers.GetUserHandler(users))).Methods(http.MethodGet)
+	ar.HandleFunc("/users/{id}", guarded(writeLimit, configs.AuditUsersUpdate, configs.UsersWrite, handlers.UpdateUserHandler(users))).Methods(http.MethodPut, http.MethodPatch)
+	ar.HandleFunc("/users/{id}", guarded(writeLimit, configs.AuditUsersDelete, configs.UsersWrite, handlers.DeleteUserHandler(users))).Methods(http.MethodDelete)
+	ar.HandleFunc("/users/{id}/restore", guarded(writeLimit, configs.AuditUsersRestore, configs.UsersWrite, handlers.RestoreUserHandler(users))).Methods(http.MethodPost)
+	ar.HandleFunc("/operators", guarded(adminLimit, configs.AuditOperatorsCreate, configs.OperatorsManage, handlers.CreateOperatorHandler(operators))).Methods(http.MethodPost)
+	ar.HandleFunc("/operators", guarded(adminLimit, configs.AuditOperatorsList, configs.OperatorsManage, handlers.ListOperatorsHandler(operators))).Methods(http.MethodGet)
+	ar.HandleFunc("/operators/{id}/disable", guarded(adminLimit, configs.AuditOperatorsDisable, configs.OperatorsManage, handlers.DisableOperatorHandler(operators, sessions))).Methods(http.MethodPost)
+	ar.HandleFunc("/operators/{id}", guarded(adminLimit, configs.AuditOperatorsDelete, configs.OperatorsManage, handlers.DeleteOperatorHandler(operators, sessions))).Methods(http.MethodDelete)
+	ar.HandleFunc("/lockouts/unlock", guarded(adminLimit, configs.AuditLockoutsUnlock, configs.OperatorsManage, handlers.UnlockLoginHandler(failures))).Methods(http.MethodPost)
+	ar.HandleFunc("/keys/reload", guarded(adminLimit, configs.AuditKeysReload, configs.KeysManage, handlers.ReloadKeysHandler)).Methods(http.MethodPost)
+	ar.HandleFunc("/audit", guarded(adminLimit, configs.AuditLogRead, configs.AuditRead, handlers.ListAuditEventsHandler(audit))).Methods(http.MethodGet)
 
 	srv := &http.Server{
 		Addr:         "0.0.0.0:8080",
@@ -53,6 +148,20 @@ func main() {
 		}
 	}()
 
+	// SIGHUP reloads KEYRING_FILE, same as POST /keys/reload
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := configs.ReloadKeyrings(); err != nil {
+				log.Err(err).Msg("Keyring reload failed, previous keys are still in use")
+				continue
+			}
+
+			log.Info().Msg("Signing keys reloaded")
+		}
+	}()
+
 	log.Info().Msg("Cybersec Test application is ready :D")
 
 	c := make(chan os.Signal, 1)
@@ -65,6 +174,16 @@ func main() {
 	defer cancel()
 	srv.Shutdown(ctx)
 
+	if err := reaper.Stop(ctx); err != nil {
+		log.Err(err).Msg("")
+	}
+
+	if denylist != nil {
+		if err := denylist.Stop(ctx); err != nil {
+			log.Err(err).Msg("")
+		}
+	}
+
 	log.Info().Msg("Bye bye :D")
 	os.Exit(0)
 }
@@ -0,0 +1,134 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"github.com/zoundwavedj/cybersecurity/configs"
+	"github.com/zoundwavedj/cybersecurity/handlers"
+	"github.com/zoundwavedj/cybersecurity/repositories"
+)
+
+// statusRecorder remembers the status a handler answered with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// heldResponse buffers a handler's response so it's only sent once the request's audit event is recorded
+type heldResponse struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (h *heldResponse) Header() http.Header {
+	return h.header
+}
+
+func (h *heldResponse) WriteHeader(status int) {
+	if !h.wroteHeader {
+		h.status, h.wroteHeader = status, true
+	}
+}
+
+func (h *heldResponse) Write(b []byte) (int, error) {
+	h.WriteHeader(http.StatusOK)
+
+	return h.body.Write(b)
+}
+
+// release sends the held response to the client
+func (h *heldResponse) release(w http.ResponseWriter) {
+	for key, values := range h.header {
+		w.Header()[key] = values
+	}
+
+	w.WriteHeader(h.status)
+	w.Write(h.body.Bytes())
+}
+
+// Audit middleware to record a request to a route as an audit event once it's answered. The actor comes from the access token
+// and the target from the {id} route variable, handlers fill in anything else through configs.AuditEventFromContext.
+// Requests that change something or ask to ?reveal= PII fail closed, their response is held back and replaced by a 503
+// when the event can't be recorded. Other reads are answered whether or not their event makes it into the log
+func Audit(audit repositories.AuditRepository, action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		event := &repositories.AuditEvent{
+			OccurredAt: time.Now().Unix(),
+			Action:     action,
+			Target:     mux.Vars(r)["id"],
+			IP:         configs.ClientIP(r),
+			UserAgent:  r.UserAgent(),
+		}
+
+		if claims, ok := configs.ClaimsFromContext(r.Context()); ok {
+			event.Actor = claims.Subject
+		}
+
+		r = r.WithContext(configs.WithAuditEvent(r.Context(), event))
+
+		if !mustRecord(r) {
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next(recorder, r)
+			appendEvent(audit, event, recorder.status)
+			return
+		}
+
+		held := &heldResponse{header: http.Header{}, status: http.StatusOK}
+		next(held, r)
+
+		if err := appendEvent(audit, event, held.status); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			handlers.HandleError(w, "The audit log is unavailable, the request couldn't be recorded", http.StatusServiceUnavailable)
+			return
+		}
+
+		held.release(w)
+	}
+}
+
+// mustRecord tells if a request may only be answered once its audit event is recorded
+func mustRecord(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return r.URL.Query().Get("reveal") != ""
+	}
+
+	return true
+}
+
+func appendEvent(audit repositories.AuditRepository, event *repositories.AuditEvent, status int) error {
+	if event.Outcome == "" {
+		event.Outcome = outcome(status)
+	}
+
+	// Not tied to the request, a client hanging up mustn't keep its request out of the log
+	err := audit.Append(context.Background(), event)
+	if err != nil {
+		log.Err(err).Str("action", event.Action).Str("actor", event.Actor).Msg("Audit event not recorded")
+	}
+
+	return err
+}
+
+func outcome(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return configs.AuditDenied
+	case status >= http.StatusBadRequest:
+		return configs.AuditFailure
+	}
+
+	return configs.AuditSuccess
+}
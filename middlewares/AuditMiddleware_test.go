@@ -0,0 +1,162 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/zoundwavedj/cybersecurity/configs"
+	"github.com/zoundwavedj/cybersecurity/repositories"
+)
+
+// fakeAuditLog keeps appended events in memory, or fails every append with err
+type fakeAuditLog struct {
+	events []*repositories.AuditEvent
+	err    error
+}
+
+func (l *fakeAuditLog) Append(ctx context.Context, event *repositories.AuditEvent) error {
+	if l.err != nil {
+		return l.err
+	}
+
+	l.events = append(l.events, event)
+
+	return nil
+}
+
+func (l *fakeAuditLog) List(ctx context.Context, query repositories.AuditQuery) ([]*repositories.AuditEvent, error) {
+	return l.events, nil
+}
+
+// auditedRequest serves method target through the audit middleware on a /users/{id} route, as subject when one is given
+func auditedRequest(audit repositories.AuditRepository, method string, target string, subject string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+	r := mux.NewRouter()
+	r.HandleFunc("/users/{id}", func(w http.ResponseWriter, req *http.Request) {
+		if subject != "" {
+			claims := &configs.Claims{StandardClaims: jwt.StandardClaims{Subject: subject}}
+			req = req.WithContext(configs.WithClaims(req.Context(), claims))
+		}
+
+		Audit(audit, configs.AuditUsersUpdate, handler)(w, req)
+	})
+
+	req := httptest.NewRequest(method, target, nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("User-Agent", "test")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	return w
+}
+
+func answer(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handler", "ran")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+func TestAuditRecordsEvents(t *testing.T) {
+	tests := []struct {
+		status  int
+		outcome string
+	}{
+		{http.StatusOK, configs.AuditSuccess},
+		{http.StatusNoContent, configs.AuditSuccess},
+		{http.StatusBadRequest, configs.AuditFailure},
+		{http.StatusUnauthorized, configs.AuditDenied},
+		{http.StatusForbidden, configs.AuditDenied},
+		{http.StatusInternalServerError, configs.AuditFailure},
+	}
+
+	for _, test := range tests {
+		audit := &fakeAuditLog{}
+		auditedRequest(audit, http.MethodPatch, "/users/u1", "o1", answer(test.status, ""))
+
+		if len(audit.events) != 1 {
+			t.Fatalf("%d appended %d events, want 1", test.status, len(audit.events))
+		}
+
+		event := audit.events[0]
+		if event.Action != configs.AuditUsersUpdate || event.Actor != "o1" || event.Target != "u1" || event.IP != "10.0.0.1" || event.UserAgent != "test" || event.OccurredAt == 0 {
+			t.Errorf("event for a %d is %+v, want o1 updating u1 from 10.0.0.1", test.status, event)
+		}
+
+		if event.Outcome != test.outcome {
+			t.Errorf("outcome of a %d is %q, want %q", test.status, event.Outcome, test.outcome)
+		}
+	}
+
+	// Handlers fill in what only they know, and may settle the outcome themselves
+	audit := &fakeAuditLog{}
+	auditedRequest(audit, http.MethodPost, "/users/u1", "", func(w http.ResponseWriter, r *http.Request) {
+		event, _ := configs.AuditEventFromContext(r.Context())
+		event.Actor, event.Details, event.Outcome = "o2", "reveal=ssn", configs.AuditDenied
+	})
+
+	if event := audit.events[0]; event.Actor != "o2" || event.Details != "reveal=ssn" || event.Outcome != configs.AuditDenied {
+		t.Errorf("event filled in by the handler is %+v, want o2, its details and a denied outcome", event)
+	}
+}
+
+func TestAuditFailsClosed(t *testing.T) {
+	tests := []struct {
+		method string
+		target string
+		closed bool
+	}{
+		{http.MethodPost, "/users/u1", true},
+		{http.MethodPut, "/users/u1", true},
+		{http.MethodPatch, "/users/u1", true},
+		{http.MethodDelete, "/users/u1", true},
+		{http.MethodGet, "/users/u1?reveal=ssn", true},
+		{http.MethodGet, "/users/u1", false},
+		{http.MethodHead, "/users/u1", false},
+	}
+
+	for _, test := range tests {
+		calls := 0
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			answer(http.StatusCreated, `{"ssn":"123-45-6789"}`)(w, r)
+		}
+
+		// Recorded, every response goes out as the handler wrote it
+		w := auditedRequest(&fakeAuditLog{}, test.method, test.target, "o1", handler)
+
+		if w.Code != http.StatusCreated || w.Header().Get("X-Handler") != "ran" || (test.method != http.MethodHead && w.Body.String() != `{"ssn":"123-45-6789"}`) {
+			t.Errorf("recorded %s %s answered %d %v %q, want the handler's response", test.method, test.target, w.Code, w.Header(), w.Body.String())
+		}
+
+		// Not recorded, only reads that reveal nothing are still answered
+		w = auditedRequest(&fakeAuditLog{err: errors.New("disk full")}, test.method, test.target, "o1", handler)
+
+		if calls != 2 {
+			t.Errorf("%s %s ran the handler %d times, want 2", test.method, test.target, calls)
+		}
+
+		if !test.closed {
+			if w.Code != http.StatusCreated {
+				t.Errorf("unrecorded %s %s answered %d, want the handler's %d", test.method, test.target, w.Code, http.StatusCreated)
+			}
+
+			continue
+		}
+
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("X-Handler") != "" || w.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("unrecorded %s %s answered %d %v, want a JSON 503", test.method, test.target, w.Code, w.Header())
+		}
+
+		if body := w.Body.String(); body == "" || strings.Contains(body, "123-45-6789") {
+			t.Errorf("unrecorded %s %s answered %q, want an error without the handler's body", test.method, test.target, body)
+		}
+	}
+}
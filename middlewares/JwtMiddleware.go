@@ -22,14 +22,15 @@ func JwtMiddleware(next http.Handler) http.Handler {
 			splits := strings.Split(authorizationString, " ")
 
 			if len(splits) == 2 {
-				if err := configs.ValidateAccessToken(splits[1]); err != nil {
-					if err == configs.ErrTokenExpired || err == configs.ErrTokenMissing {
+				if claims, err := configs.ValidateAccessToken(splits[1]); err != nil {
+					if err == configs.ErrTokenExpired || err == configs.ErrTokenMissing || err == configs.ErrTokenRevoked {
 						errorMsg = err.Error()
 					} else {
 						log.Err(err).Msg("")
 					}
 				} else {
 					authorized = true
+					r = r.WithContext(configs.WithClaims(r.Context(), claims))
 				}
 			}
 		}
@@ -0,0 +1,27 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+	"github.com/zoundwavedj/cybersecurity/configs"
+	"github.com/zoundwavedj/cybersecurity/handlers"
+)
+
+// RequirePermission middleware to only let callers holding the permission through, must run after JwtMiddleware
+func RequirePermission(permission configs.Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !configs.HasPermission(r.Context(), permission) {
+			claims, _ := configs.ClaimsFromContext(r.Context())
+			if claims != nil {
+				log.Warn().Str("userId", claims.Subject).Str("permission", string(permission)).Str("path", r.URL.Path).Msg("Permission denied")
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			handlers.HandleError(w, "You don't have permission to access this resource", http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	}
+}
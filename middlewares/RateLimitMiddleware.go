@@ -0,0 +1,175 @@
+package middlewares
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/zoundwavedj/cybersecurity/configs"
+	"github.com/zoundwavedj/cybersecurity/handlers"
+)
+
+// RateLimitKey type, what requests share a bucket by
+type RateLimitKey int
+
+const (
+	// ByIP shares a bucket between every request from the same client IP
+	ByIP RateLimitKey = iota
+	// ByPrincipal shares a bucket between every request with the same access token subject, falling back to the IP without one
+	ByPrincipal
+)
+
+// Clock interface for the time source of a RateLimiter, so it can be driven by a fake clock
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock global var, the wall clock
+var SystemClock Clock = systemClock{}
+
+// RateLimitPolicy type, a token bucket of Limit requests refilled evenly over Period. Name keeps the buckets of policies apart
+type RateLimitPolicy struct {
+	Name   string
+	Limit  int
+	Period time.Duration
+	Key    RateLimitKey
+}
+
+// bucket holds the tokens left as of updatedAt, refills are computed lazily
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// RateLimiter type holding the token buckets of every policy in memory, each instance limits on its own
+type RateLimiter struct {
+	mu        sync.Mutex
+	clock     Clock
+	buckets   map[string]*bucket
+	sweptAt   time.Time
+	maxPeriod time.Duration
+}
+
+// NewRateLimiter function to create a rate limiter reading time from clock
+func NewRateLimiter(clock Clock) *RateLimiter {
+	return &RateLimiter{
+		clock:   clock,
+		buckets: map[string]*bucket{},
+		sweptAt: clock.Now(),
+	}
+}
+
+// Limit middleware to answer 429 once the caller's bucket for the policy is empty. Every answer carries the RateLimit-* headers,
+// a 429 also Retry-After. Panics on a policy without a positive limit and period, routes are registered at startup
+func (l *RateLimiter) Limit(policy RateLimitPolicy, next http.HandlerFunc) http.HandlerFunc {
+	checkPolicy(policy)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		subject := "ip:" + configs.ClientIP(r)
+		if claims, ok := configs.ClaimsFromContext(r.Context()); ok && policy.Key == ByPrincipal {
+			subject = "principal:" + claims.Subject
+		}
+
+		allowed, remaining, reset, retryAfter := l.Take(policy, subject)
+
+		w.Header().Set("RateLimit-Policy", strconv.Itoa(policy.Limit)+";w="+strconv.Itoa(int(policy.Period.Seconds())))
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(policy.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(reset)))
+
+		if !allowed {
+			log.Warn().Str("policy", policy.Name).Str("subject", subject).Str("path", r.URL.Path).Msg("Rate limit exceeded")
+
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", strconv.Itoa(seconds(retryAfter)))
+			handlers.HandleError(w, "Too many requests, slow down", http.StatusTooManyRequests)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// Middleware function to apply a policy to every route of a router, see Limit
+func (l *RateLimiter) Middleware(policy RateLimitPolicy) func(http.Handler) http.Handler {
+	// Checked here too, mux only wraps routes in middlewares once requests come in
+	checkPolicy(policy)
+
+	return func(next http.Handler) http.Handler {
+		return l.Limit(policy, next.ServeHTTP)
+	}
+}
+
+// Take function to spend a token from the subject's bucket for the policy. Returns whether one was left, how many remain,
+// how long until the bucket is full again and, when refused, how long until the next token
+func (l *RateLimiter) Take(policy RateLimitPolicy, subject string) (allowed bool, remaining int, reset time.Duration, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var (
+		now      = l.clock.Now()
+		capacity = float64(policy.Limit)
+		perToken = policy.Period / time.Duration(policy.Limit)
+		key      = policy.Name + "|" + subject
+	)
+
+	if policy.Period > l.maxPeriod {
+		l.maxPeriod = policy.Period
+	}
+
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, updatedAt: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(capacity, b.tokens+float64(now.Sub(b.updatedAt))/float64(perToken))
+	b.updatedAt = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		allowed = true
+	} else {
+		retryAfter = time.Duration((1 - b.tokens) * float64(perToken))
+	}
+
+	return allowed, int(b.tokens), time.Duration((capacity - b.tokens) * float64(perToken)), retryAfter
+}
+
+// checkPolicy rejects policies Take can't divide a period by
+func checkPolicy(policy RateLimitPolicy) {
+	if policy.Limit <= 0 || policy.Period <= 0 {
+		panic("Rate limit policy " + policy.Name + " needs a positive limit and period")
+	}
+}
+
+// sweep drops the buckets untouched for longer than the longest period, they'd be full again anyway. Callers hold mu
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.sweptAt) < l.maxPeriod {
+		return
+	}
+
+	for key, b := range l.buckets {
+		if now.Sub(b.updatedAt) >= l.maxPeriod {
+			delete(l.buckets, key)
+		}
+	}
+
+	l.sweptAt = now
+}
+
+// seconds rounds up, a client retrying after a rounded down delay would be refused again
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
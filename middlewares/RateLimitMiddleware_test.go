@@ -0,0 +1,241 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/zoundwavedj/cybersecurity/configs"
+)
+
+// fakeClock only moves when told to
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+// threePer30s refills a token every 10 seconds
+var threePer30s = RateLimitPolicy{Name: "test", Limit: 3, Period: 30 * time.Second, Key: ByIP}
+
+func limitedHandler(l *RateLimiter, policy RateLimitPolicy, calls *int) http.HandlerFunc {
+	return l.Limit(policy, func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func request(handler http.HandlerFunc, remoteAddr string, subject string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/limited", nil)
+	r.RemoteAddr = remoteAddr
+
+	if subject != "" {
+		claims := &configs.Claims{StandardClaims: jwt.StandardClaims{Subject: subject}}
+		r = r.WithContext(configs.WithClaims(r.Context(), claims))
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	return w
+}
+
+func expectHeader(t *testing.T, w *httptest.ResponseRecorder, name string, want string) {
+	t.Helper()
+
+	if got := w.Header().Get(name); got != want {
+		t.Errorf("%s = %q, want %q", name, got, want)
+	}
+}
+
+func TestLimitExhaustsBucket(t *testing.T) {
+	var (
+		calls   int
+		clock   = newFakeClock()
+		handler = limitedHandler(NewRateLimiter(clock), threePer30s, &calls)
+	)
+
+	for i, want := range []struct{ remaining, reset string }{{"2", "10"}, {"1", "20"}, {"0", "30"}} {
+		w := request(handler, "10.0.0.1:1234", "")
+
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("request %d answered %d, want %d", i+1, w.Code, http.StatusNoContent)
+		}
+
+		expectHeader(t, w, "RateLimit-Policy", "3;w=30")
+		expectHeader(t, w, "RateLimit-Limit", "3")
+		expectHeader(t, w, "RateLimit-Remaining", want.remaining)
+		expectHeader(t, w, "RateLimit-Reset", want.reset)
+		expectHeader(t, w, "Retry-After", "")
+	}
+
+	w := request(handler, "10.0.0.1:1234", "")
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request past the limit answered %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+
+	if calls != 3 {
+		t.Errorf("handler ran %d times, want 3", calls)
+	}
+
+	expectHeader(t, w, "Retry-After", "10")
+	expectHeader(t, w, "RateLimit-Remaining", "0")
+	expectHeader(t, w, "RateLimit-Reset", "30")
+	expectHeader(t, w, "Content-Type", "application/json")
+
+	var body struct {
+		Code int `json:"code"`
+	}
+
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body.Code != http.StatusTooManyRequests {
+		t.Errorf("429 body = %q, want a JSON error with code 429", w.Body.String())
+	}
+}
+
+func TestLimitRefillsOverTime(t *testing.T) {
+	var (
+		calls   int
+		clock   = newFakeClock()
+		handler = limitedHandler(NewRateLimiter(clock), threePer30s, &calls)
+	)
+
+	for i := 0; i < 3; i++ {
+		request(handler, "10.0.0.1:1234", "")
+	}
+
+	clock.Advance(4 * time.Second)
+
+	w := request(handler, "10.0.0.1:1234", "")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request before a refill answered %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+
+	// 6 seconds to go, the next token is due 10 seconds after the bucket emptied
+	expectHeader(t, w, "Retry-After", "6")
+
+	clock.Advance(6 * time.Second)
+
+	if w = request(handler, "10.0.0.1:1234", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("request after a refill answered %d, want %d", w.Code, http.StatusNoContent)
+	}
+
+	expectHeader(t, w, "RateLimit-Remaining", "0")
+
+	// Refills never exceed the limit, however long the bucket sat idle
+	clock.Advance(time.Hour)
+
+	if w = request(handler, "10.0.0.1:1234", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("request after a long pause answered %d, want %d", w.Code, http.StatusNoContent)
+	}
+
+	expectHeader(t, w, "RateLimit-Remaining", "2")
+	expectHeader(t, w, "RateLimit-Reset", "10")
+}
+
+func TestLimitKeepsKeysApart(t *testing.T) {
+	var (
+		calls     int
+		limiter   = NewRateLimiter(newFakeClock())
+		one       = RateLimitPolicy{Name: "one", Limit: 1, Period: time.Minute, Key: ByIP}
+		principal = RateLimitPolicy{Name: "principal", Limit: 1, Period: time.Minute, Key: ByPrincipal}
+		byIP      = limitedHandler(limiter, one, &calls)
+		byUser    = limitedHandler(limiter, principal, &calls)
+	)
+
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		remoteAddr string
+		subject    string
+		want       int
+	}{
+		{"first IP", byIP, "10.0.0.1:1234", "", http.StatusNoContent},
+		{"first IP again, other port", byIP, "10.0.0.1:5678", "", http.StatusTooManyRequests},
+		{"second IP", byIP, "10.0.0.2:1234", "", http.StatusNoContent},
+		{"ByIP ignores the principal", byIP, "10.0.0.1:1234", "alice", http.StatusTooManyRequests},
+		{"first principal", byUser, "10.0.0.1:1234", "alice", http.StatusNoContent},
+		{"first principal from another IP", byUser, "10.0.0.3:1234", "alice", http.StatusTooManyRequests},
+		{"second principal from the same IP", byUser, "10.0.0.1:1234", "bob", http.StatusNoContent},
+		{"no principal falls back to the IP", byUser, "10.0.0.1:1234", "", http.StatusNoContent},
+		{"no principal from the same IP again", byUser, "10.0.0.1:1234", "", http.StatusTooManyRequests},
+	}
+
+	for _, test := range tests {
+		if w := request(test.handler, test.remoteAddr, test.subject); w.Code != test.want {
+			t.Errorf("%s: answered %d, want %d", test.name, w.Code, test.want)
+		}
+	}
+}
+
+func TestSweepDropsIdleBuckets(t *testing.T) {
+	var (
+		clock   = newFakeClock()
+		limiter = NewRateLimiter(clock)
+		policy  = RateLimitPolicy{Name: "sweep", Limit: 5, Period: time.Minute, Key: ByIP}
+	)
+
+	limiter.Take(policy, "idle")
+	limiter.Take(policy, "busy")
+
+	clock.Advance(30 * time.Second)
+	limiter.Take(policy, "busy")
+
+	clock.Advance(31 * time.Second)
+	limiter.Take(policy, "new")
+
+	if _, ok := limiter.buckets["sweep|idle"]; ok {
+		t.Error("bucket idle for longer than the period wasn't swept")
+	}
+
+	for _, subject := range []string{"busy", "new"} {
+		if _, ok := limiter.buckets["sweep|"+subject]; !ok {
+			t.Errorf("bucket of %s was swept while still in use", subject)
+		}
+	}
+
+	// A swept bucket comes back full
+	if _, remaining, _, _ := limiter.Take(policy, "idle"); remaining != 4 {
+		t.Errorf("remaining after a sweep = %d, want 4", remaining)
+	}
+}
+
+func TestLimitRejectsNonPositivePolicies(t *testing.T) {
+	limiter := NewRateLimiter(newFakeClock())
+
+	policies := []RateLimitPolicy{
+		{Name: "zero limit", Limit: 0, Period: time.Minute},
+		{Name: "negative limit", Limit: -1, Period: time.Minute},
+		{Name: "zero period", Limit: 1},
+	}
+
+	for _, policy := range policies {
+		for name, register := range map[string]func(){
+			"Limit":      func() { limiter.Limit(policy, func(http.ResponseWriter, *http.Request) {}) },
+			"Middleware": func() { limiter.Middleware(policy) },
+		} {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Errorf("%s accepted the %s policy", name, policy.Name)
+					}
+				}()
+
+				register()
+			}()
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/zoundwavedj/cybersecurity/stores"
+)
+
+// User type, fields tagged with encrypt are encrypted by the repository on write and decrypted on read so callers only see plaintext.
+// Randomized fields are bound to their row, deterministic ones can be matched exactly in queries. Ssn also accepts the pre-envelope ciphertexts.
+// SsnIndex, EmailIndex, EmailDomainIndex, NamePrefixIndexes and DobYearIndex hold the blind indexes, NamePrefixIndexes is written but never read back.
+// DeletedAt is set once the user is soft deleted
+type User struct {
+	ID                string
+	Name              string `encrypt:"randomized"`
+	Dob               string `encrypt:"deterministic"`
+	Email             string `encrypt:"randomized"`
+	Ssn               string `encrypt:"randomized,legacy"`
+	SsnIndex          string
+	EmailIndex        string
+	EmailDomainIndex  string
+	NamePrefixIndexes []string
+	DobYearIndex      string
+	DeletedAt         int64
+}
+
+// Checkpoint type recording how far a batch job got, Position is the last ID it finished
+type Checkpoint struct {
+	Job       string
+	Position  string
+	Processed int64
+	UpdatedAt int64
+}
+
+// LoginFailure type counting the failed logins of a subject, a username or an IP, LockedUntil is when its lock ends
+type LoginFailure struct {
+	Subject       string
+	Failures      int
+	LastFailureAt int64
+	LockedUntil   int64
+}
+
+// AuditEvent type, one entry of the audit log. Hash covers every other field and the previous entry's hash, chaining the log
+type AuditEvent struct {
+	Seq        int64
+	OccurredAt int64
+	Actor      string
+	Action     string
+	Target     string
+	IP         string
+	UserAgent  string
+	Outcome    string
+	Details    string
+	PrevHash   string
+	Hash       string
+}
+
+// AuditQuery type describing one page of the audit log in append order, empty filters are ignored
+type AuditQuery struct {
+	AfterSeq int64
+	Limit    int
+	Actor    string
+	Action   string
+	Target   string
+	Outcome  string
+	From     int64
+	To       int64
+}
+
+// UserQuery type describing one page of a user listing, empty filters are ignored. Users are listed by ID, After is the last ID of the previous page.
+// Dob is plaintext, matched against its deterministic ciphertext, and DobYearIndexes matches users born in any of the years.
+// IDOnly fills in nothing but the ID, sparing the read and decryption of every other field
+type UserQuery struct {
+	Limit            int
+	Descending       bool
+	IDOnly           bool
+	After            string
+	NamePrefixIndex  string
+	Dob              string
+	DobYearIndexes   []string
+	SsnIndex         string
+	EmailIndex       string
+	EmailDomainIndex string
+}
+
+// Operator type, Password holds the Argon2id hash
+type Operator struct {
+	ID        string
+	Username  string
+	Password  string
+	Role      string
+	Disabled  bool
+	CreatedAt int64
+}
+
+// UserRepository interface for the user records managed through the API
+type UserRepository interface {
+	Create(ctx context.Context, user *User) error
+	Get(ctx context.Context, id string) (*User, error)
+	List(ctx context.Context, query UserQuery) ([]*User, error)
+	Update(ctx context.Context, user *User) error
+	Delete(ctx context.Context, id string) error
+	SoftDelete(ctx context.Context, id string, deletedAt int64) error
+	Restore(ctx context.Context, id string) error
+	ListUnindexed(ctx context.Context, afterID string, limit int) ([]*User, error)
+	UpdateIndexes(ctx context.Context, user *User) error
+	ListStoredAfter(ctx context.Context, afterID string, limit int) ([]*User, error)
+	Count(ctx context.Context) (int64, error)
+	Reencrypt(ctx context.Context, stored *User) error
+}
+
+// CheckpointRepository interface for the progress of resumable batch jobs
+type CheckpointRepository interface {
+	Load(ctx context.Context, job string) (*Checkpoint, error)
+	Save(ctx context.Context, checkpoint *Checkpoint) error
+	Clear(ctx context.Context, job string) error
+}
+
+// AuditRepository interface for the append-only audit log
+type AuditRepository interface {
+	Append(ctx context.Context, event *AuditEvent) error
+	List(ctx context.Context, query AuditQuery) ([]*AuditEvent, error)
+}
+
+// LoginFailureRepository interface for the failed login counters behind lockouts
+type LoginFailureRepository interface {
+	Get(ctx context.Context, subject string) (*LoginFailure, error)
+	RecordFailure(ctx context.Context, subject string, at int64, resetBefore int64) (int, error)
+	Lock(ctx context.Context, subject string, until int64) error
+	Clear(ctx context.Context, subject string) error
+	Prune(ctx context.Context, before int64) (int64, error)
+}
+
+// OperatorRepository interface for the accounts allowed to log in
+type OperatorRepository interface {
+	Create(ctx context.Context, operator *Operator) error
+	Get(ctx context.Context, id string) (*Operator, error)
+	GetByUsername(ctx context.Context, username string) (*Operator, error)
+	List(ctx context.Context) ([]*Operator, error)
+	Disable(ctx context.Context, id string) error
+	Delete(ctx context.Context, id string) error
+	ExistsWithRole(ctx context.Context, role string) (bool, error)
+}
+
+// SessionRepository interface for the tokens issued to operators
+type SessionRepository interface {
+	Issue(ctx context.Context, token stores.Token) error
+	Revoke(ctx context.Context, token string) error
+	RevokeFamily(ctx context.Context, familyID string) (int64, error)
+	RevokeAll(ctx context.Context, userID string) (int64, error)
+}
+
+var (
+	// ErrNotFound error
+	ErrNotFound = errors.New("Record not found")
+	// ErrConflict error
+	ErrConflict = errors.New("Record already exists")
+)
@@ -0,0 +1,53 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/zoundwavedj/cybersecurity/stores"
+)
+
+// TokenSessionRepository type persisting sessions through whichever token store was selected at startup
+type TokenSessionRepository struct {
+	store stores.TokenStore
+}
+
+// NewTokenSessionRepository function to create a session repository on top of a token store
+func NewTokenSessionRepository(store stores.TokenStore) *TokenSessionRepository {
+	return &TokenSessionRepository{store: store}
+}
+
+// Issue function to record a token
+func (r *TokenSessionRepository) Issue(ctx context.Context, token stores.Token) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return r.store.Issue(token)
+}
+
+// Revoke function to revoke a single token
+func (r *TokenSessionRepository) Revoke(ctx context.Context, token string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return r.store.Revoke(token)
+}
+
+// RevokeFamily function to revoke every token from the same login
+func (r *TokenSessionRepository) RevokeFamily(ctx context.Context, familyID string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return r.store.RevokeFamily(familyID)
+}
+
+// RevokeAll function to revoke every token of a user
+func (r *TokenSessionRepository) RevokeAll(ctx context.Context, userID string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return r.store.RevokeAll(userID)
+}
@@ -0,0 +1,163 @@
+package repositories
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/zoundwavedj/cybersecurity/database"
+)
+
+const (
+	auditColumns = "seq, occurredAt, actor, action, target, ip, userAgent, outcome, details, prevHash, hash"
+	// auditAppendAttempts bounds the retries when another instance appends to the same head first
+	auditAppendAttempts = 5
+)
+
+// ErrAuditContention error
+var ErrAuditContention = errors.New("Audit log head kept moving, event not appended")
+
+// SQLAuditRepository type backed by the audit_log table, rows are only ever inserted
+type SQLAuditRepository struct {
+	mu      sync.Mutex
+	db      *sql.DB
+	dialect database.Dialect
+	key     []byte
+}
+
+// NewSQLAuditRepository function to create an audit repository on the given connection and dialect, chaining events with key
+func NewSQLAuditRepository(db *sql.DB, dialect database.Dialect, key []byte) *SQLAuditRepository {
+	return &SQLAuditRepository{db: db, dialect: dialect, key: key}
+}
+
+// Append function to chain an event onto the current head of the log, setting its PrevHash, Hash and Seq
+func (r *SQLAuditRepository) Append(ctx context.Context, event *AuditEvent) error {
+	// Serializes appends within this process, the unique prevHash catches other instances
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for attempt := 0; attempt < auditAppendAttempts; attempt++ {
+		err := r.db.QueryRowContext(ctx, `SELECT hash FROM audit_log ORDER BY seq DESC LIMIT 1`).Scan(&event.PrevHash)
+		if err == sql.ErrNoRows {
+			event.PrevHash = ""
+		} else if err != nil {
+			return err
+		}
+
+		event.Hash = AuditHash(r.key, event)
+
+		_, err = r.db.ExecContext(ctx, r.rebind(`INSERT INTO audit_log (occurredAt, actor, action, target, ip, userAgent, outcome, details, prevHash, hash)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+			event.OccurredAt, event.Actor, event.Action, event.Target, event.IP, event.UserAgent, event.Outcome, event.Details, event.PrevHash, event.Hash)
+		if err = translateError(err); err == ErrConflict {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// Hashes are unique, which spares a dialect specific way of getting the generated seq back
+		return r.db.QueryRowContext(ctx, r.rebind(`SELECT seq FROM audit_log WHERE hash=?`), event.Hash).Scan(&event.Seq)
+	}
+
+	return ErrAuditContention
+}
+
+// List function to retrieve a page of events after query.AfterSeq in append order
+func (r *SQLAuditRepository) List(ctx context.Context, query AuditQuery) ([]*AuditEvent, error) {
+	var (
+		where = []string{"seq>?"}
+		args  = []interface{}{query.AfterSeq}
+	)
+
+	filters := []struct {
+		column string
+		value  string
+	}{
+		{"actor", query.Actor},
+		{"action", query.Action},
+		{"target", query.Target},
+		{"outcome", query.Outcome},
+	}
+
+	for _, filter := range filters {
+		if filter.value != "" {
+			where = append(where, filter.column+"=?")
+			args = append(args, filter.value)
+		}
+	}
+
+	if query.From > 0 {
+		where = append(where, "occurredAt>=?")
+		args = append(args, query.From)
+	}
+
+	if query.To > 0 {
+		where = append(where, "occurredAt<=?")
+		args = append(args, query.To)
+	}
+
+	args = append(args, query.Limit)
+
+	rows, err := r.db.QueryContext(ctx, r.rebind(`SELECT `+auditColumns+` FROM audit_log WHERE `+strings.Join(where, " AND ")+` ORDER BY seq LIMIT ?`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+
+	for rows.Next() {
+		var event AuditEvent
+
+		err = rows.Scan(&event.Seq, &event.OccurredAt, &event.Actor, &event.Action, &event.Target, &event.IP,
+			&event.UserAgent, &event.Outcome, &event.Details, &event.PrevHash, &event.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, &event)
+	}
+
+	return events, rows.Err()
+}
+
+func (r *SQLAuditRepository) rebind(query string) string {
+	return database.Rebind(r.dialect, query)
+}
+
+// AuditHash function to compute the chained hash of an event, an HMAC-SHA256 under key over the previous hash and every field
+// length-prefixed. Keyed so that someone able to write to the database but without the key can't recompute the chain after an edit.
+// Seq is left out as it's only assigned on insert, the chain orders events already
+func AuditHash(key []byte, event *AuditEvent) string {
+	hash := hmac.New(sha256.New, key)
+
+	fields := []string{
+		event.PrevHash,
+		strconv.FormatInt(event.OccurredAt, 10),
+		event.Actor,
+		event.Action,
+		event.Target,
+		event.IP,
+		event.UserAgent,
+		event.Outcome,
+		event.Details,
+	}
+
+	for _, field := range fields {
+		length := make([]byte, 4)
+		binary.BigEndian.PutUint32(length, uint32(len(field)))
+		hash.Write(length)
+		hash.Write([]byte(field))
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,52 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/zoundwavedj/cybersecurity/database"
+)
+
+// SQLCheckpointRepository type backed by the job_checkpoint table
+type SQLCheckpointRepository struct {
+	db      *sql.DB
+	dialect database.Dialect
+}
+
+// NewSQLCheckpointRepository function to create a checkpoint repository on the given connection and dialect
+func NewSQLCheckpointRepository(db *sql.DB, dialect database.Dialect) *SQLCheckpointRepository {
+	return &SQLCheckpointRepository{db: db, dialect: dialect}
+}
+
+// Load function to retrieve the checkpoint of a job, returns ErrNotFound if it never ran or finished
+func (r *SQLCheckpointRepository) Load(ctx context.Context, job string) (*Checkpoint, error) {
+	var checkpoint Checkpoint
+
+	err := r.db.QueryRowContext(ctx, r.rebind("SELECT job, position, processed, updatedAt FROM job_checkpoint WHERE job=?"), job).
+		Scan(&checkpoint.Job, &checkpoint.Position, &checkpoint.Processed, &checkpoint.UpdatedAt)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return &checkpoint, nil
+}
+
+// Save function to insert or overwrite the checkpoint of a job
+func (r *SQLCheckpointRepository) Save(ctx context.Context, checkpoint *Checkpoint) error {
+	_, err := r.db.ExecContext(ctx, r.rebind("INSERT INTO job_checkpoint (job, position, processed, updatedAt) VALUES (?, ?, ?, ?) "+
+		"ON CONFLICT (job) DO UPDATE SET position=excluded.position, processed=excluded.processed, updatedAt=excluded.updatedAt"),
+		checkpoint.Job, checkpoint.Position, checkpoint.Processed, checkpoint.UpdatedAt)
+
+	return err
+}
+
+// Clear function to remove the checkpoint of a job
+func (r *SQLCheckpointRepository) Clear(ctx context.Context, job string) error {
+	_, err := r.db.ExecContext(ctx, r.rebind("DELETE FROM job_checkpoint WHERE job=?"), job)
+
+	return err
+}
+
+func (r *SQLCheckpointRepository) rebind(query string) string {
+	return database.Rebind(r.dialect, query)
+}
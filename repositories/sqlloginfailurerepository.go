@@ -0,0 +1,77 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/zoundwavedj/cybersecurity/database"
+)
+
+// SQLLoginFailureRepository type backed by the login_failure table, shared by every instance on the same database
+type SQLLoginFailureRepository struct {
+	db      *sql.DB
+	dialect database.Dialect
+}
+
+// NewSQLLoginFailureRepository function to create a login failure repository on the given connection and dialect
+func NewSQLLoginFailureRepository(db *sql.DB, dialect database.Dialect) *SQLLoginFailureRepository {
+	return &SQLLoginFailureRepository{db: db, dialect: dialect}
+}
+
+// Get function to retrieve the failed logins of a subject, returns ErrNotFound if it has none
+func (r *SQLLoginFailureRepository) Get(ctx context.Context, subject string) (*LoginFailure, error) {
+	var failure LoginFailure
+
+	err := r.db.QueryRowContext(ctx, r.rebind("SELECT subject, failures, lastFailureAt, lockedUntil FROM login_failure WHERE subject=?"), subject).
+		Scan(&failure.Subject, &failure.Failures, &failure.LastFailureAt, &failure.LockedUntil)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return &failure, nil
+}
+
+// RecordFailure function to count a failed login and return the subject's failures so far. The count starts over when both
+// the last failure and the last lock ended before resetBefore
+func (r *SQLLoginFailureRepository) RecordFailure(ctx context.Context, subject string, at int64, resetBefore int64) (int, error) {
+	_, err := r.db.ExecContext(ctx, r.rebind("INSERT INTO login_failure (subject, failures, lastFailureAt, lockedUntil) VALUES (?, 1, ?, 0) "+
+		"ON CONFLICT (subject) DO UPDATE SET lastFailureAt=excluded.lastFailureAt, failures=CASE "+
+		"WHEN login_failure.lastFailureAt<? AND login_failure.lockedUntil<? THEN 1 ELSE login_failure.failures+1 END"),
+		subject, at, resetBefore, resetBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	failure, err := r.Get(ctx, subject)
+	if err != nil {
+		return 0, err
+	}
+
+	return failure.Failures, nil
+}
+
+// Lock function to refuse logins for a subject until the given unix time
+func (r *SQLLoginFailureRepository) Lock(ctx context.Context, subject string, until int64) error {
+	return execOne(ctx, r.db, r.rebind("UPDATE login_failure SET lockedUntil=? WHERE subject=?"), until, subject)
+}
+
+// Clear function to forget the failed logins of a subject, unlocking it
+func (r *SQLLoginFailureRepository) Clear(ctx context.Context, subject string) error {
+	_, err := r.db.ExecContext(ctx, r.rebind("DELETE FROM login_failure WHERE subject=?"), subject)
+
+	return err
+}
+
+// Prune function to drop the counters whose last failure and lock both ended before the given unix time, returns how many were dropped
+func (r *SQLLoginFailureRepository) Prune(ctx context.Context, before int64) (int64, error) {
+	result, err := r.db.ExecContext(ctx, r.rebind("DELETE FROM login_failure WHERE lastFailureAt<? AND lockedUntil<?"), before, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (r *SQLLoginFailureRepository) rebind(query string) string {
+	return database.Rebind(r.dialect, query)
+}
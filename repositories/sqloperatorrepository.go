@@ -0,0 +1,101 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/zoundwavedj/cybersecurity/database"
+)
+
+const operatorColumns = "id, username, password, role, disabled, COALESCE(createdAt, 0)"
+
+// SQLOperatorRepository type backed by the superuser table
+type SQLOperatorRepository struct {
+	db      *sql.DB
+	dialect database.Dialect
+}
+
+// NewSQLOperatorRepository function to create an operator repository on the given connection and dialect
+func NewSQLOperatorRepository(db *sql.DB, dialect database.Dialect) *SQLOperatorRepository {
+	return &SQLOperatorRepository{db: db, dialect: dialect}
+}
+
+// Create function to insert an operator, returns ErrConflict if the username is taken
+func (r *SQLOperatorRepository) Create(ctx context.Context, operator *Operator) error {
+	_, err := r.db.ExecContext(ctx, r.rebind("INSERT INTO superuser (id, username, password, role, disabled, createdAt) VALUES (?, ?, ?, ?, ?, ?)"),
+		operator.ID, operator.Username, operator.Password, operator.Role, operator.Disabled, operator.CreatedAt)
+
+	return translateError(err)
+}
+
+// Get function to retrieve an operator by ID
+func (r *SQLOperatorRepository) Get(ctx context.Context, id string) (*Operator, error) {
+	return scanOperator(r.db.QueryRowContext(ctx, r.rebind("SELECT "+operatorColumns+" FROM superuser WHERE id=?"), id))
+}
+
+// GetByUsername function to retrieve an operator by username
+func (r *SQLOperatorRepository) GetByUsername(ctx context.Context, username string) (*Operator, error) {
+	return scanOperator(r.db.QueryRowContext(ctx, r.rebind("SELECT "+operatorColumns+" FROM superuser WHERE username=?"), username))
+}
+
+// List function to list every operator, oldest first
+func (r *SQLOperatorRepository) List(ctx context.Context) ([]*Operator, error) {
+	rows, err := r.db.QueryContext(ctx, r.rebind("SELECT "+operatorColumns+" FROM superuser ORDER BY createdAt"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	operators := []*Operator{}
+
+	for rows.Next() {
+		operator, err := scanOperator(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		operators = append(operators, operator)
+	}
+
+	return operators, rows.Err()
+}
+
+// Disable function to flag an operator as disabled
+func (r *SQLOperatorRepository) Disable(ctx context.Context, id string) error {
+	return execOne(ctx, r.db, r.rebind("UPDATE superuser SET disabled=? WHERE id=?"), true, id)
+}
+
+// Delete function to remove an operator
+func (r *SQLOperatorRepository) Delete(ctx context.Context, id string) error {
+	return execOne(ctx, r.db, r.rebind("DELETE FROM superuser WHERE id=?"), id)
+}
+
+// ExistsWithRole function to check if any operator holds a role
+func (r *SQLOperatorRepository) ExistsWithRole(ctx context.Context, role string) (bool, error) {
+	var id string
+
+	err := r.db.QueryRowContext(ctx, r.rebind("SELECT id FROM superuser WHERE role=? LIMIT 1"), role).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (r *SQLOperatorRepository) rebind(query string) string {
+	return database.Rebind(r.dialect, query)
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanOperator(row scanner) (*Operator, error) {
+	var operator Operator
+
+	if err := row.Scan(&operator.ID, &operator.Username, &operator.Password, &operator.Role, &operator.Disabled, &operator.CreatedAt); err != nil {
+		return nil, translateError(err)
+	}
+
+	return &operator, nil
+}
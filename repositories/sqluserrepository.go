@@ -0,0 +1,314 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/zoundwavedj/cybersecurity/database"
+	"github.com/zoundwavedj/cybersecurity/utils"
+)
+
+const (
+	userTable   = "user"
+	userColumns = "id, COALESCE(name, ''), COALESCE(dob, ''), COALESCE(email, ''), COALESCE(ssn, ''), COALESCE(ssnIndex, ''), COALESCE(emailIndex, ''), COALESCE(emailDomainIndex, ''), COALESCE(dobYearIndex, '')"
+)
+
+// SQLUserRepository type backed by the user table
+type SQLUserRepository struct {
+	db      *sql.DB
+	dialect database.Dialect
+}
+
+// NewSQLUserRepository function to create a user repository on the given connection and dialect
+func NewSQLUserRepository(db *sql.DB, dialect database.Dialect) *SQLUserRepository {
+	return &SQLUserRepository{db: db, dialect: dialect}
+}
+
+// Create function to insert a user, returns ErrConflict if another user has the same SSN
+func (r *SQLUserRepository) Create(ctx context.Context, user *User) error {
+	stored, err := encryptUser(user)
+	if err != nil {
+		return err
+	}
+
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, r.rebind(`INSERT INTO "user" (id, name, dob, email, ssn, ssnIndex, emailIndex, emailDomainIndex, dobYearIndex) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+			stored.ID, stored.Name, stored.Dob, stored.Email, stored.Ssn, nullIfEmpty(stored.SsnIndex), nullIfEmpty(stored.EmailIndex), nullIfEmpty(stored.EmailDomainIndex),
+			nullIfEmpty(stored.DobYearIndex))
+		if err != nil {
+			return translateError(err)
+		}
+
+		return r.storeNamePrefixes(ctx, tx, stored)
+	})
+}
+
+// Get function to retrieve a user by ID, soft deleted users are not found
+func (r *SQLUserRepository) Get(ctx context.Context, id string) (*User, error) {
+	user, err := scanUser(r.db.QueryRowContext(ctx, r.rebind(`SELECT `+userColumns+` FROM "user" WHERE id=? AND deletedAt IS NULL`), id))
+	if err != nil {
+		return nil, err
+	}
+
+	return user, decryptUser(user)
+}
+
+// List function to retrieve a page of users, soft deleted users excluded. Pages are keyset based so deep pages cost the same as the first
+func (r *SQLUserRepository) List(ctx context.Context, query UserQuery) ([]*User, error) {
+	var (
+		where = []string{"deletedAt IS NULL"}
+		args  []interface{}
+		op    = ">"
+		order = "ASC"
+	)
+
+	if query.Descending {
+		op, order = "<", "DESC"
+	}
+
+	if query.NamePrefixIndex != "" {
+		where = append(where, `id IN (SELECT userId FROM user_name_prefix WHERE prefixIndex=?)`)
+		args = append(args, query.NamePrefixIndex)
+	}
+
+	if query.Dob != "" {
+		dob, err := utils.EncryptDeterministic(query.Dob, utils.EncryptionContext{Table: userTable, Column: "dob"})
+		if err != nil {
+			return nil, err
+		}
+
+		where = append(where, "dob=?")
+		args = append(args, dob)
+	}
+
+	if len(query.DobYearIndexes) > 0 {
+		where = append(where, "dobYearIndex IN (?"+strings.Repeat(", ?", len(query.DobYearIndexes)-1)+")")
+		for _, index := range query.DobYearIndexes {
+			args = append(args, index)
+		}
+	}
+
+	if query.SsnIndex != "" {
+		where = append(where, "ssnIndex=?")
+		args = append(args, query.SsnIndex)
+	}
+
+	if query.EmailIndex != "" {
+		where = append(where, "emailIndex=?")
+		args = append(args, query.EmailIndex)
+	}
+
+	if query.EmailDomainIndex != "" {
+		where = append(where, "emailDomainIndex=?")
+		args = append(args, query.EmailDomainIndex)
+	}
+
+	if query.After != "" {
+		where = append(where, "id"+op+"?")
+		args = append(args, query.After)
+	}
+
+	args = append(args, query.Limit)
+	statement := ` FROM "user" WHERE ` + strings.Join(where, " AND ") + " ORDER BY id " + order + " LIMIT ?"
+
+	if query.IDOnly {
+		return r.queryIDs(ctx, `SELECT id`+statement, args...)
+	}
+
+	return r.queryDecrypted(ctx, `SELECT `+userColumns+statement, args...)
+}
+
+// Update function to overwrite the fields of a user that isn't soft deleted, returns ErrConflict if another user has the same SSN
+func (r *SQLUserRepository) Update(ctx context.Context, user *User) error {
+	stored, err := encryptUser(user)
+	if err != nil {
+		return err
+	}
+
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		err := execOne(ctx, tx, r.rebind(`UPDATE "user" SET name=?, dob=?, email=?, ssn=?, ssnIndex=?, emailIndex=?, emailDomainIndex=?, dobYearIndex=? WHERE id=? AND deletedAt IS NULL`),
+			stored.Name, stored.Dob, stored.Email, stored.Ssn, nullIfEmpty(stored.SsnIndex), nullIfEmpty(stored.EmailIndex), nullIfEmpty(stored.EmailDomainIndex),
+			nullIfEmpty(stored.DobYearIndex), stored.ID)
+		if err != nil {
+			return err
+		}
+
+		return r.storeNamePrefixes(ctx, tx, stored)
+	})
+}
+
+// Delete function to permanently remove a user, soft deleted or not
+func (r *SQLUserRepository) Delete(ctx context.Context, id string) error {
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, r.rebind("DELETE FROM user_name_prefix WHERE userId=?"), id); err != nil {
+			return err
+		}
+
+		return execOne(ctx, tx, r.rebind(`DELETE FROM "user" WHERE id=?`), id)
+	})
+}
+
+// SoftDelete function to hide a user from reads until it's restored
+func (r *SQLUserRepository) SoftDelete(ctx context.Context, id string, deletedAt int64) error {
+	return execOne(ctx, r.db, r.rebind(`UPDATE "user" SET deletedAt=? WHERE id=? AND deletedAt IS NULL`), deletedAt, id)
+}
+
+// Restore function to bring back a soft deleted user
+func (r *SQLUserRepository) Restore(ctx context.Context, id string) error {
+	return execOne(ctx, r.db, r.rebind(`UPDATE "user" SET deletedAt=NULL WHERE id=? AND deletedAt IS NOT NULL`), id)
+}
+
+// ListUnindexed function to retrieve users, soft deleted included, missing a blind index, ordered by ID after afterID
+func (r *SQLUserRepository) ListUnindexed(ctx context.Context, afterID string, limit int) ([]*User, error) {
+	return r.queryDecrypted(ctx, `SELECT `+userColumns+` FROM "user" WHERE (ssnIndex IS NULL OR emailIndex IS NULL OR emailDomainIndex IS NULL OR dobYearIndex IS NULL
+		OR NOT EXISTS (SELECT 1 FROM user_name_prefix WHERE userId="user".id)) AND id>? ORDER BY id LIMIT ?`, afterID, limit)
+}
+
+// UpdateIndexes function to store the blind indexes of a user, returns ErrConflict if another user has the same SSN
+func (r *SQLUserRepository) UpdateIndexes(ctx context.Context, user *User) error {
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		err := execOne(ctx, tx, r.rebind(`UPDATE "user" SET ssnIndex=?, emailIndex=?, emailDomainIndex=?, dobYearIndex=? WHERE id=?`),
+			nullIfEmpty(user.SsnIndex), nullIfEmpty(user.EmailIndex), nullIfEmpty(user.EmailDomainIndex), nullIfEmpty(user.DobYearIndex), user.ID)
+		if err != nil {
+			return err
+		}
+
+		return r.storeNamePrefixes(ctx, tx, user)
+	})
+}
+
+// ListStoredAfter function to retrieve users as stored, encrypted fields left encrypted, soft deleted included, ordered by ID after afterID
+func (r *SQLUserRepository) ListStoredAfter(ctx context.Context, afterID string, limit int) ([]*User, error) {
+	return r.query(ctx, `SELECT `+userColumns+` FROM "user" WHERE id>? ORDER BY id LIMIT ?`, afterID, limit)
+}
+
+// Count function to count every user, soft deleted included
+func (r *SQLUserRepository) Count(ctx context.Context) (int64, error) {
+	var count int64
+
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM "user"`).Scan(&count)
+
+	return count, err
+}
+
+// Reencrypt function to encrypt a user read by ListStoredAfter afresh under the active keys, returns ErrNotFound if it changed since so concurrent updates win
+func (r *SQLUserRepository) Reencrypt(ctx context.Context, stored *User) error {
+	user := *stored
+
+	if err := decryptUser(&user); err != nil {
+		return err
+	}
+
+	fresh, err := encryptUser(&user)
+	if err != nil {
+		return err
+	}
+
+	return execOne(ctx, r.db, r.rebind(`UPDATE "user" SET name=?, dob=?, email=?, ssn=?
+		WHERE id=? AND COALESCE(name, '')=? AND COALESCE(dob, '')=? AND COALESCE(email, '')=? AND COALESCE(ssn, '')=?`),
+		fresh.Name, fresh.Dob, fresh.Email, fresh.Ssn, stored.ID, stored.Name, stored.Dob, stored.Email, stored.Ssn)
+}
+
+func (r *SQLUserRepository) query(ctx context.Context, statement string, args ...interface{}) ([]*User, error) {
+	rows, err := r.db.QueryContext(ctx, r.rebind(statement), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// queryIDs reads users of which only the ID was selected
+func (r *SQLUserRepository) queryIDs(ctx context.Context, statement string, args ...interface{}) ([]*User, error) {
+	rows, err := r.db.QueryContext(ctx, r.rebind(statement), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		if err = rows.Scan(&user.ID); err != nil {
+			return nil, err
+		}
+
+		users = append(users, &user)
+	}
+
+	return users, rows.Err()
+}
+
+func (r *SQLUserRepository) queryDecrypted(ctx context.Context, statement string, args ...interface{}) ([]*User, error) {
+	users, err := r.query(ctx, statement, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, user := range users {
+		if err = decryptUser(user); err != nil {
+			return nil, err
+		}
+	}
+
+	return users, nil
+}
+
+// storeNamePrefixes replaces the name prefix indexes of a user, they live in their own table so each can be looked up through its primary key
+func (r *SQLUserRepository) storeNamePrefixes(ctx context.Context, tx *sql.Tx, user *User) error {
+	if _, err := tx.ExecContext(ctx, r.rebind("DELETE FROM user_name_prefix WHERE userId=?"), user.ID); err != nil {
+		return err
+	}
+
+	for _, index := range user.NamePrefixIndexes {
+		if _, err := tx.ExecContext(ctx, r.rebind("INSERT INTO user_name_prefix (prefixIndex, userId) VALUES (?, ?)"), index, user.ID); err != nil {
+			return translateError(err)
+		}
+	}
+
+	return nil
+}
+
+func (r *SQLUserRepository) rebind(query string) string {
+	return database.Rebind(r.dialect, query)
+}
+
+func scanUser(row scanner) (*User, error) {
+	var user User
+
+	if err := row.Scan(&user.ID, &user.Name, &user.Dob, &user.Email, &user.Ssn, &user.SsnIndex, &user.EmailIndex, &user.EmailDomainIndex, &user.DobYearIndex); err != nil {
+		return nil, translateError(err)
+	}
+
+	return &user, nil
+}
+
+// encryptUser returns a copy of user with its tagged fields encrypted, the caller's copy stays plaintext
+func encryptUser(user *User) (*User, error) {
+	stored := *user
+
+	if err := utils.EncryptFields(&stored, userTable, stored.ID); err != nil {
+		return nil, err
+	}
+
+	return &stored, nil
+}
+
+func decryptUser(user *User) error {
+	return utils.DecryptFields(user, userTable, user.ID)
+}
@@ -0,0 +1,175 @@
+package stores
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultDenylistInterval used when DENYLIST_SYNC_INTERVAL is not set
+const DefaultDenylistInterval = time.Second * 5
+
+// Denylist type caching revoked token IDs in memory so stateless validation needs no store round trip
+type Denylist struct {
+	store    TokenStore
+	interval time.Duration
+	mu       sync.RWMutex
+	entries  map[string]int64
+	syncedAt int64
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+// denylistingStore type syncing the denylist right after revocations made through this process
+type denylistingStore struct {
+	TokenStore
+	denylist *Denylist
+}
+
+// DenylistInterval function to read the sync interval from the DENYLIST_SYNC_INTERVAL env var (eg. 5s)
+func DenylistInterval() time.Duration {
+	value := os.Getenv("DENYLIST_SYNC_INTERVAL")
+	if value == "" {
+		return DefaultDenylistInterval
+	}
+
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		log.Warn().Str("value", value).Msg("Invalid DENYLIST_SYNC_INTERVAL, using default")
+		return DefaultDenylistInterval
+	}
+
+	return interval
+}
+
+// NewDenylist function to create a denylist fed by the given store
+func NewDenylist(store TokenStore, interval time.Duration) *Denylist {
+	return &Denylist{
+		store:    store,
+		interval: interval,
+		entries:  map[string]int64{},
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// Wrap function to decorate a store so revocations through it reach the denylist immediately instead of on the next sync
+func (d *Denylist) Wrap(store TokenStore) TokenStore {
+	return &denylistingStore{
+		TokenStore: store,
+		denylist:   d,
+	}
+}
+
+// Contains function to check if a token ID has been revoked
+func (d *Denylist) Contains(token string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	_, ok := d.entries[token]
+
+	return ok
+}
+
+// Sync function to pull revocations recorded since the previous sync and drop expired entries
+func (d *Denylist) Sync() error {
+	d.mu.RLock()
+	// One second of overlap since revokedAt has second precision
+	since := d.syncedAt - 1
+	d.mu.RUnlock()
+
+	now := time.Now()
+
+	revocations, err := d.store.Revoked(since)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, r := range revocations {
+		d.entries[r.Token] = r.ExpiresAt
+	}
+
+	for token, expiresAt := range d.entries {
+		if isExpired(expiresAt, now) {
+			delete(d.entries, token)
+		}
+	}
+
+	d.syncedAt = now.Unix()
+
+	return nil
+}
+
+// Start function to sync once and then keep syncing in the background
+func (d *Denylist) Start() error {
+	if err := d.Sync(); err != nil {
+		return err
+	}
+
+	log.Info().Dur("interval", d.interval).Msg("Token denylist started")
+
+	go func() {
+		defer close(d.stopped)
+
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := d.Sync(); err != nil {
+					log.Err(err).Msg("Token denylist sync failed")
+				}
+			case <-d.done:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Stop function to stop background syncing, waiting for an in-flight sync until ctx is done
+func (d *Denylist) Stop(ctx context.Context) error {
+	close(d.done)
+
+	select {
+	case <-d.stopped:
+		log.Info().Msg("Token denylist stopped")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *denylistingStore) Revoke(token string) error {
+	if err := s.TokenStore.Revoke(token); err != nil {
+		return err
+	}
+
+	return s.denylist.Sync()
+}
+
+func (s *denylistingStore) RevokeFamily(familyID string) (int64, error) {
+	count, err := s.TokenStore.RevokeFamily(familyID)
+	if err != nil {
+		return count, err
+	}
+
+	return count, s.denylist.Sync()
+}
+
+func (s *denylistingStore) RevokeAll(userID string) (int64, error) {
+	count, err := s.TokenStore.RevokeAll(userID)
+	if err != nil {
+		return count, err
+	}
+
+	return count, s.denylist.Sync()
+}
@@ -0,0 +1,163 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// failingStore fails every revocation listing
+type failingStore struct {
+	TokenStore
+}
+
+func (failingStore) Revoked(since int64) ([]Revocation, error) {
+	return nil, errors.New("Store unavailable")
+}
+
+func expectDenied(t *testing.T, denylist *Denylist, want bool, tokens ...string) {
+	t.Helper()
+
+	for _, token := range tokens {
+		if got := denylist.Contains(token); got != want {
+			t.Errorf("Contains(%s) = %v, want %v", token, got, want)
+		}
+	}
+}
+
+func TestDenylistSync(t *testing.T) {
+	store := newTestMemoryStore(t, time.Hour)
+	denylist := NewDenylist(store, time.Hour)
+
+	expiresAt := time.Now().Add(time.Hour).Unix()
+	issueTokens(t, store,
+		Token{UserID: "u1", FamilyID: "f1", Token: "a1", ExpiresAt: expiresAt},
+		Token{UserID: "u1", FamilyID: "f1", Token: "a2", ExpiresAt: expiresAt},
+	)
+
+	if err := store.Revoke("a1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Revocations made elsewhere only show up after a sync
+	expectDenied(t, denylist, false, "a1")
+
+	if err := denylist.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectDenied(t, denylist, true, "a1")
+	expectDenied(t, denylist, false, "a2", "missing")
+
+	// Entries go once the token they deny has expired
+	denylist.entries["gone"] = time.Now().Add(-time.Minute).Unix()
+
+	if err := denylist.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectDenied(t, denylist, false, "gone")
+	expectDenied(t, denylist, true, "a1")
+
+	if err := NewDenylist(failingStore{store}, time.Hour).Sync(); err == nil {
+		t.Error("Sync against a failing store succeeded, want its error")
+	}
+}
+
+func TestDenylistWrap(t *testing.T) {
+	store := newTestMemoryStore(t, time.Hour)
+	denylist := NewDenylist(store, time.Hour)
+	wrapped := denylist.Wrap(store)
+
+	expiresAt := time.Now().Add(time.Hour).Unix()
+	issueTokens(t, wrapped,
+		Token{UserID: "u1", FamilyID: "f1", Token: "a1", ExpiresAt: expiresAt},
+		Token{UserID: "u1", FamilyID: "f2", Token: "b1", ExpiresAt: expiresAt},
+		Token{UserID: "u1", FamilyID: "f2", Token: "b2", ExpiresAt: expiresAt},
+		Token{UserID: "u2", FamilyID: "f3", Token: "c1", ExpiresAt: expiresAt},
+		Token{UserID: "u2", FamilyID: "f4", Token: "d1", ExpiresAt: expiresAt},
+	)
+
+	// Revocations through the wrapped store are denied without waiting for a sync
+	if err := wrapped.Revoke("a1"); err != nil {
+		t.Fatal(err)
+	}
+
+	expectDenied(t, denylist, true, "a1")
+
+	if removed, err := wrapped.RevokeFamily("f2"); err != nil || removed != 2 {
+		t.Fatalf("RevokeFamily returned %d, %v, want 2 removed", removed, err)
+	}
+
+	expectDenied(t, denylist, true, "b1", "b2")
+	expectDenied(t, denylist, false, "c1", "d1")
+
+	if removed, err := wrapped.RevokeAll("u2"); err != nil || removed != 2 {
+		t.Fatalf("RevokeAll returned %d, %v, want 2 removed", removed, err)
+	}
+
+	expectDenied(t, denylist, true, "c1", "d1")
+}
+
+func TestDenylistStartStop(t *testing.T) {
+	store := newTestMemoryStore(t, time.Hour)
+	denylist := NewDenylist(store, 10*time.Millisecond)
+
+	if err := NewDenylist(failingStore{store}, time.Hour).Start(); err == nil {
+		t.Error("Start against a failing store succeeded, want its error")
+	}
+
+	if err := denylist.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	issueTokens(t, store, Token{UserID: "u1", FamilyID: "f1", Token: "a1", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+
+	if err := store.Revoke("a1"); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for !denylist.Contains("a1") {
+		if time.Now().After(deadline) {
+			t.Fatal("background sync didn't pick up a revocation within a second")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := denylist.Stop(ctx); err != nil {
+		t.Errorf("Stop returned %v", err)
+	}
+}
+
+func TestDenylistInterval(t *testing.T) {
+	previous, ok := os.LookupEnv("DENYLIST_SYNC_INTERVAL")
+	defer func() {
+		if ok {
+			os.Setenv("DENYLIST_SYNC_INTERVAL", previous)
+		} else {
+			os.Unsetenv("DENYLIST_SYNC_INTERVAL")
+		}
+	}()
+
+	for value, want := range map[string]time.Duration{
+		"":      DefaultDenylistInterval,
+		"250ms": 250 * time.Millisecond,
+		"1m":    time.Minute,
+		"soon":  DefaultDenylistInterval,
+		"0s":    DefaultDenylistInterval,
+		"-1s":   DefaultDenylistInterval,
+	} {
+		os.Setenv("DENYLIST_SYNC_INTERVAL", value)
+
+		if got := DenylistInterval(); got != want {
+			t.Errorf("DenylistInterval with %q = %s, want %s", value, got, want)
+		}
+	}
+}
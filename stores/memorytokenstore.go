@@ -0,0 +1,192 @@
+package stores
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryTokenStore type keeping tokens in process memory, expired tokens are dropped automatically
+type MemoryTokenStore struct {
+	mu          sync.RWMutex
+	tokens      map[string]Token
+	revocations map[string]Revocation
+	done        chan struct{}
+	once        sync.Once
+}
+
+// NewMemoryTokenStore function to create an in-memory token store that sweeps expired tokens every interval
+func NewMemoryTokenStore(interval time.Duration) *MemoryTokenStore {
+	s := &MemoryTokenStore{
+		tokens:      map[string]Token{},
+		revocations: map[string]Revocation{},
+		done:        make(chan struct{}),
+	}
+
+	go s.janitor(interval)
+
+	return s
+}
+
+// Issue function to store a token
+func (s *MemoryTokenStore) Issue(token Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[token.Token] = token
+
+	return nil
+}
+
+// Lookup function to retrieve a token
+func (s *MemoryTokenStore) Lookup(token string) (*Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.tokens[token]
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+
+	return &t, nil
+}
+
+// Rotate function to flag a token as used
+func (s *MemoryTokenStore) Rotate(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[token]
+	if !ok {
+		return ErrTokenNotFound
+	}
+
+	if t.Rotated {
+		return ErrTokenRotated
+	}
+
+	t.Rotated = true
+	s.tokens[token] = t
+
+	return nil
+}
+
+// Revoke function to remove a token, recording its revocation unless it has expired
+func (s *MemoryTokenStore) Revoke(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.tokens[token]; ok {
+		s.remove(t, true, time.Now())
+	}
+
+	return nil
+}
+
+// RevokeFamily function to remove every token of a family
+func (s *MemoryTokenStore) RevokeFamily(familyID string) (int64, error) {
+	return s.removeWhere(func(t Token) bool {
+		return t.FamilyID == familyID
+	}, true), nil
+}
+
+// RevokeAll function to remove every token of a user
+func (s *MemoryTokenStore) RevokeAll(userID string) (int64, error) {
+	return s.removeWhere(func(t Token) bool {
+		return t.UserID == userID
+	}, true), nil
+}
+
+// Revoked function to list revocations
+func (s *MemoryTokenStore) Revoked(since int64) ([]Revocation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var (
+		revocations []Revocation
+		now         = time.Now()
+	)
+
+	for _, r := range s.revocations {
+		if r.RevokedAt >= since && !isExpired(r.ExpiresAt, now) {
+			revocations = append(revocations, r)
+		}
+	}
+
+	return revocations, nil
+}
+
+// SweepExpired function to remove every expired token and revocation
+func (s *MemoryTokenStore) SweepExpired() (int64, error) {
+	now := time.Now()
+
+	count := s.removeWhere(func(t Token) bool {
+		return isExpired(t.ExpiresAt, now)
+	}, false)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, r := range s.revocations {
+		if isExpired(r.ExpiresAt, now) {
+			delete(s.revocations, key)
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// Close function to stop the expiry janitor
+func (s *MemoryTokenStore) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+	})
+
+	return nil
+}
+
+func (s *MemoryTokenStore) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.SweepExpired()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *MemoryTokenStore) removeWhere(match func(Token) bool, record bool) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		count int64
+		now   = time.Now()
+	)
+
+	for _, t := range s.tokens {
+		if match(t) {
+			s.remove(t, record, now)
+			count++
+		}
+	}
+
+	return count
+}
+
+// remove deletes a token, recording its revocation if asked to and it hasn't expired yet. Callers hold mu
+func (s *MemoryTokenStore) remove(t Token, record bool, now time.Time) {
+	if record && !isExpired(t.ExpiresAt, now) {
+		s.revocations[t.Token] = Revocation{
+			Token:     t.Token,
+			ExpiresAt: t.ExpiresAt,
+			RevokedAt: now.Unix(),
+		}
+	}
+
+	delete(s.tokens, t.Token)
+}
@@ -0,0 +1,186 @@
+package stores
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestMemoryStore(t *testing.T, interval time.Duration) *MemoryTokenStore {
+	t.Helper()
+
+	store := NewMemoryTokenStore(interval)
+
+	t.Cleanup(func() {
+		store.Close()
+	})
+
+	return store
+}
+
+func TestMemoryIssueLookupRotate(t *testing.T) {
+	store := newTestMemoryStore(t, time.Hour)
+
+	issued := Token{UserID: "u1", FamilyID: "f1", Token: "t1", ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	issueTokens(t, store, issued)
+
+	token, err := store.Lookup("t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *token != issued {
+		t.Errorf("Lookup returned %+v, want %+v", *token, issued)
+	}
+
+	// Lookup hands out a copy, changing it doesn't change the store
+	token.Rotated = true
+
+	if err = store.Rotate("t1"); err != nil {
+		t.Fatalf("first Rotate returned %v", err)
+	}
+
+	if token, err = store.Lookup("t1"); err != nil || !token.Rotated {
+		t.Errorf("Lookup after Rotate returned %+v, %v, want a rotated token", token, err)
+	}
+
+	if err = store.Rotate("t1"); err != ErrTokenRotated {
+		t.Errorf("second Rotate returned %v, want %v", err, ErrTokenRotated)
+	}
+
+	if err = store.Rotate("missing"); err != ErrTokenNotFound {
+		t.Errorf("Rotate of a missing token returned %v, want %v", err, ErrTokenNotFound)
+	}
+
+	if _, err = store.Lookup("missing"); err != ErrTokenNotFound {
+		t.Errorf("Lookup of a missing token returned %v, want %v", err, ErrTokenNotFound)
+	}
+}
+
+func TestMemoryRevoke(t *testing.T) {
+	store := newTestMemoryStore(t, time.Hour)
+
+	now := time.Now()
+	expiresAt := now.Add(time.Hour).Unix()
+	issueTokens(t, store,
+		Token{UserID: "u1", FamilyID: "f1", Token: "a1", ExpiresAt: expiresAt},
+		Token{UserID: "u1", FamilyID: "f1", Token: "a2", ExpiresAt: expiresAt},
+		Token{UserID: "u1", FamilyID: "f2", Token: "b1", ExpiresAt: expiresAt},
+		Token{UserID: "u1", FamilyID: "f2", Token: "stale", ExpiresAt: now.Add(-time.Hour).Unix()},
+		Token{UserID: "u2", FamilyID: "f3", Token: "c1", ExpiresAt: expiresAt},
+	)
+
+	if err := store.Revoke("a1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Revoke("missing"); err != nil {
+		t.Errorf("Revoke of a missing token returned %v", err)
+	}
+
+	if removed, err := store.RevokeFamily("f1"); err != nil || removed != 1 {
+		t.Errorf("RevokeFamily returned %d, %v, want a2 removed", removed, err)
+	}
+
+	if removed, err := store.RevokeAll("u1"); err != nil || removed != 2 {
+		t.Errorf("RevokeAll returned %d, %v, want b1 and stale removed", removed, err)
+	}
+
+	for _, token := range []string{"a1", "a2", "b1", "stale"} {
+		if _, err := store.Lookup(token); err != ErrTokenNotFound {
+			t.Errorf("Lookup of revoked %s returned %v, want %v", token, err, ErrTokenNotFound)
+		}
+	}
+
+	if _, err := store.Lookup("c1"); err != nil {
+		t.Errorf("Lookup of another user's token returned %v", err)
+	}
+
+	// The stale token had nothing left to deny, so only the live ones are recorded
+	revocations, err := store.Revoked(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(revocations) != 3 {
+		t.Errorf("Revoked listed %v, want a1, a2 and b1", revocations)
+	}
+
+	for _, revocation := range revocations {
+		if revocation.Token == "stale" || revocation.ExpiresAt != expiresAt || revocation.RevokedAt < now.Unix() {
+			t.Errorf("revocation %+v, want it to expire with its live token", revocation)
+		}
+	}
+
+	if revocations, err = store.Revoked(now.Add(time.Minute).Unix()); err != nil || len(revocations) != 0 {
+		t.Errorf("Revoked since a minute from now returned %v, %v, want none", revocations, err)
+	}
+}
+
+func TestMemorySweepExpired(t *testing.T) {
+	store := newTestMemoryStore(t, time.Hour)
+
+	now := time.Now()
+	issueTokens(t, store,
+		Token{UserID: "u1", FamilyID: "f1", Token: "expired", ExpiresAt: now.Add(-time.Minute).Unix()},
+		Token{UserID: "u1", FamilyID: "f1", Token: "live", ExpiresAt: now.Add(time.Hour).Unix()},
+		Token{UserID: "u2", FamilyID: "f2", Token: "revoked", ExpiresAt: now.Add(time.Hour).Unix()},
+	)
+
+	if err := store.Revoke("revoked"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Revocations only outlive their token when the clock moved on, so the expired one is written directly
+	store.revocations["gone"] = Revocation{Token: "gone", ExpiresAt: now.Add(-time.Minute).Unix(), RevokedAt: now.Add(-time.Hour).Unix()}
+
+	if revocations, err := store.Revoked(0); err != nil || len(revocations) != 1 {
+		t.Errorf("Revoked returned %v, %v, want the expired revocation left out", revocations, err)
+	}
+
+	removed, err := store.SweepExpired()
+	if err != nil || removed != 2 {
+		t.Fatalf("SweepExpired returned %d, %v, want the expired token and revocation removed", removed, err)
+	}
+
+	if _, err = store.Lookup("live"); err != nil {
+		t.Errorf("Lookup of a live token after SweepExpired returned %v", err)
+	}
+
+	if _, ok := store.revocations["revoked"]; !ok || len(store.revocations) != 1 {
+		t.Errorf("revocations after SweepExpired are %v, want the unexpired one", store.revocations)
+	}
+}
+
+func TestMemoryJanitor(t *testing.T) {
+	store := newTestMemoryStore(t, 10*time.Millisecond)
+
+	issueTokens(t, store,
+		Token{UserID: "u1", FamilyID: "f1", Token: "expired", ExpiresAt: time.Now().Add(-time.Minute).Unix()},
+		Token{UserID: "u1", FamilyID: "f1", Token: "live", ExpiresAt: time.Now().Add(time.Hour).Unix()},
+	)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := store.Lookup("expired"); err == ErrTokenNotFound {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("janitor didn't sweep an expired token within a second")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := store.Lookup("live"); err != nil {
+		t.Errorf("Lookup of a live token after the janitor ran returned %v", err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Errorf("second Close returned %v", err)
+	}
+}
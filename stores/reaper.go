@@ -0,0 +1,121 @@
+package stores
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultReaperInterval used when TOKEN_SWEEP_INTERVAL is not set
+const DefaultReaperInterval = time.Minute
+
+// Reaper type to periodically remove expired tokens from a store, along with any other sweeps added to its schedule
+type Reaper struct {
+	store    TokenStore
+	interval time.Duration
+	jobs     []reaperJob
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+type reaperJob struct {
+	name  string
+	sweep func() (int64, error)
+}
+
+// ReaperInterval function to read the sweep interval from the TOKEN_SWEEP_INTERVAL env var (eg. 30s, 5m)
+func ReaperInterval() time.Duration {
+	value := os.Getenv("TOKEN_SWEEP_INTERVAL")
+	if value == "" {
+		return DefaultReaperInterval
+	}
+
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		log.Warn().Str("value", value).Msg("Invalid TOKEN_SWEEP_INTERVAL, using default")
+		return DefaultReaperInterval
+	}
+
+	return interval
+}
+
+// NewReaper function to create a reaper for the given store
+func NewReaper(store TokenStore, interval time.Duration) *Reaper {
+	return &Reaper{
+		store:    store,
+		interval: interval,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// Also function to run another sweep on the reaper's schedule, name tells it apart in logs. Must be called before Start
+func (r *Reaper) Also(name string, sweep func() (int64, error)) {
+	r.jobs = append(r.jobs, reaperJob{name: name, sweep: sweep})
+}
+
+// Start function to run the reaper in the background
+func (r *Reaper) Start() {
+	log.Info().Dur("interval", r.interval).Msg("Token reaper started")
+
+	go func() {
+		defer close(r.stopped)
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.Sweep()
+				r.runJobs()
+			case <-r.done:
+				return
+			}
+		}
+	}()
+}
+
+// Sweep function to remove expired tokens once and report the number removed
+func (r *Reaper) Sweep() (int64, error) {
+	removed, err := r.store.SweepExpired()
+	if err != nil {
+		log.Err(err).Msg("Token reaper sweep failed")
+		return 0, err
+	}
+
+	if removed > 0 {
+		log.Info().Int64("removed", removed).Msg("Token reaper removed expired tokens")
+	}
+
+	return removed, nil
+}
+
+func (r *Reaper) runJobs() {
+	for _, job := range r.jobs {
+		removed, err := job.sweep()
+		if err != nil {
+			log.Err(err).Str("job", job.name).Msg("Reaper sweep failed")
+			continue
+		}
+
+		if removed > 0 {
+			log.Info().Str("job", job.name).Int64("removed", removed).Msg("Reaper removed expired entries")
+		}
+	}
+}
+
+// Stop function to stop the reaper, waiting for an in-flight sweep until ctx is done
+func (r *Reaper) Stop(ctx context.Context) error {
+	close(r.done)
+
+	select {
+	case <-r.stopped:
+		log.Info().Msg("Token reaper stopped")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,309 @@
+package stores
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	redisTokenPrefix      = "auth:token:"
+	redisRevocationPrefix = "auth:revocation:"
+	redisUserPrefix       = "auth:user:"
+	redisFamilyPrefix     = "auth:family:"
+	redisRevocationsKey   = "auth:revocations"
+)
+
+// issueScript stores the token hash expiring with the token, and indexes it in the user and family sets which live as long as their longest token
+var issueScript = redis.NewScript(3, `
+redis.call('HSET', KEYS[1], 'userId', ARGV[1], 'familyId', ARGV[2], 'expiresAt', ARGV[3], 'rotated', ARGV[4])
+redis.call('EXPIREAT', KEYS[1], ARGV[3])
+for i = 2, 3 do
+	redis.call('SADD', KEYS[i], ARGV[5])
+	if redis.call('TTL', KEYS[i]) < tonumber(ARGV[6]) then
+		redis.call('EXPIRE', KEYS[i], ARGV[6])
+	end
+end
+return 1
+`)
+
+// rotateScript flags a token as used, returns 0 if it's missing and -1 if it was already rotated
+var rotateScript = redis.NewScript(1, `
+local rotated = redis.call('HGET', KEYS[1], 'rotated')
+if not rotated then
+	return 0
+end
+if rotated == '1' then
+	return -1
+end
+redis.call('HSET', KEYS[1], 'rotated', '1')
+return 1
+`)
+
+// revokeScript removes a token and its set memberships, recording a revocation until the token would have expired.
+// The user and family sets are read from the token beforehand, a token's owner never changes but is checked so the sets are the right ones
+var revokeScript = redis.NewScript(5, `
+local token = redis.call('HMGET', KEYS[1], 'userId', 'familyId', 'expiresAt')
+if not token[3] then
+	return 0
+end
+if token[1] ~= ARGV[3] or token[2] ~= ARGV[4] then
+	return redis.error_reply('Token owner changed while revoking')
+end
+redis.call('DEL', KEYS[1])
+redis.call('SREM', KEYS[4], ARGV[1])
+redis.call('SREM', KEYS[5], ARGV[1])
+if tonumber(token[3]) >= tonumber(ARGV[2]) then
+	redis.call('SET', KEYS[2], token[3])
+	redis.call('EXPIREAT', KEYS[2], token[3])
+	redis.call('ZADD', KEYS[3], ARGV[2], ARGV[1])
+end
+return 1
+`)
+
+// pruneScript drops revocation index entries whose revocation key has already expired, KEYS[1] is the index and every
+// following key the revocation of the token in the same position in ARGV
+var pruneScript = redis.NewScript(-1, `
+local removed = 0
+for i = 2, #KEYS do
+	if redis.call('EXISTS', KEYS[i]) == 0 then
+		redis.call('ZREM', KEYS[1], ARGV[i - 1])
+		removed = removed + 1
+	end
+end
+return removed
+`)
+
+// redisPruneBatchSize bounds the revocations checked by a single pruneScript call
+const redisPruneBatchSize = 500
+
+// RedisTokenStore type backed by any server speaking the Redis protocol, tokens expire through key TTLs
+type RedisTokenStore struct {
+	pool *redis.Pool
+}
+
+// NewRedisTokenStore function to create a token store connected to the given redis:// URL
+func NewRedisTokenStore(url string) (*RedisTokenStore, error) {
+	s := &RedisTokenStore{
+		pool: &redis.Pool{
+			MaxIdle:     8,
+			IdleTimeout: time.Minute * 4,
+			Dial: func() (redis.Conn, error) {
+				return redis.DialURL(url)
+			},
+		},
+	}
+
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("PING"); err != nil {
+		s.pool.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Issue function to store a token
+func (s *RedisTokenStore) Issue(token Token) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	rotated := 0
+	if token.Rotated {
+		rotated = 1
+	}
+
+	ttl := token.ExpiresAt - time.Now().Unix()
+	if ttl < 1 {
+		ttl = 1
+	}
+
+	_, err := issueScript.Do(conn,
+		redisTokenPrefix+token.Token, redisUserPrefix+token.UserID, redisFamilyPrefix+token.FamilyID,
+		token.UserID, token.FamilyID, token.ExpiresAt, rotated, token.Token, ttl)
+
+	return err
+}
+
+// Lookup function to retrieve a token
+func (s *RedisTokenStore) Lookup(token string) (*Token, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.Strings(conn.Do("HMGET", redisTokenPrefix+token, "userId", "familyId", "expiresAt", "rotated"))
+	if err != nil {
+		return nil, err
+	}
+
+	if values[2] == "" {
+		return nil, ErrTokenNotFound
+	}
+
+	expiresAt, err := strconv.ParseInt(values[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Token{
+		UserID:    values[0],
+		FamilyID:  values[1],
+		Token:     token,
+		ExpiresAt: expiresAt,
+		Rotated:   values[3] == "1",
+	}, nil
+}
+
+// Rotate function to flag a token as used, the script runs atomically so concurrent rotations can't both succeed
+func (s *RedisTokenStore) Rotate(token string) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	result, err := redis.Int(rotateScript.Do(conn, redisTokenPrefix+token))
+	if err != nil {
+		return err
+	}
+
+	switch result {
+	case 0:
+		return ErrTokenNotFound
+	case -1:
+		return ErrTokenRotated
+	}
+
+	return nil
+}
+
+// Revoke function to remove a token
+func (s *RedisTokenStore) Revoke(token string) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	_, err := s.revoke(conn, token, time.Now().Unix())
+
+	return err
+}
+
+// RevokeFamily function to remove every token of a family
+func (s *RedisTokenStore) RevokeFamily(familyID string) (int64, error) {
+	return s.revokeSet(redisFamilyPrefix + familyID)
+}
+
+// RevokeAll function to remove every token of a user
+func (s *RedisTokenStore) RevokeAll(userID string) (int64, error) {
+	return s.revokeSet(redisUserPrefix + userID)
+}
+
+// Revoked function to list revocations
+func (s *RedisTokenStore) Revoked(since int64) ([]Revocation, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	scores, err := redis.Int64Map(conn.Do("ZRANGEBYSCORE", redisRevocationsKey, since, "+inf", "WITHSCORES"))
+	if err != nil {
+		return nil, err
+	}
+
+	var revocations []Revocation
+
+	for token, revokedAt := range scores {
+		expiresAt, err := redis.Int64(conn.Do("GET", redisRevocationPrefix+token))
+		if err == redis.ErrNil {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		revocations = append(revocations, Revocation{
+			Token:     token,
+			ExpiresAt: expiresAt,
+			RevokedAt: revokedAt,
+		})
+	}
+
+	return revocations, nil
+}
+
+// SweepExpired function to prune the revocation index, tokens and revocations themselves are dropped by their TTLs
+func (s *RedisTokenStore) SweepExpired() (int64, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	tokens, err := redis.Strings(conn.Do("ZRANGE", redisRevocationsKey, 0, -1))
+	if err != nil {
+		return 0, err
+	}
+
+	var removed int64
+
+	for start := 0; start < len(tokens); start += redisPruneBatchSize {
+		batch := tokens[start:]
+		if len(batch) > redisPruneBatchSize {
+			batch = batch[:redisPruneBatchSize]
+		}
+
+		args := []interface{}{len(batch) + 1, redisRevocationsKey}
+		for _, token := range batch {
+			args = append(args, redisRevocationPrefix+token)
+		}
+
+		for _, token := range batch {
+			args = append(args, token)
+		}
+
+		n, err := redis.Int64(pruneScript.Do(conn, args...))
+		if err != nil {
+			return removed, err
+		}
+
+		removed += n
+	}
+
+	return removed, nil
+}
+
+// Close function to close every pooled connection
+func (s *RedisTokenStore) Close() error {
+	return s.pool.Close()
+}
+
+func (s *RedisTokenStore) revokeSet(key string) (int64, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	tokens, err := redis.Strings(conn.Do("SMEMBERS", key))
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		count int64
+		now   = time.Now().Unix()
+	)
+
+	for _, token := range tokens {
+		removed, err := s.revoke(conn, token, now)
+		if err != nil {
+			return count, err
+		}
+
+		count += removed
+	}
+
+	return count, nil
+}
+
+func (s *RedisTokenStore) revoke(conn redis.Conn, token string, now int64) (int64, error) {
+	owner, err := redis.Strings(conn.Do("HMGET", redisTokenPrefix+token, "userId", "familyId"))
+	if err != nil {
+		return 0, err
+	}
+
+	return redis.Int64(revokeScript.Do(conn,
+		redisTokenPrefix+token, redisRevocationPrefix+token, redisRevocationsKey, redisUserPrefix+owner[0], redisFamilyPrefix+owner[1],
+		token, now, owner[0], owner[1]))
+}
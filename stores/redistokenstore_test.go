@@ -0,0 +1,263 @@
+package stores
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// newTestRedisStore starts an in-process Redis-compatible server, nothing external is needed
+func newTestRedisStore(t *testing.T) (*RedisTokenStore, *miniredis.Miniredis) {
+	t.Helper()
+
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.SetTime(time.Now())
+
+	store, err := NewRedisTokenStore("redis://" + server.Addr())
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		store.Close()
+		server.Close()
+	})
+
+	return store, server
+}
+
+func issueTokens(t *testing.T, store TokenStore, tokens ...Token) {
+	t.Helper()
+
+	for _, token := range tokens {
+		if err := store.Issue(token); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func expectMembers(t *testing.T, server *miniredis.Miniredis, key string, want ...string) {
+	t.Helper()
+
+	var got []string
+
+	if server.Exists(key) {
+		var err error
+
+		if got, err = server.Members(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sort.Strings(got)
+	sort.Strings(want)
+
+	if len(got) != len(want) {
+		t.Fatalf("%s holds %v, want %v", key, got, want)
+	}
+
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("%s holds %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestRedisIssueLookupRotate(t *testing.T) {
+	store, _ := newTestRedisStore(t)
+
+	issued := Token{UserID: "u1", FamilyID: "f1", Token: "t1", ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	issueTokens(t, store, issued)
+
+	token, err := store.Lookup("t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *token != issued {
+		t.Errorf("Lookup returned %+v, want %+v", *token, issued)
+	}
+
+	if err = store.Rotate("t1"); err != nil {
+		t.Fatalf("first Rotate returned %v", err)
+	}
+
+	if token, err = store.Lookup("t1"); err != nil || !token.Rotated {
+		t.Errorf("Lookup after Rotate returned %+v, %v, want a rotated token", token, err)
+	}
+
+	if err = store.Rotate("t1"); err != ErrTokenRotated {
+		t.Errorf("second Rotate returned %v, want %v", err, ErrTokenRotated)
+	}
+
+	if err = store.Rotate("missing"); err != ErrTokenNotFound {
+		t.Errorf("Rotate of a missing token returned %v, want %v", err, ErrTokenNotFound)
+	}
+
+	if _, err = store.Lookup("missing"); err != ErrTokenNotFound {
+		t.Errorf("Lookup of a missing token returned %v, want %v", err, ErrTokenNotFound)
+	}
+}
+
+func TestRedisRevokeRemovesSetMembers(t *testing.T) {
+	store, server := newTestRedisStore(t)
+
+	expiresAt := time.Now().Add(time.Hour).Unix()
+	issueTokens(t, store,
+		Token{UserID: "u1", FamilyID: "f1", Token: "a1", ExpiresAt: expiresAt},
+		Token{UserID: "u1", FamilyID: "f1", Token: "a2", ExpiresAt: expiresAt},
+		Token{UserID: "u1", FamilyID: "f2", Token: "b1", ExpiresAt: expiresAt},
+		Token{UserID: "u1", FamilyID: "f2", Token: "b2", ExpiresAt: expiresAt},
+		Token{UserID: "u2", FamilyID: "f3", Token: "c1", ExpiresAt: expiresAt},
+	)
+
+	if err := store.Revoke("b2"); err != nil {
+		t.Fatal(err)
+	}
+
+	expectMembers(t, server, redisUserPrefix+"u1", "a1", "a2", "b1")
+	expectMembers(t, server, redisFamilyPrefix+"f2", "b1")
+
+	removed, err := store.RevokeFamily("f1")
+	if err != nil || removed != 2 {
+		t.Fatalf("RevokeFamily returned %d, %v, want 2 removed", removed, err)
+	}
+
+	expectMembers(t, server, redisFamilyPrefix+"f1")
+	expectMembers(t, server, redisUserPrefix+"u1", "b1")
+
+	if removed, err = store.RevokeAll("u1"); err != nil || removed != 1 {
+		t.Fatalf("RevokeAll returned %d, %v, want 1 removed", removed, err)
+	}
+
+	expectMembers(t, server, redisUserPrefix+"u1")
+	expectMembers(t, server, redisFamilyPrefix+"f2")
+	expectMembers(t, server, redisUserPrefix+"u2", "c1")
+
+	for _, token := range []string{"a1", "a2", "b1", "b2"} {
+		if _, err = store.Lookup(token); err != ErrTokenNotFound {
+			t.Errorf("Lookup of revoked %s returned %v, want %v", token, err, ErrTokenNotFound)
+		}
+	}
+
+	if _, err = store.Lookup("c1"); err != nil {
+		t.Errorf("Lookup of another user's token returned %v", err)
+	}
+
+	revocations, err := store.Revoked(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(revocations) != 4 {
+		t.Errorf("Revoked listed %d revocations, want 4", len(revocations))
+	}
+}
+
+func TestRedisKeysExpire(t *testing.T) {
+	store, server := newTestRedisStore(t)
+
+	now := time.Now()
+	issueTokens(t, store,
+		Token{UserID: "u1", FamilyID: "f1", Token: "short", ExpiresAt: now.Add(time.Minute).Unix()},
+		Token{UserID: "u1", FamilyID: "f1", Token: "long", ExpiresAt: now.Add(time.Hour).Unix()},
+	)
+
+	// The sets live as long as their longest token
+	if ttl := server.TTL(redisUserPrefix + "u1"); ttl < 59*time.Minute {
+		t.Errorf("user set expires in %s, want about an hour", ttl)
+	}
+
+	if err := store.Revoke("long"); err != nil {
+		t.Fatal(err)
+	}
+
+	server.FastForward(2 * time.Minute)
+
+	if _, err := store.Lookup("short"); err != ErrTokenNotFound {
+		t.Errorf("Lookup of an expired token returned %v, want %v", err, ErrTokenNotFound)
+	}
+
+	if server.Exists(redisTokenPrefix + "short") {
+		t.Error("expired token key wasn't dropped by its TTL")
+	}
+
+	if !server.Exists(redisRevocationPrefix + "long") {
+		t.Fatal("revocation dropped before its token would have expired")
+	}
+
+	server.FastForward(time.Hour)
+
+	if server.Exists(redisRevocationPrefix+"long") || server.Exists(redisUserPrefix+"u1") || server.Exists(redisFamilyPrefix+"f1") {
+		t.Error("revocation or sets outlived the tokens they track")
+	}
+
+	// Only the revocation index needs sweeping, its entries outlive their TTL'd revocation keys
+	removed, err := store.SweepExpired()
+	if err != nil || removed != 1 {
+		t.Errorf("SweepExpired returned %d, %v, want 1 removed", removed, err)
+	}
+
+	if revocations, err := store.Revoked(0); err != nil || len(revocations) != 0 {
+		t.Errorf("Revoked after expiry returned %v, %v, want none", revocations, err)
+	}
+}
+
+func TestRedisRevokeChecksTheSetsItIsGiven(t *testing.T) {
+	store, server := newTestRedisStore(t)
+
+	issueTokens(t, store, Token{UserID: "u1", FamilyID: "f1", Token: "a1", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+
+	conn := store.pool.Get()
+	defer conn.Close()
+
+	// Every key the script touches comes in KEYS, sets that aren't the token's are refused rather than updated
+	_, err := revokeScript.Do(conn,
+		redisTokenPrefix+"a1", redisRevocationPrefix+"a1", redisRevocationsKey, redisUserPrefix+"u2", redisFamilyPrefix+"f1",
+		"a1", time.Now().Unix(), "u2", "f1")
+	if err == nil {
+		t.Error("revokeScript with another user's set succeeded, want an error")
+	}
+
+	if _, err = store.Lookup("a1"); err != nil {
+		t.Errorf("Lookup after a refused revocation returned %v, want the token kept", err)
+	}
+
+	expectMembers(t, server, redisUserPrefix+"u1", "a1")
+}
+
+func TestRedisSweepExpiredInBatches(t *testing.T) {
+	store, server := newTestRedisStore(t)
+
+	now := time.Now()
+	count := redisPruneBatchSize + 10
+
+	for i := 0; i < count; i++ {
+		token := Token{UserID: "u1", FamilyID: "f1", Token: "short" + strconv.Itoa(i), ExpiresAt: now.Add(time.Minute).Unix()}
+		issueTokens(t, store, token)
+	}
+
+	issueTokens(t, store, Token{UserID: "u1", FamilyID: "f2", Token: "long", ExpiresAt: now.Add(time.Hour).Unix()})
+
+	if removed, err := store.RevokeAll("u1"); err != nil || removed != int64(count+1) {
+		t.Fatalf("RevokeAll returned %d, %v, want %d removed", removed, err, count+1)
+	}
+
+	server.FastForward(2 * time.Minute)
+
+	if removed, err := store.SweepExpired(); err != nil || removed != int64(count) {
+		t.Errorf("SweepExpired returned %d, %v, want %d removed", removed, err, count)
+	}
+
+	if revocations, err := store.Revoked(0); err != nil || len(revocations) != 1 || revocations[0].Token != "long" {
+		t.Errorf("Revoked after the sweep returned %v, %v, want only long", revocations, err)
+	}
+}
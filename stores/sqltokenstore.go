@@ -0,0 +1,167 @@
+package stores
+
+import (
+	"time"
+
+	"github.com/zoundwavedj/cybersecurity/database"
+)
+
+// SQLTokenStore type backed by the authentication table of whichever database database.Db is connected to
+type SQLTokenStore struct{}
+
+// NewSQLTokenStore function to create a token store on top of database.Db
+func NewSQLTokenStore() *SQLTokenStore {
+	return &SQLTokenStore{}
+}
+
+// Issue function to insert a token row
+func (s *SQLTokenStore) Issue(token Token) error {
+	_, err := s.exec("INSERT INTO authentication (userId, familyId, token, expiresAt, rotated) VALUES (?, ?, ?, ?, ?)", token.UserID, token.FamilyID, token.Token, token.ExpiresAt, token.Rotated)
+
+	return err
+}
+
+// Lookup function to retrieve a token row
+func (s *SQLTokenStore) Lookup(token string) (*Token, error) {
+	rows, err := database.Db.Query(database.Rebind(database.Driver, "SELECT userId, familyId, token, expiresAt, rotated FROM authentication WHERE token=? LIMIT 1"), token)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, ErrTokenNotFound
+	}
+
+	var t Token
+
+	if err = rows.Scan(&t.UserID, &t.FamilyID, &t.Token, &t.ExpiresAt, &t.Rotated); err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// Rotate function to flag a token row as used, the conditional update keeps concurrent rotations from both succeeding
+func (s *SQLTokenStore) Rotate(token string) error {
+	affected, err := s.exec("UPDATE authentication SET rotated=? WHERE token=? AND rotated=?", true, token, false)
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		if _, err = s.Lookup(token); err != nil {
+			return err
+		}
+
+		return ErrTokenRotated
+	}
+
+	return nil
+}
+
+// Revoke function to delete a token row
+func (s *SQLTokenStore) Revoke(token string) error {
+	_, err := s.revokeWhere("token=?", token)
+
+	return err
+}
+
+// RevokeFamily function to delete every token row of a family
+func (s *SQLTokenStore) RevokeFamily(familyID string) (int64, error) {
+	return s.revokeWhere("familyId=?", familyID)
+}
+
+// RevokeAll function to delete every token row of a user
+func (s *SQLTokenStore) RevokeAll(userID string) (int64, error) {
+	return s.revokeWhere("userId=?", userID)
+}
+
+// Revoked function to list revocation rows
+func (s *SQLTokenStore) Revoked(since int64) ([]Revocation, error) {
+	rows, err := database.Db.Query(database.Rebind(database.Driver, "SELECT token, expiresAt, revokedAt FROM revocation WHERE revokedAt>=? AND expiresAt>=?"), since, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revocations []Revocation
+
+	for rows.Next() {
+		var r Revocation
+
+		if err = rows.Scan(&r.Token, &r.ExpiresAt, &r.RevokedAt); err != nil {
+			return nil, err
+		}
+
+		revocations = append(revocations, r)
+	}
+
+	return revocations, rows.Err()
+}
+
+// SweepExpired function to delete every expired token and revocation row
+func (s *SQLTokenStore) SweepExpired() (int64, error) {
+	now := time.Now().Unix()
+
+	tokens, err := s.exec("DELETE FROM authentication WHERE expiresAt<?", now)
+	if err != nil {
+		return 0, err
+	}
+
+	revocations, err := s.exec("DELETE FROM revocation WHERE expiresAt<?", now)
+	if err != nil {
+		return 0, err
+	}
+
+	return tokens + revocations, nil
+}
+
+// Close function, the underlying database.Db is owned by main
+func (s *SQLTokenStore) Close() error {
+	return nil
+}
+
+func (s *SQLTokenStore) exec(query string, args ...interface{}) (int64, error) {
+	statement, err := database.Db.Prepare(database.Rebind(database.Driver, query))
+	if err != nil {
+		return 0, err
+	}
+	defer statement.Close()
+
+	result, err := statement.Exec(args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// revokeWhere records revocations for the unexpired matching rows and deletes them in one transaction
+func (s *SQLTokenStore) revokeWhere(where string, arg interface{}) (int64, error) {
+	now := time.Now().Unix()
+
+	tx, err := database.Db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(database.Rebind(database.Driver, "INSERT INTO revocation (token, expiresAt, revokedAt) SELECT token, expiresAt, CAST(? AS BIGINT) FROM authentication WHERE "+where+" AND expiresAt>=? "+
+		"ON CONFLICT (token) DO UPDATE SET expiresAt=excluded.expiresAt, revokedAt=excluded.revokedAt"), now, arg, now)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	result, err := tx.Exec(database.Rebind(database.Driver, "DELETE FROM authentication WHERE "+where), arg)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,105 @@
+package stores
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Token type
+type Token struct {
+	UserID    string
+	FamilyID  string
+	Token     string
+	ExpiresAt int64
+	Rotated   bool
+}
+
+// Revocation type recording a token revoked before its expiry
+type Revocation struct {
+	Token     string
+	ExpiresAt int64
+	RevokedAt int64
+}
+
+// TokenStore interface for persisting issued token IDs
+type TokenStore interface {
+	// Issue records a token ID for its user and family until ExpiresAt (unix seconds)
+	Issue(token Token) error
+	// Lookup returns the record for a token ID, or ErrTokenNotFound
+	Lookup(token string) (*Token, error)
+	// Rotate marks a refresh token ID as used, or returns ErrTokenRotated if it already was
+	Rotate(token string) error
+	// Revoke removes a single token ID, recording a revocation if it hasn't expired yet
+	Revoke(token string) error
+	// RevokeFamily removes every token descended from the same login and returns the count removed
+	RevokeFamily(familyID string) (int64, error)
+	// RevokeAll removes every token belonging to a user and returns the count removed
+	RevokeAll(userID string) (int64, error)
+	// Revoked lists unexpired revocations recorded at or after since (unix seconds)
+	Revoked(since int64) ([]Revocation, error)
+	// SweepExpired removes every expired token and revocation and returns the count removed
+	SweepExpired() (int64, error)
+	// Close releases any resources held by the store
+	Close() error
+}
+
+const (
+	// DATABASE store type, the authentication table of the configured database
+	DATABASE = "database"
+	// SQLITE store type, kept as an alias of DATABASE
+	SQLITE = "sqlite"
+	// REDIS store type, any server speaking the Redis protocol at REDIS_URL
+	REDIS = "redis"
+	// DefaultRedisURL used when REDIS_URL is not set
+	DefaultRedisURL = "redis://localhost:6379/0"
+	// MEMORY store type
+	MEMORY = "memory"
+)
+
+var (
+	// ErrTokenNotFound error
+	ErrTokenNotFound = errors.New("Token not found")
+	// ErrTokenRotated error
+	ErrTokenRotated = errors.New("Token already rotated")
+	// Tokens global var
+	Tokens TokenStore
+)
+
+// Setup function for selecting the token store given the TOKEN_STORE env var
+func Setup() {
+	storeType := strings.ToLower(strings.TrimSpace(os.Getenv("TOKEN_STORE")))
+	if storeType == "" {
+		storeType = DATABASE
+	}
+
+	switch storeType {
+	case DATABASE, SQLITE:
+		Tokens = NewSQLTokenStore()
+	case REDIS:
+		url := os.Getenv("REDIS_URL")
+		if url == "" {
+			url = DefaultRedisURL
+		}
+
+		store, err := NewRedisTokenStore(url)
+		if err != nil {
+			log.Fatal().Err(err).Msg("")
+		}
+
+		Tokens = store
+	case MEMORY:
+		Tokens = NewMemoryTokenStore(time.Minute)
+	default:
+		log.Fatal().Str("store", storeType).Msg("Unknown token store")
+	}
+
+	log.Info().Str("store", storeType).Msg("Token store ready")
+}
+
+func isExpired(expiresAt int64, now time.Time) bool {
+	return now.After(time.Unix(expiresAt, 0))
+}
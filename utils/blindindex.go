@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MinNamePrefix is the shortest name prefix indexed, single letters would sort every user into a couple of dozen groups
+	MinNamePrefix = 2
+	// MaxNamePrefix is the longest name prefix indexed
+	MaxNamePrefix = 10
+)
+
+// ErrBlindIndexKey error
+var ErrBlindIndexKey = errors.New("BLIND_INDEX_KEY must be a hex encoded key of at least 32 bytes")
+
+// SsnIndex function to compute the blind index of an SSN, only digits count so 123-45-6789 and 123456789 match. Empty SSNs aren't indexed
+func SsnIndex(ssn string) (string, error) {
+	digits := digitsOnly(ssn)
+	if digits == "" {
+		return "", nil
+	}
+
+	return blindIndex("ssn", digits)
+}
+
+// EmailIndex function to compute the blind index of an email, case and surrounding spaces are ignored. Empty emails aren't indexed
+func EmailIndex(email string) (string, error) {
+	normalized := strings.ToLower(strings.TrimSpace(email))
+	if normalized == "" {
+		return "", nil
+	}
+
+	return blindIndex("email", normalized)
+}
+
+// EmailDomainIndex function to compute the blind index of the domain of an email, emails without one aren't indexed
+func EmailDomainIndex(email string) (string, error) {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return "", nil
+	}
+
+	return DomainIndex(email[at+1:])
+}
+
+// DomainIndex function to compute the blind index of an email domain, case and surrounding spaces are ignored. Empty domains aren't indexed
+func DomainIndex(domain string) (string, error) {
+	normalized := strings.ToLower(strings.TrimSpace(domain))
+	if normalized == "" {
+		return "", nil
+	}
+
+	return blindIndex("email_domain", normalized)
+}
+
+// NamePrefixIndexes function to compute the blind indexes of every prefix of a name from MinNamePrefix to MaxNamePrefix characters long,
+// case and surrounding spaces are ignored. Names shorter than MinNamePrefix aren't indexed
+func NamePrefixIndexes(name string) ([]string, error) {
+	runes := []rune(normalizeName(name))
+	indexes := []string{}
+
+	for n := MinNamePrefix; n <= len(runes) && n <= MaxNamePrefix; n++ {
+		index, err := blindIndex("name_prefix", string(runes[:n]))
+		if err != nil {
+			return nil, err
+		}
+
+		indexes = append(indexes, index)
+	}
+
+	return indexes, nil
+}
+
+// NamePrefixIndex function to compute the blind index a name prefix filter matches, case and surrounding spaces are ignored.
+// Prefixes outside MinNamePrefix to MaxNamePrefix characters have no index to match
+func NamePrefixIndex(prefix string) (string, error) {
+	normalized := normalizeName(prefix)
+	if n := len([]rune(normalized)); n < MinNamePrefix || n > MaxNamePrefix {
+		return "", nil
+	}
+
+	return blindIndex("name_prefix", normalized)
+}
+
+// DobYearIndex function to compute the blind index of the birth year of a YYYY-MM-DD date of birth, dates without a year aren't indexed
+func DobYearIndex(dob string) (string, error) {
+	year, err := strconv.Atoi(strings.SplitN(strings.TrimSpace(dob), "-", 2)[0])
+	if err != nil {
+		return "", nil
+	}
+
+	return YearIndex(year)
+}
+
+// YearIndex function to compute the blind index a birth year filter matches
+func YearIndex(year int) (string, error) {
+	return blindIndex("dob_year", strconv.Itoa(year))
+}
+
+// blindIndex keys an HMAC-SHA256 with BLIND_INDEX_KEY, never ENCRYPT_KEY, and prefixes the field so equal values in different fields don't match
+func blindIndex(field string, value string) (string, error) {
+	key, err := hex.DecodeString(os.Getenv("BLIND_INDEX_KEY"))
+	if err != nil || len(key) < 32 {
+		return "", ErrBlindIndexKey
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(field + ":" + value))
+
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+func digitsOnly(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+
+		return -1
+	}, value)
+}
@@ -1,52 +1,178 @@
 package utils
 
 import (
-	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"os"
+	"strings"
+)
+
+const (
+	// boundVersion prefixes ciphertexts sealed with their EncryptionContext as associated data, formatted as
+	// v3:<KEK version>:<wrapped data key>:<nonce || ciphertext>
+	boundVersion = "v3"
+	// envelopeVersion prefixes envelope ciphertexts from before they were bound to their record, same layout as v3
+	envelopeVersion = "v2"
 )
 
 var (
-	encodedKey = os.Getenv("ENCRYPT_KEY")
+	// ErrNoKeyProvider error
+	ErrNoKeyProvider = errors.New("Key provider is not set up")
+	// ErrInvalidCiphertext error
+	ErrInvalidCiphertext = errors.New("Ciphertext format is invalid")
+	// ErrUnboundCiphertext error
+	ErrUnboundCiphertext = errors.New("Ciphertext isn't bound to its record, re-encrypt it")
+	encodedKey           = os.Getenv("ENCRYPT_KEY")
+	requireBound         = os.Getenv("ENCRYPTION_REQUIRE_BOUND") == "true"
 )
 
-func initCipher() (cipher.AEAD, error) {
-	key, err := hex.DecodeString(encodedKey)
+// EncryptionContext type naming where a ciphertext is stored, it's authenticated as associated data so the ciphertext only decrypts there
+type EncryptionContext struct {
+	Table    string
+	Column   string
+	RecordID string
+}
+
+// additionalData length-prefixes every field so no two contexts encode alike
+func (c EncryptionContext) additionalData() []byte {
+	var data []byte
+
+	for _, field := range []string{c.Table, c.Column, c.RecordID} {
+		length := make([]byte, 4)
+		binary.BigEndian.PutUint32(length, uint32(len(field)))
+		data = append(append(data, length...), field...)
+	}
+
+	return data
+}
+
+// legacyCipher builds the cipher ciphertexts were sealed with directly before envelope encryption,
+// ENCRYPT_LEGACY_KEY keeps them readable once ENCRYPT_KEY has been rotated
+func legacyCipher() (cipher.AEAD, error) {
+	encoded := os.Getenv("ENCRYPT_LEGACY_KEY")
+	if encoded == "" {
+		encoded = encodedKey
+	}
+
+	key, err := hex.DecodeString(encoded)
 	if err != nil {
 		return nil, err
 	}
 
-	block, err := aes.NewCipher(key)
+	return newAEAD(key)
+}
+
+// Encrypt function to seal plaintext under a fresh data key, which is wrapped by the active KEK and stored alongside it.
+// The ciphertext is bound to context and won't decrypt anywhere else
+func Encrypt(plaintext string, context EncryptionContext) (string, error) {
+	if Keys == nil {
+		return "", ErrNoKeyProvider
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+
+	aead, err := newAEAD(dataKey)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+
+	sealed, err := seal(aead, []byte(plaintext), context.additionalData())
+	if err != nil {
+		return "", err
 	}
 
-	return cipher.NewGCM(block)
+	version, wrapped, err := Keys.Wrap(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join([]string{
+		boundVersion,
+		version,
+		base64.RawStdEncoding.EncodeToString(wrapped),
+		base64.RawStdEncoding.EncodeToString(sealed),
+	}, ":"), nil
 }
 
-// Encrypt function
-func Encrypt(plaintext string) (string, error) {
-	cipher, err := initCipher()
+// Decrypt function to open a ciphertext stored at context. Unbound v2 and pre-envelope ciphertexts are still opened
+// until ENCRYPTION_REQUIRE_BOUND=true, once `reencrypt users` has bound them all
+func Decrypt(ciphertext string, context EncryptionContext) (string, error) {
+	parts := strings.Split(ciphertext, ":")
+	if len(parts) == 1 {
+		if requireBound {
+			return "", ErrUnboundCiphertext
+		}
+
+		return decryptLegacy(ciphertext)
+	}
+
+	if len(parts) != 4 || (parts[0] != boundVersion && parts[0] != envelopeVersion) {
+		return "", ErrInvalidCiphertext
+	}
+
+	var additionalData []byte
+
+	if parts[0] == boundVersion {
+		additionalData = context.additionalData()
+	} else if requireBound {
+		return "", ErrUnboundCiphertext
+	}
+
+	if Keys == nil {
+		return "", ErrNoKeyProvider
+	}
+
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[2])
 	if err != nil {
 		return "", err
 	}
 
-	nonce := make([]byte, cipher.NonceSize())
-	if _, err = rand.Read(nonce); err != nil {
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
 		return "", err
 	}
 
-	ciphertext := cipher.Seal(nonce, nonce, []byte(plaintext), nil)
+	dataKey, err := Keys.Unwrap(parts[1], wrapped)
+	if err == ErrUnknownKeyVersion && PreviousKeys != nil {
+		dataKey, err = PreviousKeys.Unwrap(parts[1], wrapped)
+	}
+
+	if err != nil {
+		return "", err
+	}
 
-	return base64.RawStdEncoding.EncodeToString(ciphertext), nil
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := open(aead, sealed, additionalData)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
 }
 
-// Decrypt function
-func Decrypt(ciphertext string) (string, error) {
-	cipher, err := initCipher()
+// NeedsRotation function to check if a ciphertext isn't bound to its record or its data key isn't wrapped by the active KEK
+func NeedsRotation(ciphertext string) bool {
+	parts := strings.SplitN(ciphertext, ":", 3)
+	if len(parts) < 3 || parts[0] != boundVersion {
+		return true
+	}
+
+	return Keys == nil || parts[1] != Keys.Active()
+}
+
+func decryptLegacy(ciphertext string) (string, error) {
+	cipher, err := legacyCipher()
 	if err != nil {
 		return "", err
 	}
@@ -56,10 +182,7 @@ func Decrypt(ciphertext string) (string, error) {
 		return "", err
 	}
 
-	nonceSize := cipher.NonceSize()
-	nonce, encryptedstring := decodedCipherText[:nonceSize], decodedCipherText[nonceSize:]
-
-	plaintext, err := cipher.Open(nil, nonce, encryptedstring, nil)
+	plaintext, err := open(cipher, decodedCipherText, nil)
 	if err != nil {
 		return "", err
 	}
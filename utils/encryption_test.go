@@ -0,0 +1,235 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+var ssnContext = EncryptionContext{Table: "user", Column: "ssn", RecordID: "u1"}
+
+func TestEncryptDecrypt(t *testing.T) {
+	useTestKeys(t)
+
+	for _, plaintext := range []string{"", "123-45-6789", "Zoë ☃", strings.Repeat("a", 4096)} {
+		ciphertext, err := Encrypt(plaintext, ssnContext)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(ciphertext, boundVersion+":1:") || strings.Count(ciphertext, ":") != 3 {
+			t.Errorf("Encrypt(%.20q) = %q, want %s:1:<wrapped key>:<ciphertext>", plaintext, ciphertext, boundVersion)
+		}
+
+		if again, _ := Encrypt(plaintext, ssnContext); again == ciphertext {
+			t.Errorf("Encrypt(%.20q) twice returned the same ciphertext, want a fresh data key and nonce each time", plaintext)
+		}
+
+		if decrypted, err := Decrypt(ciphertext, ssnContext); err != nil || decrypted != plaintext {
+			t.Errorf("Decrypt of Encrypt(%.20q) returned %.20q, %v", plaintext, decrypted, err)
+		}
+
+		if NeedsRotation(ciphertext) {
+			t.Errorf("NeedsRotation of a fresh ciphertext of %.20q = true, want false", plaintext)
+		}
+	}
+}
+
+func TestDecryptInvalidCiphertexts(t *testing.T) {
+	useTestKeys(t)
+
+	valid, err := Encrypt("123-45-6789", ssnContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(valid, ":")
+	tampered := []byte(parts[3])
+	tampered[len(tampered)-1] ^= 'A' ^ 'B'
+
+	tests := []struct {
+		name       string
+		ciphertext string
+		want       error
+	}{
+		{"too few parts", strings.Join(parts[:3], ":"), ErrInvalidCiphertext},
+		{"too many parts", valid + ":extra", ErrInvalidCiphertext},
+		{"unknown format", strings.Join(append([]string{"v9"}, parts[1:]...), ":"), ErrInvalidCiphertext},
+		{"unknown KEK version", strings.Join([]string{parts[0], "9", parts[2], parts[3]}, ":"), ErrUnknownKeyVersion},
+		{"tampered ciphertext", strings.Join([]string{parts[0], parts[1], parts[2], string(tampered)}, ":"), nil},
+		{"not base64", strings.Join([]string{parts[0], parts[1], "%%", parts[3]}, ":"), nil},
+	}
+
+	for _, test := range tests {
+		_, err := Decrypt(test.ciphertext, ssnContext)
+
+		if err == nil || (test.want != nil && err != test.want) {
+			t.Errorf("Decrypt of a ciphertext with %s returned %v, want %v", test.name, err, test.want)
+		}
+	}
+
+	Keys = nil
+
+	if _, err = Encrypt("123-45-6789", ssnContext); err != ErrNoKeyProvider {
+		t.Errorf("Encrypt without a key provider returned %v, want %v", err, ErrNoKeyProvider)
+	}
+
+	if _, err = Decrypt(valid, ssnContext); err != ErrNoKeyProvider {
+		t.Errorf("Decrypt without a key provider returned %v, want %v", err, ErrNoKeyProvider)
+	}
+}
+
+func TestDecryptAfterKEKRotation(t *testing.T) {
+	old := useTestKeys(t)
+
+	ciphertext, err := Encrypt("123-45-6789", ssnContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The old KEK stays in the provider as an inactive version
+	if Keys, err = NewStaticKeyProvider("2", map[string][]byte{"1": bytes.Repeat([]byte{1}, 32), "2": bytes.Repeat([]byte{2}, 32)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if plaintext, err := Decrypt(ciphertext, ssnContext); err != nil || plaintext != "123-45-6789" {
+		t.Errorf("Decrypt under a retired KEK version returned %q, %v", plaintext, err)
+	}
+
+	if !NeedsRotation(ciphertext) {
+		t.Error("NeedsRotation of a ciphertext wrapped by a retired KEK = false, want true")
+	}
+
+	// Or it moved to another provider, which Decrypt falls back to
+	if Keys, err = NewStaticKeyProvider("2", map[string][]byte{"2": bytes.Repeat([]byte{2}, 32)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = Decrypt(ciphertext, ssnContext); err != ErrUnknownKeyVersion {
+		t.Errorf("Decrypt under a KEK version nobody holds returned %v, want %v", err, ErrUnknownKeyVersion)
+	}
+
+	PreviousKeys = old
+
+	if plaintext, err := Decrypt(ciphertext, ssnContext); err != nil || plaintext != "123-45-6789" {
+		t.Errorf("Decrypt under a KEK version held by PreviousKeys returned %q, %v", plaintext, err)
+	}
+}
+
+func TestDecryptLegacyCiphertexts(t *testing.T) {
+	useTestKeys(t)
+
+	legacy := legacyEncrypt(t, "123-45-6789")
+
+	if plaintext, err := Decrypt(legacy, ssnContext); err != nil || plaintext != "123-45-6789" {
+		t.Errorf("Decrypt of a pre-envelope ciphertext returned %q, %v", plaintext, err)
+	}
+
+	if !NeedsRotation(legacy) {
+		t.Error("NeedsRotation of a pre-envelope ciphertext = false, want true")
+	}
+
+	// ENCRYPT_LEGACY_KEY takes over once ENCRYPT_KEY has been rotated
+	encodedKey = strings.Repeat("09", 32)
+
+	if _, err := Decrypt(legacy, ssnContext); err == nil {
+		t.Error("Decrypt of a pre-envelope ciphertext under another ENCRYPT_KEY succeeded, want an error")
+	}
+
+	setEnv(t, "ENCRYPT_LEGACY_KEY", strings.Repeat("03", 32))
+
+	if plaintext, err := Decrypt(legacy, ssnContext); err != nil || plaintext != "123-45-6789" {
+		t.Errorf("Decrypt of a pre-envelope ciphertext under ENCRYPT_LEGACY_KEY returned %q, %v", plaintext, err)
+	}
+}
+
+// unboundEncrypt seals plaintext the way v2 did, an envelope without associated data
+func unboundEncrypt(t *testing.T, plaintext string) string {
+	t.Helper()
+
+	dataKey := bytes.Repeat([]byte{5}, 32)
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := seal(aead, []byte(plaintext), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, wrapped, err := Keys.Wrap(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Join([]string{envelopeVersion, version, base64.RawStdEncoding.EncodeToString(wrapped), base64.RawStdEncoding.EncodeToString(sealed)}, ":")
+}
+
+func TestDecryptOutsideItsContext(t *testing.T) {
+	useTestKeys(t)
+
+	ciphertext, err := Encrypt("123-45-6789", ssnContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		context EncryptionContext
+	}{
+		{"another record", EncryptionContext{Table: "user", Column: "ssn", RecordID: "u2"}},
+		{"another column", EncryptionContext{Table: "user", Column: "email", RecordID: "u1"}},
+		{"another table", EncryptionContext{Table: "operator", Column: "ssn", RecordID: "u1"}},
+		{"no context", EncryptionContext{}},
+		// Fields are length-prefixed, moving bytes from one to the next doesn't give the same associated data
+		{"shifted fields", EncryptionContext{Table: "users", Column: "sn", RecordID: "u1"}},
+	}
+
+	for _, test := range tests {
+		if plaintext, err := Decrypt(ciphertext, test.context); err == nil {
+			t.Errorf("Decrypt in %s returned %q, want an error", test.name, plaintext)
+		}
+	}
+}
+
+func TestDecryptUnboundCiphertexts(t *testing.T) {
+	useTestKeys(t)
+
+	unbound := map[string]string{
+		"v2":           unboundEncrypt(t, "123-45-6789"),
+		"pre-envelope": legacyEncrypt(t, "123-45-6789"),
+	}
+
+	// Ciphertexts from before binding open in any context, and are due for re-encryption
+	for name, ciphertext := range unbound {
+		for _, context := range []EncryptionContext{ssnContext, {Table: "user", Column: "ssn", RecordID: "u2"}} {
+			if plaintext, err := Decrypt(ciphertext, context); err != nil || plaintext != "123-45-6789" {
+				t.Errorf("Decrypt of a %s ciphertext for %s returned %q, %v", name, context.RecordID, plaintext, err)
+			}
+		}
+
+		if !NeedsRotation(ciphertext) {
+			t.Errorf("NeedsRotation of a %s ciphertext = false, want true", name)
+		}
+	}
+
+	requireBound = true
+
+	for name, ciphertext := range unbound {
+		if _, err := Decrypt(ciphertext, ssnContext); err != ErrUnboundCiphertext {
+			t.Errorf("Decrypt of a %s ciphertext with ENCRYPTION_REQUIRE_BOUND returned %v, want %v", name, err, ErrUnboundCiphertext)
+		}
+	}
+
+	bound, err := Encrypt("123-45-6789", ssnContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plaintext, err := Decrypt(bound, ssnContext); err != nil || plaintext != "123-45-6789" {
+		t.Errorf("Decrypt of a bound ciphertext with ENCRYPTION_REQUIRE_BOUND returned %q, %v", plaintext, err)
+	}
+}
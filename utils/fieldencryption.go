@@ -0,0 +1,214 @@
+package utils
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+)
+
+const (
+	// RANDOMIZED field encryption mode, envelope encrypted and bound to the record, equal values never look alike
+	RANDOMIZED = "randomized"
+	// DETERMINISTIC field encryption mode, equal values in a column encrypt alike so they can be matched in queries
+	DETERMINISTIC = "deterministic"
+	// deterministicVersion prefixes deterministic ciphertexts, formatted as d1:<synthetic nonce || ciphertext>
+	deterministicVersion = "d1"
+)
+
+var (
+	// ErrDeterministicKey error
+	ErrDeterministicKey = errors.New("DETERMINISTIC_KEY must be a hex encoded key of at least 32 bytes")
+)
+
+// encryptedField describes a string field tagged `encrypt:"<mode>[,legacy]"`, legacy marks fields whose unprefixed values
+// are pre-envelope ciphertexts rather than plaintext waiting to be encrypted
+type encryptedField struct {
+	index  int
+	column string
+	mode   string
+	legacy bool
+}
+
+// EncryptFields function to encrypt every tagged field of a struct pointer in place. Randomized fields are bound to table, column and record ID,
+// deterministic ones to table and column only so they stay comparable across records
+func EncryptFields(record interface{}, table string, recordID string) error {
+	value := reflect.ValueOf(record).Elem()
+
+	for _, field := range encryptedFields(value.Type()) {
+		target := value.Field(field.index)
+
+		encrypted, err := encryptField(field, target.String(), table, recordID)
+		if err != nil {
+			return err
+		}
+
+		target.SetString(encrypted)
+	}
+
+	return nil
+}
+
+// DecryptFields function to decrypt every tagged field of a struct pointer in place, values not encrypted yet are left as they are
+// until ENCRYPTION_REQUIRE_BOUND=true
+func DecryptFields(record interface{}, table string, recordID string) error {
+	value := reflect.ValueOf(record).Elem()
+
+	for _, field := range encryptedFields(value.Type()) {
+		target := value.Field(field.index)
+		ciphertext := target.String()
+
+		var (
+			plaintext string
+			err       error
+		)
+
+		switch {
+		case ciphertext == "":
+			// Never written, every encrypted value including an empty one has a prefix
+		case strings.HasPrefix(ciphertext, deterministicVersion+":"):
+			plaintext, err = decryptDeterministic(ciphertext, EncryptionContext{Table: table, Column: field.column})
+		case strings.HasPrefix(ciphertext, boundVersion+":"), strings.HasPrefix(ciphertext, envelopeVersion+":"), field.legacy:
+			plaintext, err = Decrypt(ciphertext, EncryptionContext{Table: table, Column: field.column, RecordID: recordID})
+		case requireBound:
+			err = ErrUnboundCiphertext
+		default:
+			plaintext = ciphertext
+		}
+
+		if err != nil {
+			return err
+		}
+
+		target.SetString(plaintext)
+	}
+
+	return nil
+}
+
+// FieldsNeedRotation function to check if any tagged field of a struct pointer isn't encrypted in its mode under the active keys
+func FieldsNeedRotation(record interface{}) bool {
+	value := reflect.ValueOf(record).Elem()
+
+	for _, field := range encryptedFields(value.Type()) {
+		ciphertext := value.Field(field.index).String()
+
+		switch field.mode {
+		case DETERMINISTIC:
+			if !strings.HasPrefix(ciphertext, deterministicVersion+":") {
+				return true
+			}
+		default:
+			if NeedsRotation(ciphertext) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// EncryptDeterministic function to encrypt a value so it can be compared against a deterministic column
+func EncryptDeterministic(plaintext string, context EncryptionContext) (string, error) {
+	aead, macKey, err := deterministicKeys()
+	if err != nil {
+		return "", err
+	}
+
+	additionalData := context.additionalData()
+
+	// Synthetic nonce, the same plaintext in the same context always gets the same nonce and so the same ciphertext
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(additionalData)
+	mac.Write([]byte(plaintext))
+	nonce := mac.Sum(nil)[:aead.NonceSize()]
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), additionalData)
+
+	return deterministicVersion + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptDeterministic(ciphertext string, context EncryptionContext) (string, error) {
+	aead, _, err := deterministicKeys()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(ciphertext, deterministicVersion+":"))
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := open(aead, sealed, context.additionalData())
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+func encryptField(field encryptedField, plaintext string, table string, recordID string) (string, error) {
+	switch field.mode {
+	case RANDOMIZED:
+		return Encrypt(plaintext, EncryptionContext{Table: table, Column: field.column, RecordID: recordID})
+	case DETERMINISTIC:
+		return EncryptDeterministic(plaintext, EncryptionContext{Table: table, Column: field.column})
+	}
+
+	return "", errors.New("Unknown encryption mode " + field.mode + " on " + field.column)
+}
+
+// deterministicKeys derives separate encryption and nonce keys from DETERMINISTIC_KEY
+func deterministicKeys() (aead cipher.AEAD, macKey []byte, err error) {
+	key, err := hex.DecodeString(os.Getenv("DETERMINISTIC_KEY"))
+	if err != nil || len(key) < 32 {
+		return nil, nil, ErrDeterministicKey
+	}
+
+	derive := func(label string) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(label))
+		return mac.Sum(nil)
+	}
+
+	if aead, err = newAEAD(derive("encryption")); err != nil {
+		return nil, nil, err
+	}
+
+	return aead, derive("nonce"), nil
+}
+
+func encryptedFields(t reflect.Type) []encryptedField {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var fields []encryptedField
+
+	for i := 0; i < t.NumField(); i++ {
+		tag, ok := t.Field(i).Tag.Lookup("encrypt")
+		if !ok || t.Field(i).Type.Kind() != reflect.String {
+			continue
+		}
+
+		options := strings.Split(tag, ",")
+		field := encryptedField{
+			index:  i,
+			column: strings.ToLower(t.Field(i).Name),
+			mode:   options[0],
+		}
+
+		for _, option := range options[1:] {
+			field.legacy = field.legacy || option == "legacy"
+		}
+
+		fields = append(fields, field)
+	}
+
+	return fields
+}
@@ -0,0 +1,233 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+)
+
+type taggedRecord struct {
+	ID   string
+	Name string `encrypt:"randomized"`
+	Dob  string `encrypt:"deterministic"`
+	Ssn  string `encrypt:"randomized,legacy"`
+	Note string
+}
+
+var legacyKey = bytes.Repeat([]byte{3}, 32)
+
+// setEnv sets an env var for the length of the test
+func setEnv(t *testing.T, key string, value string) {
+	t.Helper()
+
+	previous, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, previous)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+// useTestKeys installs a provider holding KEK version 1 plus the deterministic and legacy keys, and puts everything back after the test
+func useTestKeys(t *testing.T) *StaticKeyProvider {
+	t.Helper()
+
+	keys, err := NewStaticKeyProvider("1", map[string][]byte{"1": bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previousKeys, previousRetired, previousLegacy, previousBound := Keys, PreviousKeys, encodedKey, requireBound
+	Keys, PreviousKeys, encodedKey, requireBound = keys, nil, hex.EncodeToString(legacyKey), false
+
+	t.Cleanup(func() {
+		Keys, PreviousKeys, encodedKey, requireBound = previousKeys, previousRetired, previousLegacy, previousBound
+	})
+
+	setEnv(t, "DETERMINISTIC_KEY", strings.Repeat("02", 32))
+	setEnv(t, "ENCRYPT_LEGACY_KEY", "")
+
+	return keys
+}
+
+// legacyEncrypt seals plaintext the way values were stored before envelope encryption
+func legacyEncrypt(t *testing.T, plaintext string) string {
+	t.Helper()
+
+	aead, err := newAEAD(legacyKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := seal(aead, []byte(plaintext), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.RawStdEncoding.EncodeToString(sealed)
+}
+
+func encryptRecord(t *testing.T, record taggedRecord) taggedRecord {
+	t.Helper()
+
+	if err := EncryptFields(&record, "record", record.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	return record
+}
+
+func TestEncryptFieldsRoundTrip(t *testing.T) {
+	useTestKeys(t)
+
+	for _, plain := range []taggedRecord{
+		{ID: "r1", Name: "Ada Lovelace", Dob: "1815-12-10", Ssn: "123-45-6789", Note: "untagged"},
+		{ID: "r2", Name: "", Dob: "", Ssn: "", Note: ""},
+		{ID: "r3", Name: "Zoë ☃", Dob: "1906-12-09", Ssn: "987-65-4321"},
+	} {
+		encrypted := encryptRecord(t, plain)
+
+		if !strings.HasPrefix(encrypted.Name, boundVersion+":") || !strings.HasPrefix(encrypted.Ssn, boundVersion+":") {
+			t.Errorf("randomized fields of %s encrypted to %q and %q, want %s ciphertexts", plain.ID, encrypted.Name, encrypted.Ssn, boundVersion)
+		}
+
+		if !strings.HasPrefix(encrypted.Dob, deterministicVersion+":") {
+			t.Errorf("deterministic field of %s encrypted to %q, want a %s ciphertext", plain.ID, encrypted.Dob, deterministicVersion)
+		}
+
+		if encrypted.ID != plain.ID || encrypted.Note != plain.Note {
+			t.Errorf("untagged fields of %s changed to %+v", plain.ID, encrypted)
+		}
+
+		decrypted := encrypted
+		if err := DecryptFields(&decrypted, "record", plain.ID); err != nil {
+			t.Fatalf("DecryptFields of %s returned %v", plain.ID, err)
+		}
+
+		if decrypted != plain {
+			t.Errorf("round trip of %s returned %+v, want %+v", plain.ID, decrypted, plain)
+		}
+	}
+}
+
+func TestEncryptFieldsModes(t *testing.T) {
+	useTestKeys(t)
+
+	first := encryptRecord(t, taggedRecord{ID: "r1", Name: "Ada", Dob: "1815-12-10"})
+	second := encryptRecord(t, taggedRecord{ID: "r2", Name: "Ada", Dob: "1815-12-10"})
+	again := encryptRecord(t, taggedRecord{ID: "r1", Name: "Ada", Dob: "1815-12-10"})
+
+	if first.Dob != second.Dob || first.Dob != again.Dob {
+		t.Errorf("deterministic encryptions of one value differ: %q, %q and %q", first.Dob, second.Dob, again.Dob)
+	}
+
+	if first.Name == second.Name || first.Name == again.Name {
+		t.Errorf("randomized encryptions of one value are equal: %q, %q and %q", first.Name, second.Name, again.Name)
+	}
+
+	if other := encryptRecord(t, taggedRecord{ID: "r1", Dob: "1815-12-11"}); other.Dob == first.Dob {
+		t.Errorf("deterministic encryptions of different values are equal: %q", other.Dob)
+	}
+
+	// Filters match the column by encrypting the value they look for
+	if filter, err := EncryptDeterministic("1815-12-10", EncryptionContext{Table: "record", Column: "dob"}); err != nil || filter != first.Dob {
+		t.Errorf("EncryptDeterministic returned %q, %v, want the stored %q", filter, err, first.Dob)
+	}
+
+	if filter, err := EncryptDeterministic("1815-12-10", EncryptionContext{Table: "record", Column: "other"}); err != nil || filter == first.Dob {
+		t.Errorf("EncryptDeterministic for another column returned %q, %v, want a different ciphertext", filter, err)
+	}
+
+	// Randomized values only decrypt for the record they were encrypted for
+	moved := first
+	if err := DecryptFields(&moved, "record", "r2"); err == nil {
+		t.Errorf("DecryptFields of r1's fields as r2 returned %+v, want an error", moved)
+	}
+
+	setEnv(t, "DETERMINISTIC_KEY", "")
+
+	if err := EncryptFields(&taggedRecord{ID: "r1"}, "record", "r1"); err != ErrDeterministicKey {
+		t.Errorf("EncryptFields without DETERMINISTIC_KEY returned %v, want %v", err, ErrDeterministicKey)
+	}
+}
+
+func TestDecryptFieldsUnencryptedValues(t *testing.T) {
+	useTestKeys(t)
+
+	legacySsn := legacyEncrypt(t, "123-45-6789")
+
+	// Values written before their field was encrypted read as they are, except on legacy fields where they're old ciphertexts
+	record := taggedRecord{ID: "r1", Name: "Ada", Dob: "1815-12-10", Ssn: legacySsn}
+	if err := DecryptFields(&record, "record", "r1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (taggedRecord{ID: "r1", Name: "Ada", Dob: "1815-12-10", Ssn: "123-45-6789"}); record != want {
+		t.Errorf("DecryptFields of unencrypted values returned %+v, want %+v", record, want)
+	}
+
+	// A legacy field never holds plaintext, so one that isn't a valid ciphertext is an error rather than a value
+	if err := DecryptFields(&taggedRecord{ID: "r1", Ssn: "123-45-6789"}, "record", "r1"); err == nil {
+		t.Error("DecryptFields of plaintext in a legacy field succeeded, want an error")
+	}
+
+	requireBound = true
+
+	for name, record := range map[string]taggedRecord{
+		"plaintext":         {ID: "r1", Name: "Ada"},
+		"legacy ciphertext": {ID: "r1", Ssn: legacySsn},
+	} {
+		if err := DecryptFields(&record, "record", "r1"); err != ErrUnboundCiphertext {
+			t.Errorf("DecryptFields of %s with ENCRYPTION_REQUIRE_BOUND returned %v, want %v", name, err, ErrUnboundCiphertext)
+		}
+	}
+
+	bound := encryptRecord(t, taggedRecord{ID: "r1", Name: "Ada", Dob: "1815-12-10", Ssn: "123-45-6789"})
+	if err := DecryptFields(&bound, "record", "r1"); err != nil {
+		t.Errorf("DecryptFields of bound values with ENCRYPTION_REQUIRE_BOUND returned %v", err)
+	}
+}
+
+func TestFieldsNeedRotation(t *testing.T) {
+	keys := useTestKeys(t)
+
+	current := encryptRecord(t, taggedRecord{ID: "r1", Name: "Ada", Dob: "1815-12-10", Ssn: "123-45-6789"})
+
+	tests := []struct {
+		name   string
+		record taggedRecord
+		want   bool
+	}{
+		{"current", current, false},
+		{"plaintext randomized field", taggedRecord{Name: "Ada", Dob: current.Dob, Ssn: current.Ssn}, true},
+		{"plaintext deterministic field", taggedRecord{Name: current.Name, Dob: "1815-12-10", Ssn: current.Ssn}, true},
+		{"legacy ciphertext", taggedRecord{Name: current.Name, Dob: current.Dob, Ssn: legacyEncrypt(t, "123-45-6789")}, true},
+		{"randomized field holding a deterministic ciphertext", taggedRecord{Name: current.Dob, Dob: current.Dob, Ssn: current.Ssn}, true},
+	}
+
+	for _, test := range tests {
+		record := test.record
+		if got := FieldsNeedRotation(&record); got != test.want {
+			t.Errorf("FieldsNeedRotation of the %s record = %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	// Once another KEK is active, everything wrapped by the old one needs rotating
+	rotated, err := NewStaticKeyProvider("2", map[string][]byte{"1": bytes.Repeat([]byte{1}, 32), "2": bytes.Repeat([]byte{2}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Keys = rotated
+
+	if !FieldsNeedRotation(&current) {
+		t.Errorf("FieldsNeedRotation after %s was replaced as the active KEK = false, want true", keys.Active())
+	}
+}
@@ -0,0 +1,338 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// ENV key provider type, KEKs come from ENCRYPT_KEY and ENCRYPT_PREVIOUS_KEYS
+	ENV = "env"
+	// FILE key provider type, KEKs come from the JSON file at KEK_FILE
+	FILE = "file"
+	// KMS key provider type, data keys are wrapped by the local KMS stand-in
+	KMS = "kms"
+)
+
+var (
+	// ErrUnknownKeyVersion error
+	ErrUnknownKeyVersion = errors.New("Unknown key encryption key version")
+	// ErrInvalidKeyVersion error
+	ErrInvalidKeyVersion = errors.New("Key encryption key versions can't be empty or contain ':'")
+	// Keys global var, the provider wrapping data keys for Encrypt and Decrypt
+	Keys KeyProvider
+	// PreviousKeys global var, a retired provider Decrypt falls back to for KEK versions Keys doesn't know
+	PreviousKeys KeyProvider
+)
+
+// KeyProvider interface for the key encryption keys (KEKs) wrapping per-record data keys
+type KeyProvider interface {
+	// Wrap encrypts a data key with the active KEK and returns that KEK's version
+	Wrap(dataKey []byte) (version string, wrapped []byte, err error)
+	// Unwrap decrypts a data key wrapped by the given KEK version, returns ErrUnknownKeyVersion for versions it doesn't hold
+	Unwrap(version string, wrapped []byte) ([]byte, error)
+	// Active returns the KEK version new data keys are wrapped by
+	Active() string
+}
+
+// StaticKeyProvider type holding its KEKs in memory, new data keys are wrapped by the active one and older ones only unwrap
+type StaticKeyProvider struct {
+	active string
+	keks   map[string]cipher.AEAD
+}
+
+// KekFile type, format of the KEK_FILE JSON document
+type KekFile struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"`
+}
+
+// NewStaticKeyProvider function to create a provider from 32 byte KEKs by version
+func NewStaticKeyProvider(active string, keys map[string][]byte) (*StaticKeyProvider, error) {
+	p := &StaticKeyProvider{
+		active: active,
+		keks:   map[string]cipher.AEAD{},
+	}
+
+	for version, key := range keys {
+		if version == "" || strings.Contains(version, ":") {
+			return nil, ErrInvalidKeyVersion
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+
+		p.keks[version] = aead
+	}
+
+	if _, ok := p.keks[active]; !ok {
+		return nil, ErrUnknownKeyVersion
+	}
+
+	return p, nil
+}
+
+// Wrap function to encrypt a data key with the active KEK
+func (p *StaticKeyProvider) Wrap(dataKey []byte) (string, []byte, error) {
+	wrapped, err := seal(p.keks[p.active], dataKey, nil)
+
+	return p.active, wrapped, err
+}
+
+// Unwrap function to decrypt a data key with the KEK of the given version
+func (p *StaticKeyProvider) Unwrap(version string, wrapped []byte) ([]byte, error) {
+	kek, ok := p.keks[version]
+	if !ok {
+		return nil, ErrUnknownKeyVersion
+	}
+
+	return open(kek, wrapped, nil)
+}
+
+// Active function to get the version of the KEK wrapping new data keys
+func (p *StaticKeyProvider) Active() string {
+	return p.active
+}
+
+// KMSKeyProvider type wrapping data keys through a KMS, the KEK never leaves it. Versions are <key ID>/<key version>, so data keys
+// wrapped before the KMS key was rotated report as needing rotation while the KMS keeps unwrapping them
+type KMSKeyProvider struct {
+	kms   KMSClient
+	keyID string
+}
+
+// KMSClient interface for the subset of a KMS API envelope encryption needs
+type KMSClient interface {
+	Encrypt(keyID string, plaintext []byte) ([]byte, error)
+	Decrypt(keyID string, ciphertext []byte) ([]byte, error)
+	// KeyVersion returns the version of the key Encrypt currently uses
+	KeyVersion(keyID string) (int, error)
+}
+
+// NewKMSKeyProvider function to create a provider wrapping data keys with the given KMS key
+func NewKMSKeyProvider(kms KMSClient, keyID string) (*KMSKeyProvider, error) {
+	if keyID == "" || strings.Contains(keyID, ":") {
+		return nil, ErrInvalidKeyVersion
+	}
+
+	return &KMSKeyProvider{kms: kms, keyID: keyID}, nil
+}
+
+// Wrap function to have the KMS encrypt a data key. The key version is read first, a rotation in between only labels the data key
+// older than it is and gets it re-wrapped sooner
+func (p *KMSKeyProvider) Wrap(dataKey []byte) (string, []byte, error) {
+	version, err := p.kms.KeyVersion(p.keyID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	wrapped, err := p.kms.Encrypt(p.keyID, dataKey)
+
+	return p.keyID + "/" + strconv.Itoa(version), wrapped, err
+}
+
+// Unwrap function to have the KMS decrypt a data key, versions without a key version suffix were wrapped before they were labelled
+func (p *KMSKeyProvider) Unwrap(version string, wrapped []byte) ([]byte, error) {
+	keyID := version
+	if i := strings.LastIndex(version, "/"); i >= 0 {
+		keyID = version[:i]
+	}
+
+	dataKey, err := p.kms.Decrypt(keyID, wrapped)
+	if err == ErrKMSKeyNotFound {
+		return nil, ErrUnknownKeyVersion
+	}
+
+	return dataKey, err
+}
+
+// Active function to get the KMS key and key version wrapping new data keys. When the KMS can't be asked it's the bare key ID,
+// which no data key is labelled with, so everything reports as needing rotation rather than nothing
+func (p *KMSKeyProvider) Active() string {
+	version, err := p.kms.KeyVersion(p.keyID)
+	if err != nil {
+		return p.keyID
+	}
+
+	return p.keyID + "/" + strconv.Itoa(version)
+}
+
+// SetupKeyProvider function to build the KeyProvider selected by KEY_PROVIDER (env, file or kms),
+// and the one selected by PREVIOUS_KEY_PROVIDER when moving data from one provider to another
+func SetupKeyProvider() error {
+	providerType := strings.ToLower(strings.TrimSpace(os.Getenv("KEY_PROVIDER")))
+	if providerType == "" {
+		providerType = ENV
+	}
+
+	// Deterministic fields are encrypted under their own key, fail at startup rather than on the first write
+	if _, _, err := deterministicKeys(); err != nil {
+		return err
+	}
+
+	var err error
+
+	if Keys, err = newKeyProvider(providerType); err != nil {
+		return err
+	}
+
+	previousType := strings.ToLower(strings.TrimSpace(os.Getenv("PREVIOUS_KEY_PROVIDER")))
+	if previousType != "" && previousType != providerType {
+		if PreviousKeys, err = newKeyProvider(previousType); err != nil {
+			return err
+		}
+	}
+
+	log.Info().Str("provider", providerType).Str("previous", previousType).Str("active", Keys.Active()).Msg("Key provider ready")
+
+	return nil
+}
+
+func newKeyProvider(providerType string) (KeyProvider, error) {
+	switch providerType {
+	case ENV:
+		return envKeyProvider()
+	case FILE:
+		return fileKeyProvider(os.Getenv("KEK_FILE"))
+	case KMS:
+		return kmsKeyProvider()
+	}
+
+	return nil, errors.New("Unknown key provider " + providerType)
+}
+
+// envKeyProvider uses ENCRYPT_KEY as the active KEK, versioned by ENCRYPT_KEY_VERSION, and ENCRYPT_PREVIOUS_KEYS (version=hex,...) for the ones it replaced
+func envKeyProvider() (*StaticKeyProvider, error) {
+	active := os.Getenv("ENCRYPT_KEY_VERSION")
+	if active == "" {
+		active = "1"
+	}
+
+	keys := map[string][]byte{}
+
+	key, err := hex.DecodeString(encodedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keys[active] = key
+
+	for _, entry := range strings.Split(os.Getenv("ENCRYPT_PREVIOUS_KEYS"), ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("ENCRYPT_PREVIOUS_KEYS entries must be formatted as version=hexkey")
+		}
+
+		if keys[parts[0]], err = hex.DecodeString(parts[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewStaticKeyProvider(active, keys)
+}
+
+func fileKeyProvider(path string) (*StaticKeyProvider, error) {
+	if path == "" {
+		return nil, errors.New("KEK_FILE must be set when KEY_PROVIDER is file")
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file KekFile
+
+	if err = json.Unmarshal(raw, &file); err != nil {
+		return nil, err
+	}
+
+	keys := map[string][]byte{}
+
+	for version, encoded := range file.Keys {
+		if keys[version], err = hex.DecodeString(encoded); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewStaticKeyProvider(file.Active, keys)
+}
+
+// KMSKeystore function to read the local KMS keystore path from the KMS_KEYSTORE env var
+func KMSKeystore() string {
+	return envOrDefault("KMS_KEYSTORE", DefaultKMSKeystore)
+}
+
+// KMSKeyID function to read the KMS key wrapping data keys from the KMS_KEY_ID env var
+func KMSKeyID() string {
+	return envOrDefault("KMS_KEY_ID", DefaultKMSKeyID)
+}
+
+func kmsKeyProvider() (*KMSKeyProvider, error) {
+	kms, err := OpenLocalKMS(KMSKeystore())
+	if err != nil {
+		return nil, err
+	}
+
+	keyID := KMSKeyID()
+
+	if err = kms.EnsureKey(keyID); err != nil {
+		return nil, err
+	}
+
+	return NewKMSKeyProvider(kms, keyID)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts with a random nonce, returning nonce || ciphertext
+func seal(aead cipher.AEAD, plaintext []byte, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open reverses seal, failing unless additionalData matches what was sealed with
+func open(aead cipher.AEAD, sealed []byte, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("Ciphertext too short")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func envOrDefault(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	return fallback
+}
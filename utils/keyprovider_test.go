@@ -0,0 +1,243 @@
+package utils
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewStaticKeyProvider(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+
+	tests := []struct {
+		name   string
+		active string
+		keys   map[string][]byte
+		want   error
+	}{
+		{"an empty version", "", map[string][]byte{"": key}, ErrInvalidKeyVersion},
+		{"a version containing ':'", "1:2", map[string][]byte{"1:2": key}, ErrInvalidKeyVersion},
+		{"an active version it doesn't hold", "2", map[string][]byte{"1": key}, ErrUnknownKeyVersion},
+		{"no keys", "1", nil, ErrUnknownKeyVersion},
+		{"a short key", "1", map[string][]byte{"1": key[:7]}, nil},
+	}
+
+	for _, test := range tests {
+		if _, err := NewStaticKeyProvider(test.active, test.keys); err == nil || (test.want != nil && err != test.want) {
+			t.Errorf("NewStaticKeyProvider with %s returned %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestStaticKeyProviderWrap(t *testing.T) {
+	dataKey := bytes.Repeat([]byte{7}, 32)
+
+	old, err := NewStaticKeyProvider("1", map[string][]byte{"1": bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, wrapped, err := old.Wrap(dataKey)
+	if err != nil || version != "1" {
+		t.Fatalf("Wrap returned version %q, %v, want 1", version, err)
+	}
+
+	if bytes.Contains(wrapped, dataKey) {
+		t.Error("Wrap returned the data key in the clear")
+	}
+
+	rotated, err := NewStaticKeyProvider("2", map[string][]byte{"1": bytes.Repeat([]byte{1}, 32), "2": bytes.Repeat([]byte{2}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if unwrapped, err := rotated.Unwrap(version, wrapped); err != nil || !bytes.Equal(unwrapped, dataKey) {
+		t.Errorf("Unwrap under an inactive version returned %x, %v, want %x", unwrapped, err, dataKey)
+	}
+
+	if version, _, _ = rotated.Wrap(dataKey); version != "2" || rotated.Active() != "2" {
+		t.Errorf("Wrap after rotation used version %q with %q active, want 2", version, rotated.Active())
+	}
+
+	if _, err = rotated.Unwrap("3", wrapped); err != ErrUnknownKeyVersion {
+		t.Errorf("Unwrap under an unknown version returned %v, want %v", err, ErrUnknownKeyVersion)
+	}
+
+	if _, err = rotated.Unwrap("2", wrapped); err == nil {
+		t.Error("Unwrap under the wrong version succeeded, want an error")
+	}
+}
+
+func TestEnvKeyProvider(t *testing.T) {
+	keys := useTestKeys(t)
+
+	encodedKey = strings.Repeat("02", 32)
+	setEnv(t, "ENCRYPT_KEY_VERSION", "2")
+	setEnv(t, "ENCRYPT_PREVIOUS_KEYS", " 1="+strings.Repeat("01", 32)+", ")
+
+	provider, err := envKeyProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, wrapped, err := keys.Wrap(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = provider.Unwrap("1", wrapped); err != nil || provider.Active() != "2" {
+		t.Errorf("provider from the env has %q active and unwraps version 1 with %v, want 2 active and the previous key kept", provider.Active(), err)
+	}
+
+	for _, previous := range []string{"1", "1=zz", "=" + strings.Repeat("01", 32)} {
+		setEnv(t, "ENCRYPT_PREVIOUS_KEYS", previous)
+
+		if _, err = envKeyProvider(); err == nil {
+			t.Errorf("envKeyProvider with ENCRYPT_PREVIOUS_KEYS=%q succeeded, want an error", previous)
+		}
+	}
+}
+
+func TestFileKeyProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keks.json")
+	document := `{"active": "b", "keys": {"a": "` + strings.Repeat("01", 32) + `", "b": "` + strings.Repeat("02", 32) + `"}}`
+
+	if err := ioutil.WriteFile(path, []byte(document), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	provider, err := fileKeyProvider(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if provider.Active() != "b" || len(provider.keks) != 2 {
+		t.Errorf("provider from %s has %q active and %d keys, want b and 2", document, provider.Active(), len(provider.keks))
+	}
+
+	for _, path := range []string{"", filepath.Join(t.TempDir(), "missing.json")} {
+		if _, err = fileKeyProvider(path); err == nil {
+			t.Errorf("fileKeyProvider(%q) succeeded, want an error", path)
+		}
+	}
+}
+
+func TestKMSKeyProvider(t *testing.T) {
+	kms := newTestKMS(t)
+	dataKey := bytes.Repeat([]byte{7}, 32)
+
+	for _, keyID := range []string{"", "a:b"} {
+		if _, err := NewKMSKeyProvider(kms, keyID); err != ErrInvalidKeyVersion {
+			t.Errorf("NewKMSKeyProvider(%q) returned %v, want %v", keyID, err, ErrInvalidKeyVersion)
+		}
+	}
+
+	provider, err := NewKMSKeyProvider(kms, "k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, wrapped, err := provider.Wrap(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if unwrapped, err := provider.Unwrap(version, wrapped); err != nil || !bytes.Equal(unwrapped, dataKey) {
+		t.Errorf("Unwrap returned %x, %v, want %x", unwrapped, err, dataKey)
+	}
+
+	if _, err = provider.Unwrap("k2", wrapped); err != ErrUnknownKeyVersion {
+		t.Errorf("Unwrap with a key the KMS doesn't hold returned %v, want %v", err, ErrUnknownKeyVersion)
+	}
+}
+
+func TestSetupKeyProvider(t *testing.T) {
+	useTestKeys(t)
+
+	keystore := filepath.Join(t.TempDir(), "kms.json")
+	setEnv(t, "KMS_KEYSTORE", keystore)
+	setEnv(t, "KMS_KEY_ID", "k1")
+	setEnv(t, "KEY_PROVIDER", "KMS")
+	setEnv(t, "PREVIOUS_KEY_PROVIDER", "env")
+	setEnv(t, "ENCRYPT_KEY_VERSION", "")
+	setEnv(t, "ENCRYPT_PREVIOUS_KEYS", "")
+
+	if err := SetupKeyProvider(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := Keys.(*KMSKeyProvider); !ok || PreviousKeys == nil || PreviousKeys.Active() != "1" {
+		t.Errorf("SetupKeyProvider set up %T with %v as the previous provider, want the KMS with the env keys", Keys, PreviousKeys)
+	}
+
+	setEnv(t, "KEY_PROVIDER", "vault")
+
+	if err := SetupKeyProvider(); err == nil {
+		t.Error("SetupKeyProvider with an unknown provider succeeded, want an error")
+	}
+
+	// Deterministic fields can't be written without their key, so it's required before anything starts
+	setEnv(t, "KEY_PROVIDER", "")
+	setEnv(t, "DETERMINISTIC_KEY", "0102")
+
+	if err := SetupKeyProvider(); err != ErrDeterministicKey {
+		t.Errorf("SetupKeyProvider with a short DETERMINISTIC_KEY returned %v, want %v", err, ErrDeterministicKey)
+	}
+}
+
+func TestKMSKeyRotation(t *testing.T) {
+	useTestKeys(t)
+
+	kms := newTestKMS(t)
+
+	provider, err := NewKMSKeyProvider(kms, "k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Keys = provider
+
+	before, err := Encrypt("123-45-6789", ssnContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(before, boundVersion+":k1/1:") || NeedsRotation(before) {
+		t.Errorf("ciphertext %q needs rotation = %v, want it wrapped by k1/1 and up to date", before, NeedsRotation(before))
+	}
+
+	if _, err = kms.RotateKey("k1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if provider.Active() != "k1/2" {
+		t.Errorf("Active after the KMS key was rotated = %q, want k1/2", provider.Active())
+	}
+
+	if plaintext, err := Decrypt(before, ssnContext); err != nil || plaintext != "123-45-6789" {
+		t.Errorf("Decrypt of a ciphertext from before the rotation returned %q, %v", plaintext, err)
+	}
+
+	if !NeedsRotation(before) {
+		t.Error("NeedsRotation of a ciphertext from before the rotation = false, want true")
+	}
+
+	// Data keys wrapped before versions were labelled name the bare key ID
+	parts := strings.Split(before, ":")
+	unlabelled := strings.Join([]string{parts[0], "k1", parts[2], parts[3]}, ":")
+
+	if plaintext, err := Decrypt(unlabelled, ssnContext); err != nil || plaintext != "123-45-6789" || !NeedsRotation(unlabelled) {
+		t.Errorf("Decrypt of an unlabelled ciphertext returned %q, %v and needs rotation = %v, want it readable and due for rotation", plaintext, err, NeedsRotation(unlabelled))
+	}
+
+	after, err := Encrypt("123-45-6789", ssnContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(after, boundVersion+":k1/2:") || NeedsRotation(after) {
+		t.Errorf("ciphertext %q after the rotation needs rotation = %v, want it wrapped by k1/2 and up to date", after, NeedsRotation(after))
+	}
+}
@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+const (
+	// DefaultKMSKeystore used when KMS_KEYSTORE is not set
+	DefaultKMSKeystore = "kms.json"
+	// DefaultKMSKeyID used when KMS_KEY_ID is not set
+	DefaultKMSKeyID = "cybersecurity"
+)
+
+// ErrKMSKeyNotFound error
+var ErrKMSKeyNotFound = errors.New("KMS key not found")
+
+// LocalKMS type standing in for a managed KMS during development, master keys live in a JSON keystore and never leave this type.
+// Each key keeps every version it was rotated through, ciphertexts name the version that produced them
+type LocalKMS struct {
+	mu   sync.Mutex
+	path string
+	keys map[string][]string
+}
+
+// OpenLocalKMS function to load the keystore at path, a missing file starts an empty keystore
+func OpenLocalKMS(path string) (*LocalKMS, error) {
+	k := &LocalKMS{
+		path: path,
+		keys: map[string][]string{},
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return k, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(raw, &k.keys); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// EnsureKey function to create a key with a first version unless it already exists
+func (k *LocalKMS) EnsureKey(keyID string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if len(k.keys[keyID]) > 0 {
+		return nil
+	}
+
+	return k.addVersion(keyID)
+}
+
+// RotateKey function to add a new version to a key, it encrypts from now on while older versions keep decrypting
+func (k *LocalKMS) RotateKey(keyID string) (int, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if len(k.keys[keyID]) == 0 {
+		return 0, ErrKMSKeyNotFound
+	}
+
+	if err := k.addVersion(keyID); err != nil {
+		return 0, err
+	}
+
+	return len(k.keys[keyID]), nil
+}
+
+// KeyVersion function to get the latest version of a key, the one Encrypt uses
+func (k *LocalKMS) KeyVersion(keyID string) (int, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if len(k.keys[keyID]) == 0 {
+		return 0, ErrKMSKeyNotFound
+	}
+
+	return len(k.keys[keyID]), nil
+}
+
+// Encrypt function to encrypt with the latest version of a key, returns version || nonce || ciphertext
+func (k *LocalKMS) Encrypt(keyID string, plaintext []byte) ([]byte, error) {
+	k.mu.Lock()
+	versions := k.keys[keyID]
+	k.mu.Unlock()
+
+	if len(versions) == 0 {
+		return nil, ErrKMSKeyNotFound
+	}
+
+	aead, err := k.version(versions, len(versions))
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := seal(aead, plaintext, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	blob := make([]byte, 4, 4+len(sealed))
+	binary.BigEndian.PutUint32(blob, uint32(len(versions)))
+
+	return append(blob, sealed...), nil
+}
+
+// Decrypt function to decrypt with whichever version of a key produced the ciphertext
+func (k *LocalKMS) Decrypt(keyID string, blob []byte) ([]byte, error) {
+	if len(blob) < 4 {
+		return nil, errors.New("Ciphertext too short")
+	}
+
+	k.mu.Lock()
+	versions := k.keys[keyID]
+	k.mu.Unlock()
+
+	aead, err := k.version(versions, int(binary.BigEndian.Uint32(blob)))
+	if err != nil {
+		return nil, err
+	}
+
+	return open(aead, blob[4:], nil)
+}
+
+func (k *LocalKMS) version(versions []string, version int) (cipher.AEAD, error) {
+	if version < 1 || version > len(versions) {
+		return nil, ErrKMSKeyNotFound
+	}
+
+	key, err := hex.DecodeString(versions[version-1])
+	if err != nil {
+		return nil, err
+	}
+
+	return newAEAD(key)
+}
+
+// addVersion generates a key version and persists the keystore, callers hold mu
+func (k *LocalKMS) addVersion(keyID string) error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+
+	k.keys[keyID] = append(k.keys[keyID], hex.EncodeToString(key))
+
+	raw, err := json.MarshalIndent(k.keys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(k.path, raw, 0600)
+}
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestKMS opens an empty keystore holding key k1
+func newTestKMS(t *testing.T) *LocalKMS {
+	t.Helper()
+
+	kms, err := OpenLocalKMS(filepath.Join(t.TempDir(), "kms.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = kms.EnsureKey("k1"); err != nil {
+		t.Fatal(err)
+	}
+
+	return kms
+}
+
+func TestLocalKMS(t *testing.T) {
+	kms := newTestKMS(t)
+	plaintext := []byte("data key")
+
+	blob, err := kms.Encrypt("k1", plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decrypted, err := kms.Decrypt("k1", blob); err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("Decrypt returned %q, %v, want %q", decrypted, err, plaintext)
+	}
+
+	if err = kms.EnsureKey("k1"); err != nil || len(kms.keys["k1"]) != 1 {
+		t.Errorf("EnsureKey of an existing key returned %v with %d versions, want it left alone", err, len(kms.keys["k1"]))
+	}
+
+	if _, err = kms.Encrypt("k2", plaintext); err != ErrKMSKeyNotFound {
+		t.Errorf("Encrypt with a missing key returned %v, want %v", err, ErrKMSKeyNotFound)
+	}
+
+	if _, err = kms.Decrypt("k2", blob); err != ErrKMSKeyNotFound {
+		t.Errorf("Decrypt with a missing key returned %v, want %v", err, ErrKMSKeyNotFound)
+	}
+
+	if _, err = kms.Decrypt("k1", blob[:3]); err == nil {
+		t.Error("Decrypt of a truncated blob succeeded, want an error")
+	}
+
+	if _, err = kms.RotateKey("k2"); err != ErrKMSKeyNotFound {
+		t.Errorf("RotateKey of a missing key returned %v, want %v", err, ErrKMSKeyNotFound)
+	}
+}
+
+func TestLocalKMSRotateKey(t *testing.T) {
+	kms := newTestKMS(t)
+	plaintext := []byte("data key")
+
+	before, err := kms.Encrypt("k1", plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version, err := kms.RotateKey("k1"); err != nil || version != 2 {
+		t.Fatalf("RotateKey returned %d, %v, want version 2", version, err)
+	}
+
+	after, err := kms.Encrypt("k1", plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if after[3] != 2 {
+		t.Errorf("Encrypt after RotateKey used version %d, want 2", after[3])
+	}
+
+	// The keystore is saved on every change, a reopened KMS still decrypts with both versions
+	reopened, err := OpenLocalKMS(kms.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, blob := range [][]byte{before, after} {
+		if decrypted, err := reopened.Decrypt("k1", blob); err != nil || !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("Decrypt of a version %d blob returned %q, %v, want %q", blob[3], decrypted, err, plaintext)
+		}
+	}
+
+	if info, err := os.Stat(kms.path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("keystore is %v, %v, want it readable by its owner only", info.Mode(), err)
+	}
+
+	unknown := append([]byte{0, 0, 0, 3}, after[4:]...)
+	if _, err = reopened.Decrypt("k1", unknown); err != ErrKMSKeyNotFound {
+		t.Errorf("Decrypt of a blob from a version that doesn't exist returned %v, want %v", err, ErrKMSKeyNotFound)
+	}
+}
@@ -0,0 +1,16 @@
+package utils
+
+// MaskSsn function to hide every digit of an SSN but the last four, eg. ***-**-1234. Empty when there's no SSN on file,
+// so an absent one isn't shown as hidden
+func MaskSsn(ssn string) string {
+	digits := digitsOnly(ssn)
+	if digits == "" {
+		return ""
+	}
+
+	if len(digits) < 4 {
+		return "***-**-****"
+	}
+
+	return "***-**-" + digits[len(digits)-4:]
+}
@@ -0,0 +1,23 @@
+package utils
+
+import "testing"
+
+func TestMaskSsn(t *testing.T) {
+	tests := []struct {
+		ssn  string
+		want string
+	}{
+		{"123-45-6789", "***-**-6789"},
+		{"123456789", "***-**-6789"},
+		{" 123 45 6789 ", "***-**-6789"},
+		{"12", "***-**-****"},
+		{"", ""},
+		{"n/a", ""},
+	}
+
+	for _, test := range tests {
+		if got := MaskSsn(test.ssn); got != test.want {
+			t.Errorf("MaskSsn(%q) = %q, want %q", test.ssn, got, test.want)
+		}
+	}
+}